@@ -2,6 +2,7 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
 	"monkey/token"
 	"strings"
 )
@@ -54,6 +55,8 @@ type LetStatement struct {
 	Token token.Token // the token.LET token
 	Name  *Identifier
 	Value Expression
+	// Pattern 分割代入 let [a, b] = x; のパターン. 設定されている場合Nameはnil
+	Pattern Pattern
 }
 
 func (ls *LetStatement) statementNode() {}
@@ -65,7 +68,11 @@ func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -77,6 +84,89 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// ExportStatement export let文・export fn文・export struct文. モジュールの外から参照できる変数を宣言する
+type ExportStatement struct {
+	Token token.Token // the token.EXPORT token
+	// Statement *LetStatement, *FunctionStatement, *StructStatementのいずれか
+	Statement Statement
+	// Name exportする変数の名前
+	Name *Identifier
+}
+
+func (es *ExportStatement) statementNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (es *ExportStatement) TokenLiteral() string { return es.Token.Literal }
+
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+
+// FunctionStatement 関数宣言 fn name(x) { ... }
+// 宣言したブロックの先頭で変数に代入されるので, 宣言より前の文からも呼び出せる
+type FunctionStatement struct {
+	Token    token.Token // the token.FUNCTION token
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (fs *FunctionStatement) statementNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+
+func (fs *FunctionStatement) String() string { return fs.Function.String() }
+
+// FunctionDeclaration stmtが関数宣言(export fnを含む)であれば, その宣言を返す
+func FunctionDeclaration(stmt Statement) (*FunctionStatement, bool) {
+	if export, ok := stmt.(*ExportStatement); ok {
+		stmt = export.Statement
+	}
+	fs, ok := stmt.(*FunctionStatement)
+	return fs, ok
+}
+
+// StructStatement struct宣言 struct Point { x, y }. 宣言した名前の変数に構造体の型を代入する
+type StructStatement struct {
+	Token  token.Token // the token.STRUCT token
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+func (ss *StructStatement) statementNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (ss *StructStatement) TokenLiteral() string { return ss.Token.Literal }
+
+func (ss *StructStatement) String() string {
+	fields := []string{}
+	for _, f := range ss.Fields {
+		fields = append(fields, f.String())
+	}
+	return ss.TokenLiteral() + " " + ss.Name.String() + " { " + strings.Join(fields, ", ") + " }"
+}
+
+// ImplStatement impl Point { fn norm(self) { ... } }. 構造体の型にメソッドを追加する
+// メソッドのFunction.Nameはスタックトレース用に "Point.norm" とする
+type ImplStatement struct {
+	Token   token.Token // the token.IMPL token
+	Name    *Identifier
+	Methods []*FunctionStatement
+}
+
+func (is *ImplStatement) statementNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (is *ImplStatement) TokenLiteral() string { return is.Token.Literal }
+
+func (is *ImplStatement) String() string {
+	methods := []string{}
+	for _, m := range is.Methods {
+		methods = append(methods, m.String())
+	}
+	return is.TokenLiteral() + " " + is.Name.String() + " { " + strings.Join(methods, " ") + " }"
+}
+
 // ReturnStatement return文
 type ReturnStatement struct {
 	Token       token.Token // the token.RETURN token
@@ -101,6 +191,21 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement throw文. 値を例外として投げる
+type ThrowStatement struct {
+	Token token.Token // the token.THROW token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
 // ExpressionStatement 式
 type ExpressionStatement struct {
 	Token      token.Token // the first token of the expression
@@ -238,6 +343,177 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// TryExpression try { } catch (e) { } finally { }
+// catchとfinallyはどちらか一方を省略できる. 値はtryまたはcatchのブロックの値
+type TryExpression struct {
+	Token      token.Token // the token.TRY token
+	Block      *BlockStatement
+	CatchParam *Identifier
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+}
+
+func (te *TryExpression) expressionNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch (")
+		out.WriteString(te.CatchParam.String())
+		out.WriteString(") ")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+// MatchExpression match x { 0 => a, [y, z] => b, _ if c => d }
+// 上から順にパターンを試し, 最初にマッチしたアームの値になる
+type MatchExpression struct {
+	Token   token.Token // the token.MATCH token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+func (me *MatchExpression) expressionNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	var arms []string
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+	return "match " + me.Subject.String() + " { " + strings.Join(arms, ", ") + " }"
+}
+
+// Warning どの値にもマッチするアーム(ガードの無い_・変数)が無い場合の警告. 有る場合は空文字列
+func (me *MatchExpression) Warning() string {
+	for _, arm := range me.Arms {
+		switch arm.Pattern.(type) {
+		case *WildcardPattern, *BindingPattern:
+			if arm.Guard == nil {
+				return ""
+			}
+		}
+	}
+	return fmt.Sprintf("match on %s has no wildcard arm and may not be exhaustive", me.Subject.String())
+}
+
+// MatchArm パターン, 省略可能なガード(if 条件)と値. 値が式の場合も1文のブロックとして持つ
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression
+	Body    *BlockStatement
+}
+
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+// Pattern match式のパターン
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+// WildcardPattern _ 任意の値にマッチし, 束縛しない
+type WildcardPattern struct {
+	Token token.Token // the token.IDENT token "_"
+}
+
+func (wp *WildcardPattern) patternNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) String() string       { return wp.Token.Literal }
+
+// BindingPattern 任意の値にマッチし, 変数に束縛する
+type BindingPattern struct {
+	Name *Identifier
+}
+
+func (bp *BindingPattern) patternNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (bp *BindingPattern) TokenLiteral() string { return bp.Name.TokenLiteral() }
+func (bp *BindingPattern) String() string       { return bp.Name.String() }
+
+// LiteralPattern 整数・文字列・真偽値・nullのリテラル. 型と値が等しい場合にマッチする
+type LiteralPattern struct {
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Value.TokenLiteral() }
+func (lp *LiteralPattern) String() string       { return lp.Value.String() }
+
+// ArrayPattern [p1, p2] 同じ長さの配列で, 各要素がパターンにマッチする場合にマッチする
+// [p1, ...rest] は長さが要素数以上の配列にマッチし, 残りの要素の配列をRestにマッチさせる
+type ArrayPattern struct {
+	Token    token.Token // the token.LBRACKET token
+	Elements []Pattern
+	// Rest ...restの変数または_. 無い場合はnil
+	Rest Pattern
+}
+
+func (ap *ArrayPattern) patternNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	var elements []string
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern {"k": p} 全てのキーを持ち, 各値がパターンにマッチするハッシュにマッチする. 他のキーは無視する
+// {name} は {"name": name} の省略形
+type HashPattern struct {
+	Token  token.Token  // the token.LBRACE token
+	Keys   []Expression // リテラルのキー
+	Values []Pattern
+}
+
+func (hp *HashPattern) patternNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) String() string {
+	var pairs []string
+	for i, key := range hp.Keys {
+		pairs = append(pairs, key.String()+":"+hp.Values[i].String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
 // Boolean implemented with Golang bool
 type Boolean struct {
 	Token token.Token
@@ -250,6 +526,31 @@ func (b *Boolean) expressionNode() {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
+// NullLiteral null
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (n *NullLiteral) expressionNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (n *NullLiteral) TokenLiteral() string { return n.Token.Literal }
+func (n *NullLiteral) String() string       { return n.Token.Literal }
+
+// ImportExpression import "path". モジュールのexportした変数のハッシュとして評価される
+type ImportExpression struct {
+	Token token.Token // the token.IMPORT token
+	Path  *StringLiteral
+}
+
+func (ie *ImportExpression) expressionNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpression) String() string {
+	return ie.TokenLiteral() + " \"" + ie.Path.Value + "\""
+}
+
 // StringLiteral Goの文字列型をそのまま利用する
 type StringLiteral struct {
 	Token token.Token
@@ -288,6 +589,56 @@ func (al *ArrayLiteral) String() string {
 }
 
 // IndexExpression index operator expression
+// FieldExpression e.message. 文字列のキーによる添字アクセス e["message"] と同じ
+type FieldExpression struct {
+	Token token.Token // the token.DOT token
+	Left  Expression
+	Field *Identifier
+}
+
+func (fe *FieldExpression) expressionNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (fe *FieldExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *FieldExpression) String() string {
+	return "(" + fe.Left.String() + "." + fe.Field.String() + ")"
+}
+
+// AssignExpression フィールドへの代入 p.x = 1. 代入した値を返す
+type AssignExpression struct {
+	Token  token.Token // the token.ASSIGN token
+	Target *FieldExpression
+	Value  Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) String() string {
+	return "(" + ae.Target.String() + " = " + ae.Value.String() + ")"
+}
+
+// MethodCallExpression メソッド呼び出し p.norm(1). 関数の値を持つハッシュのキー・構造体のフィールドの呼び出しにも使う
+type MethodCallExpression struct {
+	Token     token.Token // the token.LPAREN token
+	Receiver  Expression
+	Method    *Identifier
+	Arguments []Expression
+}
+
+func (mc *MethodCallExpression) expressionNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (mc *MethodCallExpression) TokenLiteral() string { return mc.Token.Literal }
+func (mc *MethodCallExpression) String() string {
+	var arguments []string
+	for _, a := range mc.Arguments {
+		arguments = append(arguments, a.String())
+	}
+	return mc.Receiver.String() + "." + mc.Method.String() + "(" + strings.Join(arguments, ", ") + ")"
+}
+
 type IndexExpression struct {
 	Token token.Token
 	Left  Expression
@@ -315,6 +666,7 @@ func (ie *IndexExpression) String() string {
 type HashLiteral struct {
 	Token token.Token // the '{' token
 	Pairs map[Expression]Expression
+	Keys  []Expression // ソースコード上のキーの順序
 }
 
 func (hl *HashLiteral) expressionNode() {}
@@ -325,9 +677,8 @@ func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	var pairs []string
-	// range mapはkey, valueを返す
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -341,7 +692,17 @@ func (hl *HashLiteral) String() string {
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
-	Body       *BlockStatement
+	// Patterns 分割代入する引数 fn([a, b]) のパターン. Parametersと同じ位置に並び, 通常の引数ではnil
+	// 分割代入する引数のParametersには, ソースコードからは参照できない名前 "<param 0>" が入る
+	Patterns []Pattern
+	// Defaults 引数の既定値 fn(a, b = 1). Parametersと同じ位置に並び, 既定値の無い引数ではnil
+	// 既定値を持つ引数は, 既定値の無い引数より後ろに並ぶ
+	Defaults []Expression
+	// Rest 残りの引数を配列で受け取る最後の引数 fn(a, ...rest). 無い場合はnil
+	Rest *Identifier
+	Body *BlockStatement
+	// Name 関数宣言 fn name() {} の名前. 無名関数では空
+	Name string
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
@@ -352,11 +713,24 @@ func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		param := p.String()
+		if i < len(fl.Patterns) && fl.Patterns[i] != nil {
+			param = fl.Patterns[i].String()
+		}
+		if i < len(fl.Defaults) && fl.Defaults[i] != nil {
+			param += " = " + fl.Defaults[i].String()
+		}
+		params = append(params, param)
+	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
 	}
 
 	out.WriteString(fl.TokenLiteral())
+	if fl.Name != "" {
+		out.WriteString(" " + fl.Name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
@@ -365,6 +739,18 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// SpreadExpression 関数呼び出しの引数 f(...args). 配列の要素を引数として展開する
+type SpreadExpression struct {
+	Token token.Token // the token.ELLIPSIS token
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode() {}
+
+// TokenLiteral Nodeリテラル実装
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) String() string       { return "..." + se.Value.String() }
+
 // CallExpression 関数呼び出し <identifier>(<comma separated expressions>)
 type CallExpression struct {
 	Token     token.Token
@@ -0,0 +1,141 @@
+package ast
+
+// Inspect nodeとその子孫を深さ優先で順に訪れ, それぞれのNodeでfを呼ぶ
+// fがfalseを返した場合, そのNodeの子孫は訪れない
+func Inspect(node Node, f func(Node) bool) {
+	if isNilNode(node) || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		inspectStatements(n.Statements, f)
+	case *BlockStatement:
+		inspectStatements(n.Statements, f)
+	case *LetStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Pattern, f)
+		Inspect(n.Value, f)
+	case *ExportStatement:
+		Inspect(n.Statement, f)
+	case *FunctionStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Function, f)
+	case *StructStatement:
+		Inspect(n.Name, f)
+		for _, field := range n.Fields {
+			Inspect(field, f)
+		}
+	case *ImplStatement:
+		Inspect(n.Name, f)
+		for _, method := range n.Methods {
+			Inspect(method, f)
+		}
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ThrowStatement:
+		Inspect(n.Value, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *TryExpression:
+		Inspect(n.Block, f)
+		Inspect(n.CatchParam, f)
+		Inspect(n.Catch, f)
+		Inspect(n.Finally, f)
+	case *MatchExpression:
+		Inspect(n.Subject, f)
+		for _, arm := range n.Arms {
+			Inspect(arm.Pattern, f)
+			Inspect(arm.Guard, f)
+			Inspect(arm.Body, f)
+		}
+	case *BindingPattern:
+		Inspect(n.Name, f)
+	case *LiteralPattern:
+		Inspect(n.Value, f)
+	case *ArrayPattern:
+		for _, element := range n.Elements {
+			Inspect(element, f)
+		}
+		Inspect(n.Rest, f)
+	case *HashPattern:
+		for i, key := range n.Keys {
+			Inspect(key, f)
+			Inspect(n.Values[i], f)
+		}
+	case *ImportExpression:
+		Inspect(n.Path, f)
+	case *ArrayLiteral:
+		inspectExpressions(n.Elements, f)
+	case *FieldExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Field, f)
+	case *AssignExpression:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *MethodCallExpression:
+		Inspect(n.Receiver, f)
+		Inspect(n.Method, f)
+		inspectExpressions(n.Arguments, f)
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *HashLiteral:
+		for _, key := range n.Keys {
+			Inspect(key, f)
+			Inspect(n.Pairs[key], f)
+		}
+	case *FunctionLiteral:
+		for i, param := range n.Parameters {
+			if i < len(n.Patterns) && n.Patterns[i] != nil {
+				Inspect(n.Patterns[i], f)
+			} else {
+				Inspect(param, f)
+			}
+			if i < len(n.Defaults) {
+				Inspect(n.Defaults[i], f)
+			}
+		}
+		Inspect(n.Rest, f)
+		Inspect(n.Body, f)
+	case *SpreadExpression:
+		Inspect(n.Value, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		inspectExpressions(n.Arguments, f)
+	}
+}
+
+func inspectStatements(statements []Statement, f func(Node) bool) {
+	for _, statement := range statements {
+		Inspect(statement, f)
+	}
+}
+
+func inspectExpressions(expressions []Expression, f func(Node) bool) {
+	for _, expression := range expressions {
+		Inspect(expression, f)
+	}
+}
+
+// isNilNode 省略可能なフィールド(elseの無いIfExpressionのAlternativeなど)は型付きのnilになる
+func isNilNode(node Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+	case *Identifier:
+		return n == nil
+	case *BlockStatement:
+		return n == nil
+	}
+	return false
+}
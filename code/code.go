@@ -56,9 +56,19 @@ const (
 	OpHash
 	// Index Operator
 	OpIndex
+	// 値・フィールド名・構造体をpopしてフィールドへ代入し, 値をpushする
+	OpSetField
+	// メソッド名・レシーバをpopし, 呼び出す関数(レシーバを束縛したメソッド)をpushする
+	OpGetMethod
+	// メソッドのハッシュ・構造体の型をpopし, 型にメソッドを追加する
+	OpImpl
 
 	// Function
 	OpCall
+	// OpSpread 配列をpopし, OpCallSpreadで引数として展開する値をpushする
+	OpSpread
+	// OpCallSpread OpSpreadの値を展開してからOpCallと同じように呼び出す
+	OpCallSpread
 	OpReturnValue // Stackの最後の要素を返す
 	OpReturn      // Nullを返す(返す値が存在しないFunctionで利用する)
 
@@ -71,8 +81,58 @@ const (
 	// Closure
 	OpClosure
 	OpGetFree
+	// 実行中のクロージャ自身をpushする. 関数宣言の本体から自分の名前を参照する
+	OpCurrentClosure
+	// OpSetFree クロージャと値をpopし, 値をクロージャのオペランドの位置の自由変数に設定する
+	// ブロックの先頭で作成した関数宣言のクロージャへ, 自由変数が参照する変数の値を設定する
+	OpSetFree
+
+	// Module
+	// グローバル変数にキャッシュしたモジュールをpushする. 未初期化であればモジュールの初期化関数を呼び出す
+	OpImport
+
+	// Exception
+	// OpTry ハンドラ表のハンドラを現在のフレームに登録する. OpEndTryで登録を解除する
+	OpTry
+	OpEndTry
+	// OpThrow Stackの一番上の値を例外として投げる
+	OpThrow
+	// OpEndFinally finallyブロックの終わり. 中断していたthrow・returnを再開する
+	OpEndFinally
+
+	// Match
+	// OpMatchValue 値とリテラルのパターンをpopし, 型と値が等しければtrueをpushする
+	OpMatchValue
+	// OpMatchArray 値をpopし, オペランドの長さの配列であればtrueをpushする
+	// 2つ目のオペランドが1の場合(...restがある場合)は長さ以上の配列であればtrue
+	OpMatchArray
+	// OpMatchHash 値をpopし, ハッシュであればtrueをpushする
+	OpMatchHash
+	// OpMatchKey ハッシュとキーをpopし, ハッシュがキーを持っていればtrueをpushする
+	OpMatchKey
+	// OpJumpTable 値をpopし, 定数のジャンプ表(*object.JumpTable)で値に対応する位置へジャンプする
+	OpJumpTable
+	// OpNoMatch 値をpopし, どのアームにもマッチしなかった例外を投げる
+	OpNoMatch
+
+	// Destructuring
+	// OpCheckArray 値をpopし, OpMatchArrayと同じ条件を満たす配列でなければ例外を投げる
+	OpCheckArray
+	// OpCheckHash 値をpopし, ハッシュでなければ例外を投げる
+	OpCheckHash
+	// OpCheckKey ハッシュとキーをpopし, ハッシュがキーを持っていなければ例外を投げる
+	OpCheckKey
+	// OpRest 配列をpopし, オペランドの位置以降の要素の配列をpushする
+	OpRest
 )
 
+// Handler try式の例外ハンドラ. コンパイラが関数ごとのハンドラ表に並べ, OpTryが位置で参照する
+// Catch, Finally: catch・finallyブロックの開始位置. ブロックが無い場合は-1
+type Handler struct {
+	Catch   int
+	Finally int
+}
+
 // Definition a defition of monkey instructions
 type Definition struct {
 	Name          string
@@ -102,17 +162,52 @@ var definitions = map[Opcode]*Definition{
 	OpArray:         {"OpArray", []int{2}},
 	OpHash:          {"OpHash", []int{2}},
 	OpIndex:         {"OpIndex", []int{}},
+	OpSetField:      {"OpSetField", []int{}},
+	OpGetMethod:     {"OpGetMethod", []int{}},
+	OpImpl:          {"OpImpl", []int{}},
 	OpCall:          {"OpCall", []int{1}},
-	OpReturnValue:   {"OpReturnValue", []int{}},
-	OpReturn:        {"OpReturn", []int{}},
-	OpGetLocal:      {"OpGetLocal", []int{1}},
-	OpSetLocal:      {"OpSetLocal", []int{1}},
-	OpGetBuiltin:    {"OpGetBuiltin", []int{1}},
+	OpSpread:        {"OpSpread", []int{}},
+	// 1byte 展開する前の引数の数
+	OpCallSpread:  {"OpCallSpread", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	OpGetLocal:    {"OpGetLocal", []int{1}},
+	OpSetLocal:    {"OpSetLocal", []int{1}},
+	OpGetBuiltin:  {"OpGetBuiltin", []int{1}},
 	// Closure
 	// 2byte Constant pool上の関数の位置
 	// 1byte 自由変数の数
-	OpClosure: {"OpClosure", []int{2, 1}},
-	OpGetFree: {"OpGetFree", []int{1}},
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	// 1byte 自由変数の位置
+	OpSetFree: {"OpSetFree", []int{1}},
+	// Module
+	// 2byte モジュールをキャッシュするグローバル変数の位置
+	// 2byte Constant pool上のモジュールの初期化関数の位置
+	OpImport: {"OpImport", []int{2, 2}},
+	// Exception
+	// 2byte ハンドラ表の位置
+	OpTry:        {"OpTry", []int{2}},
+	OpEndTry:     {"OpEndTry", []int{}},
+	OpThrow:      {"OpThrow", []int{}},
+	OpEndFinally: {"OpEndFinally", []int{}},
+	// Match
+	OpMatchValue: {"OpMatchValue", []int{}},
+	// 2byte 配列の長さ, 1byte ...restの有無
+	OpMatchArray: {"OpMatchArray", []int{2, 1}},
+	OpMatchHash:  {"OpMatchHash", []int{}},
+	OpMatchKey:   {"OpMatchKey", []int{}},
+	// 2byte ジャンプ表の定数の位置
+	OpJumpTable: {"OpJumpTable", []int{2}},
+	OpNoMatch:   {"OpNoMatch", []int{}},
+	// Destructuring
+	// 2byte 配列の長さ, 1byte ...restの有無
+	OpCheckArray: {"OpCheckArray", []int{2, 1}},
+	OpCheckHash:  {"OpCheckHash", []int{}},
+	OpCheckKey:   {"OpCheckKey", []int{}},
+	// 2byte 残りの要素の開始位置
+	OpRest: {"OpRest", []int{2}},
 }
 
 // Lookup Lookup
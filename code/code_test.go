@@ -12,6 +12,13 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpImport, []int{65534, 1}, []byte{byte(OpImport), 255, 254, 0, 1}},
+		{OpTry, []int{3}, []byte{byte(OpTry), 0, 3}},
+		{OpJumpTable, []int{258}, []byte{byte(OpJumpTable), 1, 2}},
+		{OpCheckArray, []int{2, 1}, []byte{byte(OpCheckArray), 0, 2, 1}},
+		{OpCallSpread, []int{3}, []byte{byte(OpCallSpread), 3}},
+		{OpCurrentClosure, []int{}, []byte{byte(OpCurrentClosure)}},
+		{OpSetFree, []int{2}, []byte{byte(OpSetFree), 2}},
 	}
 
 	for _, tt := range tests {
@@ -1,11 +1,12 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
-	"sort"
+	"strings"
 )
 
 // EmittedInstruction 分岐のBlockStatementで最後のステートメントをOpPopしないようにするために必要
@@ -19,6 +20,19 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	// handlers try式の例外ハンドラ表
+	handlers []code.Handler
+	// freeCells ブロックの先頭で作成した関数宣言のクロージャの自由変数. 変数へ代入するたびに値を更新する
+	freeCells []freeCell
+}
+
+// freeCell 関数宣言のクロージャの自由変数. 宣言より前に呼び出しても, 呼び出した時点の変数の値を参照できるようにする
+type freeCell struct {
+	name    string
+	closure Symbol
+	index   int
+	// blocks 関数宣言があるブロックの深さ. matchのアームなど, より内側のスコープでの同名の変数への代入では更新しない
+	blocks int
 }
 
 // Compiler a Compler for Monkey Lnaguage
@@ -28,8 +42,36 @@ type Compiler struct {
 	symbolTable  *SymbolTable
 	scopes       []CompilationScope
 	scopeIndex   int
+
+	loader object.ModuleLoader
+	// path コンパイル中のモジュールのファイル. メインプログラムでは空
+	path string
+	// exports exportされたグローバル変数
+	exports []Symbol
+	// warnings コンパイルは続けられるが問題のありそうな箇所
+	warnings []string
+	// hoisted ブロックの先頭で定義した関数宣言の変数と, 本体をコンパイルする前に予約した定数の位置
+	hoisted map[*ast.FunctionStatement]hoistedFunction
+}
+
+// hoistedFunction ブロックの先頭でクロージャを作成した関数宣言
+type hoistedFunction struct {
+	symbol Symbol
+	index  int
+	// free 自由変数の位置に並べた名前. 本体をコンパイルする前に, 参照しうる名前から決める
+	free []string
 }
 
+// ModuleError importしたモジュールのコンパイルに失敗した
+type ModuleError struct {
+	Path string
+	Err  error
+}
+
+func (e *ModuleError) Error() string { return fmt.Sprintf("module %s: %s", e.Path, e.Err) }
+
+func (e *ModuleError) Unwrap() error { return e.Err }
+
 // New a constructor of the Compiler
 func New() *Compiler {
 	mainScope := CompilationScope{
@@ -56,12 +98,14 @@ func New() *Compiler {
 func (c *Compiler) Compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
+		mark := c.hoistFunctions(node.Statements)
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
 		}
+		c.forgetFreeCells(mark)
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
 		if err != nil {
@@ -69,24 +113,107 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.BlockStatement:
+		mark := c.hoistFunctions(node.Statements)
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
 				return err
 			}
 		}
+		c.forgetFreeCells(mark)
 	case *ast.LetStatement:
+		if node.Pattern != nil {
+			// 値を先にコンパイルするので, let [x] = [x]; の右辺のxは外側の変数を参照する
+			err := c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+			value := c.symbolTable.defineTemp()
+			c.storeSymbol(value)
+			return c.compileDestructure(node.Pattern, value)
+		}
 		symbol := c.symbolTable.Define(node.Name.Value)
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		c.storeSymbol(symbol)
+	case *ast.ExportStatement:
+		if c.scopeIndex != 0 {
+			return fmt.Errorf("export is only allowed at the top level: %s", node.Name.Value)
+		}
+		err := c.Compile(node.Statement)
+		if err != nil {
+			return err
+		}
+		symbol, _ := c.symbolTable.Resolve(node.Name.Value)
+		c.exports = append(c.exports, symbol)
+	case *ast.StructStatement:
+		symbol := c.symbolTable.Define(node.Name.Value)
+		def := &object.StructType{Name: node.Name.Value}
+		for _, f := range node.Fields {
+			def.Fields = append(def.Fields, f.Value)
+		}
+		c.emit(code.OpConstant, c.addConstant(def))
+		c.storeSymbol(symbol)
+	case *ast.ImplStatement:
+		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+		if !ok {
+			return fmt.Errorf("undefined variable %s", node.Name.Value)
+		}
+		c.loadSymbol(symbol)
+		for _, m := range node.Methods {
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: m.Name.Value}))
+			err := c.Compile(m.Function)
+			if err != nil {
+				return err
+			}
 		}
+		c.emit(code.OpHash, len(node.Methods)*2)
+		c.emit(code.OpImpl)
+	case *ast.FunctionStatement:
+		if hoisted, ok := c.hoisted[node]; ok {
+			// クロージャはブロックの先頭で作成済み. 宣言の位置で定義済みの変数を参照できるように, ここで本体をコンパイルする
+			// 自由変数の位置はブロックの先頭で決めたものを使う. 宣言の位置で解決できない名前の位置は空けておく
+			free := make([]Symbol, len(hoisted.free))
+			for i, name := range hoisted.free {
+				if symbol, ok := c.symbolTable.Resolve(name); ok {
+					free[i] = symbol
+				}
+			}
+			fn, freeSymbols, err := c.compileFunction(node.Function, free...)
+			if err != nil {
+				return err
+			}
+			fn.FreeNames = make([]string, len(freeSymbols))
+			for i, s := range freeSymbols {
+				fn.FreeNames[i] = s.Name
+			}
+			c.constants[hoisted.index] = fn
+			for i, s := range freeSymbols {
+				if s.Name == "" {
+					continue
+				}
+				c.loadSymbol(hoisted.symbol)
+				c.loadSymbol(s)
+				c.emit(code.OpSetFree, i)
+			}
+			// 宣言は値を持たない. ブロックの最後の文であれば, ブロックの値は直前の式ではなくnullになる
+			c.scopes[c.scopeIndex].lastInstruction = EmittedInstruction{}
+			return nil
+		}
+		symbol := c.symbolTable.Define(node.Name.Value)
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+		c.storeSymbol(symbol)
+	case *ast.ImportExpression:
+		mod, err := c.compileModule(node.Path.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpImport, mod.slot, mod.initFn)
 	case *ast.PrefixExpression:
 		err := c.Compile(node.Right)
 		if err != nil {
@@ -153,14 +280,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// Emit an 'OpJumpNotTruthy' with a bogus value
 		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
 
-		err = c.Compile(node.Consequence)
+		// 値を持たないブロック(letや関数宣言で終わる場合など)はnullをpushする
+		err = c.compileBlockValue(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
 		// Emit an 'OpJump' with a bogus
 		jumpPos := c.emit(code.OpJump, 9999)
 
@@ -170,19 +295,31 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
-			err := c.Compile(node.Alternative)
+			err := c.compileBlockValue(node.Alternative)
 			if err != nil {
 				return err
 			}
-
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
 		}
 
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
 
+	case *ast.TryExpression:
+		err := c.compileTry(node)
+		if err != nil {
+			return err
+		}
+	case *ast.MatchExpression:
+		err := c.compileMatch(node)
+		if err != nil {
+			return err
+		}
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
@@ -192,6 +329,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		} else {
 			c.emit(code.OpFalse)
 		}
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
 	case *ast.StringLiteral:
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
@@ -211,15 +350,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
+		// ソースコード上の順に評価し, 挿入順を保つ
+		for _, k := range node.Keys {
 			// Compile "key"
 			err := c.Compile(k)
 			if err != nil {
@@ -242,41 +374,35 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		c.emit(code.OpIndex)
-	case *ast.FunctionLiteral:
-		c.enterScope()
-
-		for _, p := range node.Parameters {
-			c.symbolTable.Define(p.Value)
+	case *ast.FieldExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
 		}
-
-		err := c.Compile(node.Body)
+		field := &object.String{Value: node.Field.Value}
+		c.emit(code.OpConstant, c.addConstant(field))
+		c.emit(code.OpIndex)
+	case *ast.AssignExpression:
+		err := c.Compile(node.Target.Left)
 		if err != nil {
 			return err
 		}
-
-		// Implicit Return
-		if c.lastInstructionIs(code.OpPop) {
-			c.replaceLastPopWithReturn()
+		field := &object.String{Value: node.Target.Field.Value}
+		c.emit(code.OpConstant, c.addConstant(field))
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
 		}
-		// 何も返さない関数の場合 ex: fn () {}
-		if !c.lastInstructionIs(code.OpReturnValue) {
-			c.emit(code.OpReturn)
+		c.emit(code.OpSetField)
+	case *ast.FunctionLiteral:
+		fn, freeSymbols, err := c.compileFunction(node)
+		if err != nil {
+			return err
 		}
-
-		freeSymbols := c.symbolTable.FreeSymbols
-		numLocals := c.symbolTable.numDefinitions
-		instructions := c.leaveScope()
-
 		for _, s := range freeSymbols {
 			c.loadSymbol(s)
 		}
-
-		compiledFn := &object.CompiledFunction{
-			Instructions:  instructions,
-			NumLocals:     numLocals,
-			NumParameters: len(node.Parameters),
-		}
-		fnIndex := c.addConstant(compiledFn)
+		fnIndex := c.addConstant(fn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
@@ -289,15 +415,685 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		return c.compileCall(node.Arguments)
+	case *ast.MethodCallExpression:
+		err := c.Compile(node.Receiver)
+		if err != nil {
+			return err
+		}
+		method := &object.String{Value: node.Method.Value}
+		c.emit(code.OpConstant, c.addConstant(method))
+		c.emit(code.OpGetMethod)
+		return c.compileCall(node.Arguments)
+	}
+	return nil
+}
 
-		for _, a := range node.Arguments {
+// compileCall Stack上の関数を引数argsで呼び出す. ...argsがあればOpCallSpreadで展開する
+func (c *Compiler) compileCall(args []ast.Expression) error {
+	spread := false
+	for _, a := range args {
+		s, ok := a.(*ast.SpreadExpression)
+		if !ok {
 			err := c.Compile(a)
 			if err != nil {
 				return err
 			}
+			continue
+		}
+		err := c.Compile(s.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpSpread)
+		spread = true
+	}
+
+	if spread {
+		c.emit(code.OpCallSpread, len(args))
+	} else {
+		c.emit(code.OpCall, len(args))
+	}
+	return nil
+}
+
+// compileDefaults 引数の既定値を計算するコードをコンパイルし, 既定値を持つ引数ごとの開始位置を返す
+// 全ての引数を渡した呼び出しは先頭のOpJumpで既定値のコードを飛ばす
+//
+//	OpJump <body>
+//	<default 1>        既定値を持つ最初の引数
+//	OpSetLocal <param>
+//	<default 2>
+//	OpSetLocal <param>
+//	body:
+func (c *Compiler) compileDefaults(node *ast.FunctionLiteral, params []Symbol) ([]int, error) {
+	if node.Defaults == nil {
+		return nil, nil
+	}
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	var entries []int
+	for i, d := range node.Defaults {
+		if d == nil {
+			continue
+		}
+		entries = append(entries, len(c.currentInstructions()))
+		err := c.Compile(d)
+		if err != nil {
+			return nil, err
+		}
+		c.storeSymbol(params[i])
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return entries, nil
+}
+
+// compileFunction 関数リテラルを新しいスコープでコンパイルする. 自由変数は外側のスコープのSymbolで返す
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral, free ...Symbol) (*object.CompiledFunction, []Symbol, error) {
+	c.enterScope()
+	for _, s := range free {
+		c.symbolTable.reserveFree(s)
+	}
+	if node.Name != "" {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	params := make([]Symbol, len(node.Parameters))
+	for i, p := range node.Parameters {
+		params[i] = c.symbolTable.Define(p.Value)
+	}
+	if node.Rest != nil {
+		c.symbolTable.Define(node.Rest.Value)
+	}
+	defaultEntries, err := c.compileDefaults(node, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, pattern := range node.Patterns {
+		if pattern == nil {
+			continue
+		}
+		err := c.compileDestructure(pattern, params[i])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = c.Compile(node.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Implicit Return
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	// 何も返さない関数の場合 ex: fn () {}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	handlers := c.currentHandlers()
+	instructions := c.leaveScope()
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:   instructions,
+		NumLocals:      numLocals,
+		NumParameters:  len(node.Parameters),
+		NumDefaults:    len(defaultEntries),
+		DefaultEntries: defaultEntries,
+		Rest:           node.Rest != nil,
+		Name:           node.Name,
+		Handlers:       handlers,
+	}
+	return compiledFn, freeSymbols, nil
+}
+
+// hoistFunctions ブロック(プログラム・関数の本体など)の関数宣言のクロージャを, ブロックの先頭で作成して変数へ代入する
+// 宣言より前の文や, 互いに呼び出し合う関数から参照できる
+// 本体は宣言の位置でコンパイルして予約した定数を置き換える. 関数の中の宣言では自由変数をブロックの先頭で設定し,
+// その後の代入でも更新するので, 宣言より前に呼び出しても呼び出した時点の変数の値を参照する
+// 戻り値はforgetFreeCellsへ渡す, このブロックより前に登録した自由変数の数
+func (c *Compiler) hoistFunctions(statements []ast.Statement) int {
+	mark := len(c.scopes[c.scopeIndex].freeCells)
+
+	var declarations []*ast.FunctionStatement
+	for _, s := range statements {
+		fs, ok := ast.FunctionDeclaration(s)
+		if !ok {
+			continue
+		}
+		if c.hoisted == nil {
+			c.hoisted = map[*ast.FunctionStatement]hoistedFunction{}
+		}
+		symbol := c.symbolTable.Define(fs.Name.Value)
+		index := c.addConstant(&object.CompiledFunction{Name: fs.Name.Value})
+		c.hoisted[fs] = hoistedFunction{symbol: symbol, index: index}
+		c.emit(code.OpClosure, index, 0)
+		c.storeSymbol(symbol)
+		declarations = append(declarations, fs)
+	}
+	if c.symbolTable.Outer == nil {
+		// トップレベルの宣言はグローバル変数を直接参照するので, 自由変数を持たない
+		return mark
+	}
+
+	for _, fs := range declarations {
+		hoisted := c.hoisted[fs]
+		for _, name := range referencedNames(fs) {
+			symbol, ok := c.symbolTable.Resolve(name)
+			if ok && (symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope) {
+				continue
+			}
+			cell := freeCell{name: name, closure: hoisted.symbol, index: len(hoisted.free), blocks: c.symbolTable.blocks}
+			c.scopes[c.scopeIndex].freeCells = append(c.scopes[c.scopeIndex].freeCells, cell)
+			hoisted.free = append(hoisted.free, name)
+			if ok {
+				c.loadSymbol(hoisted.symbol)
+				c.loadSymbol(symbol)
+				c.emit(code.OpSetFree, cell.index)
+			}
+		}
+		c.hoisted[fs] = hoisted
+	}
+	return mark
+}
+
+// forgetFreeCells ブロックの終わりで, そのブロックの関数宣言の自由変数を更新の対象から外す
+func (c *Compiler) forgetFreeCells(mark int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.freeCells = scope.freeCells[:mark]
+}
+
+// updateFreeCells 変数へ代入した値を, 同じ名前を参照する関数宣言のクロージャの自由変数にも設定する
+func (c *Compiler) updateFreeCells(s Symbol) {
+	for _, cell := range c.scopes[c.scopeIndex].freeCells {
+		if cell.name != s.Name || cell.blocks != c.symbolTable.blocks {
+			continue
+		}
+		c.loadSymbol(cell.closure)
+		c.loadSymbol(s)
+		c.emit(code.OpSetFree, cell.index)
+	}
+}
+
+// referencedNames 関数宣言の本体が参照しうる変数の名前. 関数自身の名前と引数は除く
+func referencedNames(fs *ast.FunctionStatement) []string {
+	own := map[string]bool{fs.Name.Value: true}
+	for _, p := range fs.Function.Parameters {
+		own[p.Value] = true
+	}
+	if fs.Function.Rest != nil {
+		own[fs.Function.Rest.Value] = true
+	}
+
+	var names []string
+	var visit func(ast.Node) bool
+	visit = func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FieldExpression:
+			// フィールド名・メソッド名は変数ではない
+			ast.Inspect(node.Left, visit)
+			return false
+		case *ast.MethodCallExpression:
+			ast.Inspect(node.Receiver, visit)
+			for _, arg := range node.Arguments {
+				ast.Inspect(arg, visit)
+			}
+			return false
+		case *ast.Identifier:
+			if !own[node.Value] {
+				own[node.Value] = true
+				names = append(names, node.Value)
+			}
+		}
+		return true
+	}
+	ast.Inspect(fs.Function, visit)
+	return names
+}
+
+// compileModule importしたpathのモジュールを初期化関数へコンパイルする. コンパイル済みであればキャッシュを使う
+// 初期化関数はモジュールのトップレベルを実行し, exportした変数のハッシュをグローバル変数へキャッシュして返す
+func (c *Compiler) compileModule(path string) (compiledModule, error) {
+	if c.loader == nil {
+		return compiledModule{}, fmt.Errorf("cannot import %q: no module loader", path)
+	}
+	resolved, err := c.loader.Resolve(c.path, path)
+	if err != nil {
+		return compiledModule{}, err
+	}
+
+	globals := c.symbolTable.globals
+	if mod, ok := globals.modules[resolved]; ok {
+		return mod, nil
+	}
+	for i, loading := range globals.loading {
+		if loading == resolved {
+			cycle := append(append([]string{}, globals.loading[i:]...), resolved)
+			return compiledModule{}, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if mod, ok := c.linkLibrary(resolved); ok {
+		return mod, nil
+	}
+	program, err := c.loader.Load(resolved)
+	if err != nil {
+		return compiledModule{}, err
+	}
+
+	globals.loading = append(globals.loading, resolved)
+	defer func() { globals.loading = globals.loading[:len(globals.loading)-1] }()
+
+	sub := NewWithState(NewModuleSymbolTable(c.symbolTable), c.constants)
+	sub.loader = c.loader
+	sub.path = resolved
+	if err := sub.Compile(program); err != nil {
+		// 最も内側のモジュールのエラーとして報告する
+		var moduleErr *ModuleError
+		if !errors.As(err, &moduleErr) {
+			err = &ModuleError{Path: resolved, Err: err}
+		}
+		return compiledModule{}, err
+	}
+
+	mod := compiledModule{slot: sub.symbolTable.defineGlobal()}
+	for _, symbol := range sub.exports {
+		sub.emit(code.OpConstant, sub.addConstant(&object.String{Value: symbol.Name}))
+		sub.emit(code.OpGetGlobal, symbol.Index)
+	}
+	sub.emit(code.OpHash, len(sub.exports)*2)
+	sub.emit(code.OpSetGlobal, mod.slot)
+	sub.emit(code.OpGetGlobal, mod.slot)
+	sub.emit(code.OpReturnValue)
+
+	c.constants = sub.constants
+	for _, w := range sub.warnings {
+		c.warnings = append(c.warnings, fmt.Sprintf("module %s: %s", resolved, w))
+	}
+	mod.initFn = c.addConstant(&object.CompiledFunction{
+		Instructions: sub.currentInstructions(),
+		Handlers:     sub.currentHandlers(),
+		Name:         object.ModuleFrameName(resolved),
+	})
+	globals.modules[resolved] = mod
+	return mod, nil
+}
+
+// compileTry try式をコンパイルする. 例外が起きた場合の移動先はハンドラ表に記録する
+//
+//	OpTry <handler>
+//	<try block>        値をpushする
+//	OpEndTry
+//	OpJump <finally>
+//	catch:             例外の値がpushされている
+//	OpSetGlobal/OpSetLocal <e>  catchブロックだけのスコープに定義する
+//	<catch block>      値をpushする
+//	OpEndTry           finallyがある場合のみ
+//	finally:           try・catchの値, または中断したthrow・returnがpushされている
+//	<finally block>
+//	OpEndFinally
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	scope := &c.scopes[c.scopeIndex]
+	handler := len(scope.handlers)
+	scope.handlers = append(scope.handlers, code.Handler{Catch: -1, Finally: -1})
+	c.emit(code.OpTry, handler)
+
+	err := c.compileBlockValue(node.Block)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpEndTry)
+
+	if node.Catch != nil {
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		c.scopes[c.scopeIndex].handlers[handler].Catch = len(c.currentInstructions())
+		// catchの変数はcatchブロックの中だけで参照でき, 外側の同名の変数を上書きしない
+		// catchの外の関数宣言からは参照できないので, 自由変数は更新しない
+		param, restore := c.symbolTable.defineShadowing(node.CatchParam.Value)
+		c.emitStore(param)
+		err := c.compileBlockValue(node.Catch)
+		if err != nil {
+			return err
+		}
+		restore()
+		if node.Finally != nil {
+			c.emit(code.OpEndTry)
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	}
+
+	if node.Finally != nil {
+		c.scopes[c.scopeIndex].handlers[handler].Finally = len(c.currentInstructions())
+		err := c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpEndFinally)
+	}
+	return nil
+}
+
+// compileMatch match式をコンパイルする. 値は名前の無い変数に保存し, 上から順にアームのパターンを試す
+//
+//	<subject>
+//	OpSetGlobal/OpSetLocal <tmp>
+//	arm:               パターンの検査. マッチしなければ次のアームへジャンプする
+//	<guard>            ガードがある場合のみ
+//	OpJumpNotTruthy <next arm>
+//	<body>             値をpushする
+//	OpJump <end>
+//	...
+//	OpGetGlobal/OpGetLocal <tmp>
+//	OpNoMatch
+//	end:
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	if w := node.Warning(); w != "" {
+		c.warnings = append(c.warnings, w)
+	}
+
+	err := c.Compile(node.Subject)
+	if err != nil {
+		return err
+	}
+	subject := c.symbolTable.defineTemp()
+	c.storeSymbol(subject)
+
+	if keys, ok := matchTableKeys(node); ok {
+		return c.compileMatchTable(node, subject, keys)
+	}
+
+	var endJumps []int
+	for _, arm := range node.Arms {
+		// パターンで束縛する変数とアームの中の変数は, そのアームの中だけで参照できる
+		saved := c.symbolTable.enterBlock()
+		var failJumps []int
+		err := c.compilePattern(arm.Pattern, subject, &failJumps)
+		if err != nil {
+			return err
+		}
+		if arm.Guard != nil {
+			err := c.Compile(arm.Guard)
+			if err != nil {
+				return err
+			}
+			failJumps = append(failJumps, c.emit(code.OpJumpNotTruthy, 9999))
+		}
+
+		err = c.compileBlockValue(arm.Body)
+		if err != nil {
+			return err
 		}
+		c.symbolTable.leaveBlock(saved)
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
 
-		c.emit(code.OpCall, len(node.Arguments))
+		nextArmPos := len(c.currentInstructions())
+		for _, pos := range failJumps {
+			c.changeOperand(pos, nextArmPos)
+		}
+	}
+	c.loadSymbol(subject)
+	c.emit(code.OpNoMatch)
+
+	afterMatchPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, afterMatchPos)
+	}
+	return nil
+}
+
+// compileMatchTable リテラルのアームをOpJumpTableで選ぶmatch式をコンパイルする. keysはアームごとのパターンの値
+// ジャンプ表に無い値は最後の_・変数のアームへ, そのアームが無ければOpNoMatchへジャンプする
+func (c *Compiler) compileMatchTable(node *ast.MatchExpression, subject Symbol, keys []object.Hashable) error {
+	table := object.NewJumpTable(-1)
+	c.loadSymbol(subject)
+	c.emit(code.OpJumpTable, c.addConstant(table))
+
+	var endJumps []int
+	for i, arm := range node.Arms {
+		armPos := len(c.currentInstructions())
+		saved := c.symbolTable.enterBlock()
+		if keys[i] == nil {
+			table.Default = armPos
+			if binding, ok := arm.Pattern.(*ast.BindingPattern); ok {
+				c.loadSymbol(subject)
+				c.storeSymbol(c.symbolTable.Define(binding.Name.Value))
+			}
+		} else {
+			// 同じ値のアームは最初のものだけがマッチする
+			table.Add(keys[i], armPos)
+		}
+
+		err := c.compileBlockValue(arm.Body)
+		if err != nil {
+			return err
+		}
+		c.symbolTable.leaveBlock(saved)
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+	}
+	if table.Default < 0 {
+		table.Default = len(c.currentInstructions())
+		c.loadSymbol(subject)
+		c.emit(code.OpNoMatch)
+	}
+
+	afterMatchPos := len(c.currentInstructions())
+	for _, pos := range endJumps {
+		c.changeOperand(pos, afterMatchPos)
+	}
+	return nil
+}
+
+// compilePattern valueの変数の値をpatternで検査する. マッチしない場合のOpJumpNotTruthyの位置をfailに追加する
+func (c *Compiler) compilePattern(pattern ast.Pattern, value Symbol, fail *[]int) error {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+	case *ast.BindingPattern:
+		c.loadSymbol(value)
+		c.storeSymbol(c.symbolTable.Define(pattern.Name.Value))
+	case *ast.LiteralPattern:
+		c.loadSymbol(value)
+		err := c.Compile(pattern.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpMatchValue)
+		*fail = append(*fail, c.emit(code.OpJumpNotTruthy, 9999))
+	case *ast.ArrayPattern:
+		c.loadSymbol(value)
+		c.emit(code.OpMatchArray, len(pattern.Elements), hasRest(pattern))
+		*fail = append(*fail, c.emit(code.OpJumpNotTruthy, 9999))
+		for i, el := range pattern.Elements {
+			err := c.compileElementPattern(el, value, &object.Integer{Value: int64(i)}, fail)
+			if err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			c.loadSymbol(value)
+			c.emit(code.OpRest, len(pattern.Elements))
+			rest := c.symbolTable.defineTemp()
+			c.storeSymbol(rest)
+			return c.compilePattern(pattern.Rest, rest, fail)
+		}
+	case *ast.HashPattern:
+		c.loadSymbol(value)
+		c.emit(code.OpMatchHash)
+		*fail = append(*fail, c.emit(code.OpJumpNotTruthy, 9999))
+		for i, keyNode := range pattern.Keys {
+			key, ok := literalKey(keyNode)
+			if !ok {
+				return fmt.Errorf("unusable as hash pattern key: %s", keyNode.String())
+			}
+			c.loadSymbol(value)
+			c.emit(code.OpConstant, c.addConstant(key))
+			c.emit(code.OpMatchKey)
+			*fail = append(*fail, c.emit(code.OpJumpNotTruthy, 9999))
+
+			err := c.compileElementPattern(pattern.Values[i], value, key, fail)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown pattern %s", pattern.String())
+	}
+	return nil
+}
+
+// compileElementPattern 配列の要素・ハッシュの値value[index]をpatternで検査する
+// 要素の値は名前の無い変数に保存してから検査する
+func (c *Compiler) compileElementPattern(pattern ast.Pattern, value Symbol, index object.Object, fail *[]int) error {
+	if _, ok := pattern.(*ast.WildcardPattern); ok {
+		return nil
+	}
+	c.loadSymbol(value)
+	c.emit(code.OpConstant, c.addConstant(index))
+	c.emit(code.OpIndex)
+
+	if binding, ok := pattern.(*ast.BindingPattern); ok {
+		c.storeSymbol(c.symbolTable.Define(binding.Name.Value))
+		return nil
+	}
+	element := c.symbolTable.defineTemp()
+	c.storeSymbol(element)
+	return c.compilePattern(pattern, element, fail)
+}
+
+// compileDestructure valueの変数の値をpatternで分割代入する. 値の形が違う場合はOpCheck*が例外を投げる
+func (c *Compiler) compileDestructure(pattern ast.Pattern, value Symbol) error {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+	case *ast.BindingPattern:
+		c.loadSymbol(value)
+		c.storeSymbol(c.symbolTable.Define(pattern.Name.Value))
+	case *ast.ArrayPattern:
+		c.loadSymbol(value)
+		c.emit(code.OpCheckArray, len(pattern.Elements), hasRest(pattern))
+		for i, el := range pattern.Elements {
+			err := c.compileElementDestructure(el, value, &object.Integer{Value: int64(i)})
+			if err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			c.loadSymbol(value)
+			c.emit(code.OpRest, len(pattern.Elements))
+			rest := c.symbolTable.defineTemp()
+			c.storeSymbol(rest)
+			return c.compileDestructure(pattern.Rest, rest)
+		}
+	case *ast.HashPattern:
+		c.loadSymbol(value)
+		c.emit(code.OpCheckHash)
+		for i, keyNode := range pattern.Keys {
+			key, ok := literalKey(keyNode)
+			if !ok {
+				return fmt.Errorf("unusable as hash pattern key: %s", keyNode.String())
+			}
+			c.loadSymbol(value)
+			c.emit(code.OpConstant, c.addConstant(key))
+			c.emit(code.OpCheckKey)
+
+			err := c.compileElementDestructure(pattern.Values[i], value, key)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot destructure with pattern %s", pattern.String())
+	}
+	return nil
+}
+
+// compileElementDestructure 配列の要素・ハッシュの値value[index]をpatternで分割代入する
+func (c *Compiler) compileElementDestructure(pattern ast.Pattern, value Symbol, index object.Object) error {
+	if _, ok := pattern.(*ast.WildcardPattern); ok {
+		return nil
+	}
+	c.loadSymbol(value)
+	c.emit(code.OpConstant, c.addConstant(index))
+	c.emit(code.OpIndex)
+
+	if binding, ok := pattern.(*ast.BindingPattern); ok {
+		c.storeSymbol(c.symbolTable.Define(binding.Name.Value))
+		return nil
+	}
+	element := c.symbolTable.defineTemp()
+	c.storeSymbol(element)
+	return c.compileDestructure(pattern, element)
+}
+
+// hasRest OpMatchArray・OpCheckArrayの2つ目のオペランド. ...restがあれば1
+func hasRest(pattern *ast.ArrayPattern) int {
+	if pattern.Rest != nil {
+		return 1
+	}
+	return 0
+}
+
+// matchTableKeys 全てのアームがガードの無いリテラルであれば, アームごとのパターンの値を返す
+// 最後のアームはガードの無い_・変数でもよく, その値はnilになる. リテラルのアームが2つ未満の場合はfalse
+func matchTableKeys(node *ast.MatchExpression) ([]object.Hashable, bool) {
+	keys := make([]object.Hashable, len(node.Arms))
+	literals := 0
+	for i, arm := range node.Arms {
+		if arm.Guard != nil {
+			return nil, false
+		}
+		switch pattern := arm.Pattern.(type) {
+		case *ast.WildcardPattern, *ast.BindingPattern:
+			if i != len(node.Arms)-1 {
+				return nil, false
+			}
+		case *ast.LiteralPattern:
+			key, ok := literalKey(pattern.Value)
+			if !ok {
+				return nil, false
+			}
+			keys[i] = key
+			literals++
+		default:
+			return nil, false
+		}
+	}
+	return keys, literals >= 2
+}
+
+// literalKey リテラルのパターン・ハッシュのパターンのキーの値
+func literalKey(exp ast.Expression) (object.Hashable, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: exp.Value}, true
+	case *ast.PrefixExpression:
+		if integer, ok := exp.Right.(*ast.IntegerLiteral); ok && exp.Operator == "-" {
+			return &object.Integer{Value: -integer.Value}, true
+		}
+	case *ast.StringLiteral:
+		return &object.String{Value: exp.Value}, true
+	case *ast.Boolean:
+		return object.NativeBoolToBoolean(exp.Value), true
+	case *ast.NullLiteral:
+		return object.NULL, true
+	}
+	return nil, false
+}
+
+// compileBlockValue blockを最後の式の値をpushするようにコンパイルする. 値が無い場合はnullをpushする
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
 	}
 	return nil
 }
@@ -310,11 +1106,17 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	return pos
 }
 
+// Warnings コンパイル中に見つかった警告. match式にワイルドカードのアームが無い場合など
+func (c *Compiler) Warnings() []string {
+	return c.warnings
+}
+
 // Bytecode ???
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Handlers:     c.currentHandlers(),
 	}
 }
 
@@ -401,12 +1203,30 @@ func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
 
+func (c *Compiler) currentHandlers() []code.Handler {
+	return c.scopes[c.scopeIndex].handlers
+}
+
 func (c *Compiler) replaceLastPopWithReturn() {
 	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
 	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
+// storeSymbol スタックの値を変数へ代入する. 同じ名前を参照する関数宣言の自由変数も更新する
+func (c *Compiler) storeSymbol(s Symbol) {
+	c.emitStore(s)
+	c.updateFreeCells(s)
+}
+
+func (c *Compiler) emitStore(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -417,6 +1237,8 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
 	}
 }
 
@@ -424,6 +1246,13 @@ func (c *Compiler) loadSymbol(s Symbol) {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object // ???
+	// Handlers メインプログラムの例外ハンドラ表
+	Handlers []code.Handler
+}
+
+// SetLoader importに使うLoaderを設定する. 設定しない場合importはコンパイルエラーになる
+func (c *Compiler) SetLoader(l object.ModuleLoader) {
+	c.loader = l
 }
 
 // NewWithState In REPL, we need to recreate a new VM with existing Symbol table and a list of constants.
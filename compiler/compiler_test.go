@@ -7,6 +7,7 @@ import (
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
 )
 
@@ -916,6 +917,471 @@ func TestClosures(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `try { throw 1 } catch (e) { e }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 0),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpThrow),
+				// 0007
+				code.Make(code.OpNull),
+				// 0008
+				code.Make(code.OpEndTry),
+				// 0009
+				code.Make(code.OpJump, 18),
+				// 0012
+				code.Make(code.OpSetGlobal, 0),
+				// 0015
+				code.Make(code.OpGetGlobal, 0),
+				// 0018
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `try { 1 } finally { 2 }`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 0),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpEndTry),
+				// 0007
+				code.Make(code.OpConstant, 1),
+				// 0010
+				code.Make(code.OpPop),
+				// 0011
+				code.Make(code.OpEndFinally),
+				// 0012
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	// ハンドラ表はcatch・finallyブロックの開始位置を持つ. 関数の中のtry式は関数のハンドラ表に入る
+	handlerTests := []struct {
+		input    string
+		expected []code.Handler
+	}{
+		{`try { throw 1 } catch (e) { e }`, []code.Handler{{Catch: 12, Finally: -1}}},
+		{`try { 1 } finally { 2 }`, []code.Handler{{Catch: -1, Finally: 7}}},
+		{`fn() { try { 1 } catch (e) { try { 2 } finally { 3 } } }`, []code.Handler{{Catch: 10, Finally: -1}, {Catch: -1, Finally: 19}}},
+	}
+	for _, tt := range handlerTests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+		actual := bytecode.Handlers
+		if fn, ok := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction); ok {
+			actual = fn.Handlers
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(tt.expected) {
+			t.Errorf("wrong handlers for %q. want=%v, got=%v", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `match 1 { 1 => 10, _ => 20 }`,
+			expectedConstants: []interface{}{1, 1, 10, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpMatchValue),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 22),
+				// 0016
+				code.Make(code.OpConstant, 2),
+				// 0019
+				code.Make(code.OpJump, 32),
+				// 0022
+				code.Make(code.OpConstant, 3),
+				// 0025
+				code.Make(code.OpJump, 32),
+				// 0028
+				code.Make(code.OpGetGlobal, 0),
+				// 0031
+				code.Make(code.OpNoMatch),
+				// 0032
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// リテラルのアームが2つ以上あればジャンプ表を使う
+			input: `match 2 { 1 => 10, 2 => 20, x => x }`,
+			expectedConstants: []interface{}{
+				2,
+				&object.JumpTable{
+					Targets: []object.JumpTarget{
+						{Key: &object.Integer{Value: 1}, Pos: 12},
+						{Key: &object.Integer{Value: 2}, Pos: 18},
+					},
+					Default: 24,
+				},
+				10,
+				20,
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpJumpTable, 1),
+				// 0012
+				code.Make(code.OpConstant, 2),
+				// 0015
+				code.Make(code.OpJump, 36),
+				// 0018
+				code.Make(code.OpConstant, 3),
+				// 0021
+				code.Make(code.OpJump, 36),
+				// 0024
+				code.Make(code.OpGetGlobal, 0),
+				// 0027
+				code.Make(code.OpSetGlobal, 1),
+				// 0030
+				code.Make(code.OpGetGlobal, 1),
+				// 0033
+				code.Make(code.OpJump, 36),
+				// 0036
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let [a, b] = [1, 2];`,
+			expectedConstants: []interface{}{1, 2, 0, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCheckArray, 2, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 2),
+			},
+		},
+		{
+			input: `fn({name}) { name }`,
+			expectedConstants: []interface{}{
+				"name",
+				"name",
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCheckHash),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpCheckKey),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpIndex),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctionParameters(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a, b = 1) { b }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpJump, 8),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `let f = fn(...xs) { xs }; f(1, ...[2]);`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSpread),
+				code.Make(code.OpCallSpread, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	// デフォルト値の評価はデフォルト値を持つ最初の引数から始める
+	program := parse(`fn(a, b = 1, c = 2, ...d) { a }`)
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn := compiler.Bytecode().Constants[2].(*object.CompiledFunction)
+	if fn.NumParameters != 3 || fn.NumDefaults != 2 || !fn.Rest {
+		t.Fatalf("wrong parameters. got NumParameters=%d, NumDefaults=%d, Rest=%t",
+			fn.NumParameters, fn.NumDefaults, fn.Rest)
+	}
+	if len(fn.DefaultEntries) != 2 || fn.DefaultEntries[0] != 3 || fn.DefaultEntries[1] != 8 {
+		t.Fatalf("wrong DefaultEntries. got=%v", fn.DefaultEntries)
+	}
+}
+
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `f(); fn f() { f }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { fn g(x) { g(x) } }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 関数の中の宣言もブロックの先頭でクロージャを作成して自由変数を設定し, 宣言の位置でも設定する
+			input: `fn(n) { let r = g(); fn g() { n } }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 宣言より後に定義する変数は, 代入した位置で自由変数を更新する
+			input: `fn() { let r = g(); let x = 1; fn g() { x } }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestStructs(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `struct Point { x, y }; let p = Point(1, 2); p.x = p.y;`,
+			expectedConstants: []interface{}{
+				&object.StructType{Name: "Point", Fields: []string{"x", "y"}},
+				1,
+				2,
+				"x",
+				"y",
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetField),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMethods(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `struct P { x }; impl P { fn get(self) { self.x } }; P(1).get();`,
+			expectedConstants: []interface{}{
+				&object.StructType{Name: "P", Fields: []string{"x"}},
+				"get",
+				"x",
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpIndex),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				"get",
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpImpl),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpGetMethod),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMatchWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`match 1 { 1 => 2, _ => 3 }`, nil},
+		{`match 1 { 1 => 2, x => x }`, nil},
+		{`match 1 { 1 => 2 }`, []string{"match on 1 has no wildcard arm and may not be exhaustive"}},
+		{`let x = 1; match x { _ if x > 0 => 2, [y] => y }`, []string{"match on x has no wildcard arm and may not be exhaustive"}},
+	}
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		if fmt.Sprint(compiler.Warnings()) != fmt.Sprint(tt.expected) {
+			t.Errorf("wrong warnings for %q. want=%q, got=%q", tt.input, tt.expected, compiler.Warnings())
+		}
+	}
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	// Memo:
 	// この関数を呼び出した関数をテストヘルパー関数とみなす
@@ -988,6 +1454,22 @@ func testConstants(t *testing.T, expected []interface{}, actual []object.Object)
 			if err != nil {
 				t.Errorf("testStringObject failed: %s", err)
 			}
+		case *object.JumpTable:
+			table, ok := actual[i].(*object.JumpTable)
+			if !ok {
+				return fmt.Errorf("constant %d - not a jump table: %T", i, actual[i])
+			}
+			if jumpTableString(table) != jumpTableString(constant) {
+				return fmt.Errorf("constant %d - wrong jump table. want=%s, got=%s", i, jumpTableString(constant), jumpTableString(table))
+			}
+		case *object.StructType:
+			def, ok := actual[i].(*object.StructType)
+			if !ok {
+				return fmt.Errorf("constant %d - not a struct type: %T", i, actual[i])
+			}
+			if def.Inspect() != constant.Inspect() {
+				return fmt.Errorf("constant %d - wrong struct type. want=%s, got=%s", i, constant.Inspect(), def.Inspect())
+			}
 		case []code.Instructions:
 			fn, ok := actual[i].(*object.CompiledFunction)
 			if !ok {
@@ -1002,6 +1484,15 @@ func testConstants(t *testing.T, expected []interface{}, actual []object.Object)
 	return nil
 }
 
+// jumpTableString ジャンプ表の値と位置を比較用の文字列にする
+func jumpTableString(table *object.JumpTable) string {
+	var targets []string
+	for _, target := range table.Targets {
+		targets = append(targets, fmt.Sprintf("%s: %d", target.Key.Inspect(), target.Pos))
+	}
+	return fmt.Sprintf("{%s} default: %d", strings.Join(targets, ", "), table.Default)
+}
+
 func testIntegerObject(expected int64, actual object.Object) error {
 	result, ok := actual.(*object.Integer)
 	if !ok {
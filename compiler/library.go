@@ -0,0 +1,141 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/code"
+	"monkey/object"
+)
+
+// Library 前もってコンパイルしたモジュールの集まり. 標準ライブラリを起動時に1度だけコンパイルするために使う
+// バイトコードは0から始まる定数・グローバル変数の位置を参照するので, importしたコンパイラの定数表の末尾とグローバル変数の数だけずらして取り込む
+type Library struct {
+	constants  []object.Object
+	numGlobals int
+	modules    map[string]compiledModule
+	// builtins 参照しているbuiltin関数. key: 名前 / value: 位置
+	builtins map[string]int
+	warnings []string
+}
+
+// LibraryLoader Libraryも提供するLoader. Libraryにあるモジュールはソースからコンパイルせずに取り込む
+type LibraryLoader interface {
+	object.ModuleLoader
+	Library() *Library
+}
+
+// CompileLibrary loaderで解決したpathsのモジュールと, それらがimportするモジュールをまとめてコンパイルする
+func CompileLibrary(loader object.ModuleLoader, paths ...string) (*Library, error) {
+	c := New()
+	// コンパイル中のLibrary自身は取り込まない
+	c.loader = struct{ object.ModuleLoader }{loader}
+	for _, path := range paths {
+		if _, err := c.compileModule(path); err != nil {
+			return nil, err
+		}
+	}
+
+	lib := &Library{
+		constants:  c.constants,
+		numGlobals: c.symbolTable.globals.numGlobals,
+		modules:    c.symbolTable.globals.modules,
+		builtins:   map[string]int{},
+		warnings:   c.warnings,
+	}
+	for _, constant := range lib.constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		eachInstruction(fn.Instructions, func(op code.Opcode, operands []int, _ int) {
+			if op == code.OpGetBuiltin {
+				lib.builtins[object.Builtins[operands[0]].Name] = operands[0]
+			}
+		})
+	}
+	return lib, nil
+}
+
+// linkLibrary loaderのLibraryにあるモジュールであれば, Library全体をコンパイラの状態へ取り込む
+// Libraryが参照するbuiltin関数の位置が異なる場合は取り込まず, ソースからコンパイルする
+func (c *Compiler) linkLibrary(resolved string) (compiledModule, bool) {
+	loader, ok := c.loader.(LibraryLoader)
+	if !ok {
+		return compiledModule{}, false
+	}
+	lib := loader.Library()
+	if lib == nil {
+		return compiledModule{}, false
+	}
+	if _, ok := lib.modules[resolved]; !ok {
+		return compiledModule{}, false
+	}
+	for name, index := range lib.builtins {
+		if symbol, ok := c.symbolTable.builtin(name); !ok || symbol.Index != index {
+			return compiledModule{}, false
+		}
+	}
+
+	globals := c.symbolTable.globals
+	constantBase, globalBase := len(c.constants), globals.numGlobals
+	for _, constant := range lib.constants {
+		c.constants = append(c.constants, relocateConstant(constant, constantBase, globalBase))
+	}
+	globals.numGlobals += lib.numGlobals
+	for path, mod := range lib.modules {
+		// ソースからコンパイル済みのモジュールはそのまま使う
+		if _, ok := globals.modules[path]; !ok {
+			globals.modules[path] = compiledModule{slot: mod.slot + globalBase, initFn: mod.initFn + constantBase}
+		}
+	}
+	c.warnings = append(c.warnings, lib.warnings...)
+	return globals.modules[resolved], true
+}
+
+// relocateConstant Libraryの定数を, 定数・グローバル変数の位置をずらしてコピーする
+// 実行時に変更される定数(implでメソッドを追加するstruct)は, 取り込んだコンパイラごとに別のオブジェクトにする
+func relocateConstant(constant object.Object, constantBase, globalBase int) object.Object {
+	switch constant := constant.(type) {
+	case *object.CompiledFunction:
+		fn := *constant
+		fn.Instructions = relocate(constant.Instructions, constantBase, globalBase)
+		return &fn
+	case *object.StructType:
+		return &object.StructType{Name: constant.Name, Fields: constant.Fields}
+	default:
+		return constant
+	}
+}
+
+// relocate 定数・グローバル変数の位置を参照するオペランドをずらした命令列を返す
+func relocate(ins code.Instructions, constantBase, globalBase int) code.Instructions {
+	relocated := make(code.Instructions, len(ins))
+	copy(relocated, ins)
+	eachInstruction(ins, func(op code.Opcode, operands []int, pos int) {
+		switch op {
+		case code.OpConstant, code.OpClosure, code.OpJumpTable:
+			operands[0] += constantBase
+		case code.OpGetGlobal, code.OpSetGlobal:
+			operands[0] += globalBase
+		case code.OpImport:
+			operands[0] += globalBase
+			operands[1] += constantBase
+		default:
+			return
+		}
+		copy(relocated[pos:], code.Make(op, operands...))
+	})
+	return relocated
+}
+
+// eachInstruction 命令列の各命令について, Opcode・オペランド・位置を渡してfnを呼び出す
+func eachInstruction(ins code.Instructions, fn func(op code.Opcode, operands []int, pos int)) {
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			panic(fmt.Sprintf("invalid instruction at %d: %s", i, err))
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		fn(code.Opcode(ins[i]), operands, i)
+		i += 1 + read
+	}
+}
@@ -0,0 +1,155 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+	"testing"
+)
+
+// libraryLoader ソースのmapからモジュールを読み込むLoader. lib以外のLibraryLoaderの機能は持たない
+type libraryLoader struct {
+	sources map[string]string
+	lib     *Library
+	loaded  []string
+}
+
+func (l *libraryLoader) Resolve(from, path string) (string, error) {
+	if _, ok := l.sources[path]; !ok {
+		return "", fmt.Errorf("cannot find module %q", path)
+	}
+	return path, nil
+}
+
+func (l *libraryLoader) Load(path string) (*ast.Program, error) {
+	l.loaded = append(l.loaded, path)
+	return parse(l.sources[path]), nil
+}
+
+func (l *libraryLoader) Library() *Library { return l.lib }
+
+func TestLinkLibrary(t *testing.T) {
+	loader := &libraryLoader{sources: map[string]string{
+		"a": `export let x = 1;`,
+		"b": `let a = import "a"; export let y = len(a);`,
+	}}
+	lib, err := CompileLibrary(loader, "b")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if fmt.Sprint(loader.loaded) != "[b a]" {
+		t.Fatalf("wrong modules loaded by CompileLibrary. got=%v", loader.loaded)
+	}
+	loader.lib = lib
+	loader.loaded = nil
+
+	// aのexport(x), bのlet a / export(y), 各モジュールのキャッシュ用の変数
+	if lib.numGlobals != 5 {
+		t.Fatalf("wrong number of library globals. got=%d", lib.numGlobals)
+	}
+	if fmt.Sprint(lib.builtins) != fmt.Sprint(map[string]int{"len": 0}) {
+		t.Fatalf("wrong library builtins. got=%v", lib.builtins)
+	}
+
+	compiler := New()
+	compiler.SetLoader(loader)
+	if err := compiler.Compile(parse(`let z = 10; import "a"; import "b"`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if len(loader.loaded) != 0 {
+		t.Fatalf("linked modules should not be loaded. got=%v", loader.loaded)
+	}
+
+	bytecode := compiler.Bytecode()
+	constants := bytecode.Constants
+	if len(constants) != 1+len(lib.constants) {
+		t.Fatalf("wrong number of constants. got=%d", len(constants))
+	}
+	a, b := lib.modules["a"], lib.modules["b"]
+	expected := []code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetGlobal, 0),
+		code.Make(code.OpImport, a.slot+1, a.initFn+1),
+		code.Make(code.OpPop),
+		code.Make(code.OpImport, b.slot+1, b.initFn+1),
+		code.Make(code.OpPop),
+	}
+	if err := testInstructions(expected, bytecode.Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	// bの初期化関数はaのキャッシュ用の変数と初期化関数をずらした位置で参照する
+	initB, ok := constants[b.initFn+1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant %d is not a function. got=%T", b.initFn+1, constants[b.initFn+1])
+	}
+	if initB == lib.constants[b.initFn] {
+		t.Errorf("linked function should be a copy")
+	}
+	found := false
+	eachInstruction(initB.Instructions, func(op code.Opcode, operands []int, _ int) {
+		if op == code.OpImport {
+			found = true
+			if operands[0] != a.slot+1 || operands[1] != a.initFn+1 {
+				t.Errorf("wrong relocated OpImport. got=%v", operands)
+			}
+		}
+	})
+	if !found {
+		t.Errorf("OpImport not found in %s", initB.Instructions)
+	}
+}
+
+func TestLinkLibraryFallback(t *testing.T) {
+	loader := &libraryLoader{sources: map[string]string{"a": `export let x = len("abc");`}}
+	lib, err := CompileLibrary(loader, "a")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	loader.lib = lib
+	loader.loaded = nil
+
+	// builtin関数の位置が異なる場合はソースからコンパイルする
+	compiler := New()
+	compiler.SetLoader(loader)
+	compiler.symbolTable.DefineBuiltin(1, "len")
+	if err := compiler.Compile(parse(`import "a"`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if fmt.Sprint(loader.loaded) != "[a]" {
+		t.Errorf("module should be compiled from source. loaded=%v", loader.loaded)
+	}
+}
+
+func TestLinkLibraryStructs(t *testing.T) {
+	loader := &libraryLoader{sources: map[string]string{"a": `struct P { x }; export let p = P;`}}
+	lib, err := CompileLibrary(loader, "a")
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	loader.lib = lib
+
+	// implはstructの定数を変更するので, 取り込んだコンパイラごとに別のオブジェクトにする
+	var linked []object.Object
+	for i := 0; i < 2; i++ {
+		compiler := New()
+		compiler.SetLoader(loader)
+		if err := compiler.Compile(parse(`import "a"`)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		for _, constant := range compiler.Bytecode().Constants {
+			if st, ok := constant.(*object.StructType); ok {
+				linked = append(linked, st)
+			}
+		}
+	}
+	if len(linked) != 2 || linked[0] == linked[1] {
+		t.Fatalf("struct constants should be copied per compiler. got=%v", linked)
+	}
+	for _, st := range linked {
+		if st.(*object.StructType).Name != "P" {
+			t.Errorf("wrong struct name. got=%s", st.Inspect())
+		}
+	}
+}
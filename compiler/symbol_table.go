@@ -7,6 +7,8 @@ const (
 	GlobalScope  SymbolScope = "GLOBAL"
 	BuiltinScope SymbolScope = "BUILTIN"
 	FreeScope    SymbolScope = "FREE"
+	// FunctionScope 関数宣言の本体から参照する, 宣言した関数自身
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 // Symbol プログラム内で宣言された変数を表す
@@ -25,35 +27,148 @@ type SymbolTable struct {
 
 	// 自由変数
 	FreeSymbols []Symbol
+	// blocks enterBlockで入った, 独自のスコープを持つブロックの深さ
+	blocks int
+
+	globals *globalState
+}
+
+// globalState VMのグローバル変数ストアを共有するシンボル表(メインプログラムと各モジュール)の状態
+type globalState struct {
+	// numGlobals 割り当て済みのグローバル変数の数. モジュールごとに名前空間は分かれるが, 位置は重複しない
+	numGlobals int
+	// modules コンパイル済みのモジュール. key: モジュールのファイルの絶対パス
+	modules map[string]compiledModule
+	// loading コンパイル中のモジュール(循環importの検出用)
+	loading []string
+}
+
+// compiledModule モジュールの初期化関数とexportをキャッシュするグローバル変数の位置
+type compiledModule struct {
+	slot   int
+	initFn int
 }
 
 // NewSymbolTable GlobalSymbolTable用
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
 	free := []Symbol{}
-	return &SymbolTable{store: s, FreeSymbols: free}
+	return &SymbolTable{store: s, FreeSymbols: free, globals: &globalState{modules: map[string]compiledModule{}}}
 }
 
 // NewEnclosedSymbolTable LocalSymbolTable用
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	s := NewSymbolTable()
 	s.Outer = outer
+	s.globals = outer.globals
+	return s
+}
+
+// Clone GlobalSymbolTableとグローバル変数の割り当て状態を複製する
+// 複製へのDefineやモジュールのコンパイルは元のシンボル表に影響しない. コンパイルに失敗した時に変更を破棄するために使う
+func (s *SymbolTable) Clone() *SymbolTable {
+	clone := NewSymbolTable()
+	for name, symbol := range s.store {
+		clone.store[name] = symbol
+	}
+	clone.numDefinitions = s.numDefinitions
+	clone.globals.numGlobals = s.globals.numGlobals
+	for path, mod := range s.globals.modules {
+		clone.globals.modules[path] = mod
+	}
+	return clone
+}
+
+// NewModuleSymbolTable モジュール用のGlobalSymbolTable
+// mainとは別の名前空間を持つが, グローバル変数の位置はmainと重複しないように割り当てる
+// builtin関数はmainと同じものを参照する
+func NewModuleSymbolTable(main *SymbolTable) *SymbolTable {
+	for main.Outer != nil {
+		main = main.Outer
+	}
+	s := NewSymbolTable()
+	s.globals = main.globals
+	for name, symbol := range main.store {
+		if symbol.Scope == BuiltinScope {
+			s.store[name] = symbol
+		}
+	}
 	return s
 }
 
 // Define SymbolTableへSymbolを定義する
 func (s *SymbolTable) Define(name string) Symbol {
-	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	symbol := Symbol{Name: name}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
+		symbol.Index = s.defineGlobal()
 	} else {
 		symbol.Scope = LocalScope
+		symbol.Index = s.numDefinitions
 	}
 	s.store[name] = symbol
 	s.numDefinitions++
 	return symbol
 }
 
+// defineGlobal 名前を持たないグローバル変数の位置を割り当てる
+func (s *SymbolTable) defineGlobal() int {
+	index := s.globals.numGlobals
+	s.globals.numGlobals++
+	return index
+}
+
+// defineTemp 名前を持たない変数を現在のスコープに割り当てる. match式の値を一時的に保存する
+func (s *SymbolTable) defineTemp() Symbol {
+	if s.Outer == nil {
+		return Symbol{Scope: GlobalScope, Index: s.defineGlobal()}
+	}
+	symbol := Symbol{Scope: LocalScope, Index: s.numDefinitions}
+	s.numDefinitions++
+	return symbol
+}
+
+// enterBlock 独自のスコープを持つブロック(matchのアームなど)に入る前の名前の表を保存する
+// ブロックで定義した変数は新しい位置に割り当てられ, leaveBlockで元の名前の表に戻す
+func (s *SymbolTable) enterBlock() map[string]Symbol {
+	saved := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		saved[name] = symbol
+	}
+	s.blocks++
+	return saved
+}
+
+// leaveBlock ブロックで定義した変数を取り除き, 隠していた外側の変数を戻す
+// ブロックの中で解決した自由変数はそのまま残す
+func (s *SymbolTable) leaveBlock(saved map[string]Symbol) {
+	s.blocks--
+	for name, symbol := range s.store {
+		if symbol.Scope != LocalScope && symbol.Scope != GlobalScope {
+			continue
+		}
+		if prev, ok := saved[name]; !ok {
+			delete(s.store, name)
+		} else if prev != symbol {
+			s.store[name] = prev
+		}
+	}
+}
+
+// defineShadowing nameを新しい位置に定義し, 外側の同名の変数へ名前を戻す関数を返す
+// catchの変数のように, ブロックの中だけで参照できる変数に使う
+func (s *SymbolTable) defineShadowing(name string) (Symbol, func()) {
+	prev, ok := s.store[name]
+	symbol := s.Define(name)
+	return symbol, func() {
+		if ok {
+			s.store[name] = prev
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
 // Resolve SymbolTableからSymbolを解決する
 // 未定義Symbole名の場合は第二返り値がfalseとなる
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
@@ -79,6 +194,33 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// builtin 最も外側のシンボル表に定義されたbuiltin関数を返す. 内側のスコープの同名の変数は無視する
+func (s *SymbolTable) builtin(name string) (Symbol, bool) {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	symbol, ok := s.store[name]
+	return symbol, ok && symbol.Scope == BuiltinScope
+}
+
+// DefineFunctionName 関数宣言の名前を, その関数自身のスコープに定義する
+// 引数や本体で同じ名前を定義した場合はそちらが優先される
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// reserveFree 本体をコンパイルする前に, 自由変数の位置を決めておく
+// originalのNameが空の場合は位置だけを空けておき, 名前は解決しない
+func (s *SymbolTable) reserveFree(original Symbol) {
+	if original.Name == "" {
+		s.FreeSymbols = append(s.FreeSymbols, original)
+		return
+	}
+	s.defineFree(original)
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
@@ -285,3 +285,81 @@ func TestResolveUnresolvableFree(t *testing.T) {
 		}
 	}
 }
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+
+	// 引数で同じ名前を定義すると関数名は隠れる
+	global.Define("a")
+	expected = Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+	result, ok = global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("name %s not resolvable", expected.Name)
+	}
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
+func TestModuleSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("a")
+
+	module := NewModuleSymbolTable(global)
+	if _, ok := module.Resolve("a"); ok {
+		t.Errorf("global a should not be visible from module")
+	}
+	if symbol, ok := module.Resolve("len"); !ok || symbol != (Symbol{Name: "len", Scope: BuiltinScope, Index: 0}) {
+		t.Errorf("builtin len should be visible from module. got=%+v", symbol)
+	}
+
+	// グローバル変数の位置はmainと重複しない
+	b := module.Define("a")
+	if b != (Symbol{Name: "a", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("expected module a=%+v, got=%+v", Symbol{Name: "a", Scope: GlobalScope, Index: 1}, b)
+	}
+	c := global.Define("c")
+	if c != (Symbol{Name: "c", Scope: GlobalScope, Index: 2}) {
+		t.Errorf("expected c=%+v, got=%+v", Symbol{Name: "c", Scope: GlobalScope, Index: 2}, c)
+	}
+	if symbol, _ := global.Resolve("a"); symbol.Index != 0 {
+		t.Errorf("global a was overwritten by module. got=%+v", symbol)
+	}
+}
+
+func TestCloneSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("a")
+
+	clone := global.Clone()
+	b := clone.Define("b")
+	if b != (Symbol{Name: "b", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("expected b=%+v, got=%+v", Symbol{Name: "b", Scope: GlobalScope, Index: 1}, b)
+	}
+	if symbol, ok := clone.Resolve("len"); !ok || symbol.Scope != BuiltinScope {
+		t.Errorf("builtin len should be visible from clone. got=%+v", symbol)
+	}
+
+	// 複製への定義は元のシンボル表に影響しない
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("b should not be defined in the original table")
+	}
+	c := global.Define("c")
+	if c != (Symbol{Name: "c", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("expected c=%+v, got=%+v", Symbol{Name: "c", Scope: GlobalScope, Index: 1}, c)
+	}
+}
@@ -1,34 +1,57 @@
 package evaluator
 
 import (
+	"errors"
 	"fmt"
 	"monkey/ast"
 	"monkey/object"
+	"strings"
 )
 
-var builtins = map[string]*object.Builtin{
-	"len":   object.GetBuiltinByName("len"),
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-	"rest":  object.GetBuiltinByName("rest"),
-	"push":  object.GetBuiltinByName("push"),
-	"puts":  object.GetBuiltinByName("puts"),
+// MaxFrames 関数呼び出しの深さの上限. vm.MaxFramesと同じ
+const MaxFrames = 1024
+
+var builtins = map[string]*object.Builtin{}
+
+func init() {
+	for _, def := range object.Builtins {
+		builtins[def.Name] = def.Builtin
+	}
 }
+
 var (
 	// NULL Null用オブジェクト
-	NULL = &object.Null{}
+	NULL = object.NULL
 	// TRUE boolean用オブジェクト全てのtrueはこのオブジェクトとして評価される
-	TRUE = &object.Boolean{Value: true}
+	TRUE = object.TRUE
 	// FALSE boolean用オブジェクト全てのfalseはこのオブジェクトとして評価される
-	FALSE = &object.Boolean{Value: false}
+	FALSE = object.FALSE
 )
 
+// EvalWithLimits 実行制限付きでASTを評価する
+// MaxInstructionsはASTノードの評価回数として数える
+// 上限を超えた場合はFatalに原因を持つErrorを返す
+func EvalWithLimits(node ast.Node, env *object.Environment, limits object.Limits) object.Object {
+	env.SetBudget(object.NewBudget(limits))
+	return Eval(node, env)
+}
+
 // Eval parserによって生成されたASTを評価する
+// Programの評価でcatchされなかった例外は, Fatalに*object.Exceptionを持つERRORとして返す
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if program, ok := node.(*ast.Program); ok {
+		result := evalProgram(program, env)
+		if exc, ok := result.(*exception); ok {
+			exc.trace(env)
+		}
+		return unwrapException(result)
+	}
+	if err := env.Budget().Step(); err != nil {
+		return newFatalError(err)
+	}
+
 	switch node := node.(type) {
 	// Statements
-	case *ast.Program:
-		return evalProgram(node, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
 	case *ast.BlockStatement:
@@ -39,6 +62,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return throw(val, env)
 
 	// Expressions
 	case *ast.IntegerLiteral:
@@ -46,6 +75,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.Boolean:
 		// booleanは2パターンしか存在しないので同じオブジェクトを参照するように (P146)
 		return nativeBoolToBooleanObject(node.Value)
+	case *ast.NullLiteral:
+		return NULL
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.PrefixExpression:
@@ -63,41 +94,88 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return trackAlloc(evalInfixExpression(node.Operator, left, right), env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
+		if node.Pattern != nil {
+			if exc := destructure(node.Pattern, val, env); exc != nil {
+				return exc
+			}
+			return nil
+		}
 		env.Set(node.Name.Value, val)
+	case *ast.ExportStatement:
+		if !env.Export(node.Name.Value) {
+			return newError("export is only allowed at the top level: %s", node.Name.Value)
+		}
+		if _, ok := node.Statement.(*ast.FunctionStatement); ok {
+			// トップレベルの関数はhoistFunctionsで代入済み
+			return nil
+		}
+		return Eval(node.Statement, env)
+	case *ast.StructStatement:
+		def := &object.StructType{Name: node.Name.Value}
+		for _, f := range node.Fields {
+			def.Fields = append(def.Fields, f.Value)
+		}
+		env.Set(node.Name.Value, def)
+	case *ast.ImplStatement:
+		return evalImplStatement(node, env)
+	case *ast.FunctionStatement:
+		fn := Eval(node.Function, env)
+		if isError(fn) {
+			return fn
+		}
+		env.Set(node.Name.Value, fn)
+	case *ast.ImportExpression:
+		return evalImport(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		fn := &object.Function{
+			Parameters: params,
+			Patterns:   node.Patterns,
+			Defaults:   node.Defaults,
+			Rest:       node.Rest,
+			Body:       body,
+			Env:        env,
+			Name:       node.Name,
+		}
+		return trackAlloc(fn, env)
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
 
-		args := evalExpressions(node.Arguments, env)
+		args := evalArguments(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			// エラー発生時は要素数1のスライスにErrorが返される
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		if builtin, ok := function.(*object.Builtin); ok && !builtin.Borrows {
+			return trackAlloc(applyFunction(function, args, env), env)
+		}
+		return applyFunction(function, args, env)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
 		// Errorの場合
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return trackAlloc(&object.Array{Elements: elements}, env)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -108,6 +186,16 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.FieldExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		return evalIndexExpression(left, &object.String{Value: node.Field.Value})
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+	case *ast.MethodCallExpression:
+		return evalMethodCallExpression(node, env)
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	}
@@ -116,15 +204,23 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 }
 
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
-	var result object.Object
+	warnMatches(program, env)
+	if exc := hoistFunctions(program.Statements, env); exc != nil {
+		return exc
+	}
 
+	var result object.Object
 	for _, statement := range program.Statements {
+		if _, ok := statement.(*ast.FunctionStatement); ok {
+			// トップレベルの関数はhoistFunctionsで代入済み
+			continue
+		}
 		result = Eval(statement, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
-		case *object.Error:
+		case *exception:
 			return result
 		}
 	}
@@ -132,17 +228,89 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
+// warnMatches compilerと同じく, どの値にもマッチするアームの無いmatch式を評価する前に警告する
+// モジュールの警告には, compilerと同じくimportしているモジュールのパスを前に付ける
+func warnMatches(program *ast.Program, env *object.Environment) {
+	var prefix string
+	for _, path := range env.Imports().Loading {
+		prefix += fmt.Sprintf("module %s: ", path)
+	}
+	ast.Inspect(program, func(node ast.Node) bool {
+		if match, ok := node.(*ast.MatchExpression); ok {
+			if w := match.Warning(); w != "" {
+				env.Warn(prefix + w)
+			}
+		}
+		return true
+	})
+}
+
+// evalImport モジュールを評価し, exportした変数のハッシュを返す
+// モジュールは1度だけ評価し, 2回目以降は同じハッシュを返す
+func evalImport(node *ast.ImportExpression, env *object.Environment) object.Object {
+	imports := env.Imports()
+	if imports.Loader == nil {
+		return newError("cannot import %q: no module loader", node.Path.Value)
+	}
+	path, err := imports.Loader.Resolve(env.ModulePath(), node.Path.Value)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	if exports, ok := imports.Modules[path]; ok {
+		return exports
+	}
+	for i, loading := range imports.Loading {
+		if loading == path {
+			cycle := append(append([]string{}, imports.Loading[i:]...), path)
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	program, err := imports.Loader.Load(path)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	imports.Loading = append(imports.Loading, path)
+	defer func() { imports.Loading = imports.Loading[:len(imports.Loading)-1] }()
+
+	moduleEnv := object.NewModuleEnvironment(env, path)
+	moduleEnv.PushFrame(object.ModuleFrameName(path))
+	result := evalProgram(program, moduleEnv)
+	if exc, ok := result.(*exception); ok {
+		exc.trace(moduleEnv)
+	}
+	moduleEnv.PopFrame()
+	if isError(result) {
+		return result
+	}
+	exports := moduleEnv.Exports()
+	if imports.Modules == nil {
+		imports.Modules = map[string]*object.Hash{}
+	}
+	imports.Modules[path] = exports
+	return exports
+}
+
 func evalBlockStatement(blcok *ast.BlockStatement, env *object.Environment) object.Object {
+	if exc := hoistFunctions(blcok.Statements, env); exc != nil {
+		return exc
+	}
+
 	var result object.Object
 
 	for _, statement := range blcok.Statements {
+		if _, ok := statement.(*ast.FunctionStatement); ok {
+			// 関数宣言はhoistFunctionsで代入済み. 宣言は値を持たない
+			result = nil
+			continue
+		}
 		result = Eval(statement, env)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if result.Type() == object.RETURN_VALUE_OBJ || isError(result) {
 				// ReturnValueをunwrapしないので、evalProgramまでReturnValue型でバブルアップしていく (P166)
-				// Errorの場合も同様
+				// 例外の場合も同様
 				return result
 			}
 		}
@@ -151,6 +319,23 @@ func evalBlockStatement(blcok *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// hoistFunctions ブロック(プログラム・関数の本体など)の関数宣言を, 他の文より先に変数へ代入する
+// 宣言より前の文や, 互いに呼び出し合う関数から参照できる
+func hoistFunctions(statements []ast.Statement, env *object.Environment) *exception {
+	for _, statement := range statements {
+		fs, ok := ast.FunctionDeclaration(statement)
+		if !ok {
+			continue
+		}
+		fn := Eval(fs.Function, env)
+		if exc, ok := fn.(*exception); ok {
+			return exc
+		}
+		env.Set(fs.Name.Value, fn)
+	}
+	return nil
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -197,12 +382,11 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 		return evalIntegerInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
-		// MonkeyのBooleanは全てTRUE/FALSEオブジェクトへの参照なので参照への比較を行えばよい
-		// パフォーマンス的にはIntegerよりも良くなる (P157)
+	// 配列・ハッシュ・nullは値として比較する. 関数は同一のオブジェクトの場合だけ等しい
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equals(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equals(left, right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -221,6 +405,9 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
@@ -236,17 +423,29 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 }
 
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
+	if isError(condition) {
+		return condition
+	}
 
 	if isTruthy(condition) {
 		return Eval(ie.Consequence, env)
@@ -257,6 +456,155 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+// evalTryExpression tryブロックで投げられた例外をcatchブロックで処理する
+// finallyブロックは最後に評価し, その中の例外・returnはtry・catchの結果より優先する
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(node.Block, env)
+	if exc, ok := result.(*exception); ok && node.Catch != nil && !exc.fatal() {
+		exc.trace(env)
+		// catchの変数はcatchブロックの中だけで参照でき, 外側の同名の変数を上書きしない
+		// catchブロックで定義した他の変数は, 他のブロックと同じく外側からも参照できる
+		catchEnv := object.NewEnclosedEnvironment(env)
+		catchEnv.Set(node.CatchParam.Value, exc.value)
+		result = Eval(node.Catch, catchEnv)
+		catchEnv.CopyTo(env, node.CatchParam.Value)
+	}
+	if result == nil {
+		result = NULL
+	}
+
+	if node.Finally != nil && !isFatal(result) {
+		finally := Eval(node.Finally, env)
+		if isError(finally) || (finally != nil && finally.Type() == object.RETURN_VALUE_OBJ) {
+			return finally
+		}
+	}
+	return result
+}
+
+// evalMatchExpression 上から順にアームのパターンを試し, 最初にマッチしたアームの値を返す
+// パターンの変数はアームごとの環境に束縛する. どのアームにもマッチしない場合は例外
+func evalMatchExpression(node *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(node.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range node.Arms {
+		// パターンで束縛する変数とアームの中の変数は, そのアームの中だけで参照できる
+		armEnv := object.NewEnclosedEnvironment(env)
+		if !matchPattern(arm.Pattern, subject, armEnv) {
+			continue
+		}
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		result := Eval(arm.Body, armEnv)
+		if result == nil {
+			return NULL
+		}
+		return result
+	}
+	return newError("no match for value: %s", subject.Inspect())
+}
+
+// matchPattern valueがpatternにマッチすればtrue. 束縛する変数はマッチの途中でenvに設定する
+func matchPattern(pattern ast.Pattern, value object.Object, env *object.Environment) bool {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return true
+	case *ast.BindingPattern:
+		env.Set(pattern.Name.Value, value)
+		return true
+	case *ast.LiteralPattern:
+		return object.Equals(Eval(pattern.Value, env), value)
+	case *ast.ArrayPattern:
+		array, ok := value.(*object.Array)
+		n := len(pattern.Elements)
+		if !ok || len(array.Elements) < n || pattern.Rest == nil && len(array.Elements) != n {
+			return false
+		}
+		for i, el := range pattern.Elements {
+			if !matchPattern(el, array.Elements[i], env) {
+				return false
+			}
+		}
+		if pattern.Rest != nil {
+			rest := &object.Array{Elements: append([]object.Object{}, array.Elements[n:]...)}
+			return matchPattern(pattern.Rest, rest, env)
+		}
+		return true
+	case *ast.HashPattern:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false
+		}
+		for i, keyNode := range pattern.Keys {
+			key, _ := object.AsHashable(Eval(keyNode, env))
+			v, ok := hash.Get(key)
+			if !ok || !matchPattern(pattern.Values[i], v, env) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// destructure let・引数の分割代入. valueの形がpatternと違う場合は例外を返す
+func destructure(pattern ast.Pattern, value object.Object, env *object.Environment) *exception {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+	case *ast.BindingPattern:
+		env.Set(pattern.Name.Value, value)
+	case *ast.ArrayPattern:
+		array, ok := value.(*object.Array)
+		if !ok {
+			return newError("cannot destructure %s as ARRAY", value.Type())
+		}
+		n := len(pattern.Elements)
+		switch {
+		case pattern.Rest == nil && len(array.Elements) != n:
+			return newError("cannot destructure ARRAY of length %d into %d elements", len(array.Elements), n)
+		case pattern.Rest != nil && len(array.Elements) < n:
+			return newError("cannot destructure ARRAY of length %d into at least %d elements", len(array.Elements), n)
+		}
+		for i, el := range pattern.Elements {
+			if exc := destructure(el, array.Elements[i], env); exc != nil {
+				return exc
+			}
+		}
+		if pattern.Rest != nil {
+			rest := &object.Array{Elements: append([]object.Object{}, array.Elements[n:]...)}
+			return destructure(pattern.Rest, rest, env)
+		}
+	case *ast.HashPattern:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return newError("cannot destructure %s as HASH", value.Type())
+		}
+		for i, keyNode := range pattern.Keys {
+			key, _ := object.AsHashable(Eval(keyNode, env))
+			v, ok := hash.Get(key)
+			if !ok {
+				return newError("cannot destructure HASH: missing key %s", key.Inspect())
+			}
+			if exc := destructure(pattern.Values[i], v, env); exc != nil {
+				return exc
+			}
+		}
+	default:
+		return newError("cannot destructure with pattern %s", pattern.String())
+	}
+	return nil
+}
+
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
@@ -265,7 +613,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	if builtin, ok := builtins[node.Value]; ok {
 		return builtin
 	}
-	return newError("identifier not found: " + node.Value)
+	return newError("identifier not found: %s", node.Value)
 }
 
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
@@ -284,17 +632,123 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
+// evalArguments 関数呼び出しの引数を評価する. ...argsは配列の要素を引数として展開する
+func evalArguments(exps []ast.Expression, env *object.Environment) []object.Object {
+	var result []object.Object
+
+	for _, e := range exps {
+		s, ok := e.(*ast.SpreadExpression)
+		if !ok {
+			evaluated := Eval(e, env)
+			if isError(evaluated) {
+				return []object.Object{evaluated}
+			}
+			result = append(result, evaluated)
+			continue
+		}
+
+		evaluated := Eval(s.Value, env)
+		if isError(evaluated) {
+			return []object.Object{evaluated}
+		}
+		array, ok := evaluated.(*object.Array)
+		if !ok {
+			return []object.Object{newError("cannot spread %s as arguments", evaluated.Type())}
+		}
+		result = append(result, array.Elements...)
+	}
+
+	return result
+}
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArraylIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.ERROR_OBJ && index.Type() == object.STRING_OBJ:
+		return left.(*object.Error).Field(index.(*object.String).Value)
+	case left.Type() == object.STRUCT_OBJ && index.Type() == object.STRING_OBJ:
+		value, err := left.(*object.Struct).Field(index.(*object.String).Value)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return value
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
 }
 
+// evalImplStatement 構造体の型にメソッドを追加する
+func evalImplStatement(node *ast.ImplStatement, env *object.Environment) object.Object {
+	target := evalIdentifier(node.Name, env)
+	if isError(target) {
+		return target
+	}
+	def, ok := target.(*object.StructType)
+	if !ok {
+		return newError("cannot impl methods for %s", target.Type())
+	}
+	for _, m := range node.Methods {
+		fn := Eval(m.Function, env)
+		if isError(fn) {
+			return fn
+		}
+		def.Impl(m.Name.Value, fn)
+	}
+	return nil
+}
+
+// evalMethodCallExpression receiver.method(args). 呼び出す関数はobject.FindMethodで探す
+func evalMethodCallExpression(node *ast.MethodCallExpression, env *object.Environment) object.Object {
+	receiver := Eval(node.Receiver, env)
+	if isError(receiver) {
+		return receiver
+	}
+	if receiver == nil {
+		receiver = NULL
+	}
+	method, err := object.FindMethod(receiver, node.Method.Value)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	args := evalArguments(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+
+	if bm, ok := method.(*object.BoundMethod); ok {
+		if builtin, ok := bm.Method.(*object.Builtin); ok && !builtin.Borrows {
+			return trackAlloc(applyFunction(method, args, env), env)
+		}
+	}
+	return applyFunction(method, args, env)
+}
+
+// evalAssignExpression p.x = value. 代入できるのは構造体のフィールドだけ
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	target := Eval(node.Target.Left, env)
+	if isError(target) {
+		return target
+	}
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+
+	field := node.Target.Field.Value
+	s, ok := target.(*object.Struct)
+	if !ok {
+		return newError("cannot assign field %s of %s", field, target.Type())
+	}
+	if err := s.SetField(field, value); err != nil {
+		return newError("%s", err)
+	}
+	return value
+}
+
 func evalArraylIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
 	idx := index.(*object.Integer).Value
@@ -310,14 +764,15 @@ func evalArraylIndexExpression(array, index object.Object) object.Object {
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
+	hash := object.NewHash(len(node.Keys))
+	for _, keyNode := range node.Keys {
+		valueNode := node.Pairs[keyNode]
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
 		// Hashableの実装でない場合はkeyとして使用できないのでエラー
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return newError("unsusable as hash key: %s", key.Type())
 		}
@@ -327,52 +782,169 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return trackAlloc(hash, env)
 }
 
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return value
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction fnを呼び出す. envは呼び出し元の環境で, ホストから呼び出す場合はnil
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		required := len(fn.Parameters)
+		for required > 0 && required <= len(fn.Defaults) && fn.Defaults[required-1] != nil {
+			required--
+		}
+		if len(args) < required || len(args) > len(fn.Parameters) && fn.Rest == nil {
+			arity := object.Arity(required, len(fn.Parameters), fn.Rest != nil)
+			return newError("wrong number of arguments: want=%s, got=%d", arity, len(args))
+		}
+		if fn.Env.CallDepth() >= MaxFrames {
+			return newFatalError(object.ErrStackOverflow)
+		}
+		fn.Env.PushFrame(object.StackFrameName(fn.Name))
+		var evaluated object.Object
+		extendedEnv, exc := extendFunctionEnv(fn, args)
+		if exc != nil {
+			evaluated = exc
+		} else {
+			evaluated = Eval(fn.Body, extendedEnv)
+		}
+		if exc, ok := evaluated.(*exception); ok {
+			exc.trace(fn.Env)
+		}
+		fn.Env.PopFrame()
 		// Memo: ReturnValueが返るので、中の返り値自身を取り出す(ReturnValueのままだとEvalProgamまで)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
-			return result
+		result := fn.Invoke(caller{env: env}, args...)
+		if result == nil {
+			return NULL
 		}
-		return NULL
+		if errObj, ok := result.(*object.Error); ok {
+			// builtin関数の失敗と, builtin関数から呼び出した関数の例外を投げ直す
+			// それ以外のERROR(error()の戻り値など)は値として返す
+			var thrown *object.Exception
+			switch {
+			case errors.As(errObj.Fatal, &thrown):
+				return &exception{value: thrown.Value}
+			case errObj.Fatal != nil:
+				return &exception{value: errObj}
+			case errObj.Failure:
+				errObj.Failure = false
+				return throw(errObj, env)
+			}
+		}
+		return result
+	case *object.BoundMethod:
+		return applyFunction(fn.Method, append([]object.Object{fn.Receiver}, args...), env)
+	case *object.StructType:
+		s, err := fn.New(args)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return trackAlloc(s, env)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+// Call Monkeyの関数(関数オブジェクト・builtin関数)をargsで呼び出し, 戻り値を返す
+// builtin関数やホストのGoコードから呼び出すために公開している
+func Call(fn object.Object, args ...object.Object) object.Object {
+	return unwrapException(applyFunction(fn, args, nil))
+}
+
+// CallWithLimits limitsの範囲でfnを呼び出す. 上限を超えた場合はFatalなErrorを返す
+// fnが定義された環境の実行予算は呼び出しの間だけ置き換え, 戻る時に元の予算へ戻す
+// そのため実行中のスクリプトからホストを経由して呼び出しても, 外側の実行制限は変わらない
+func CallWithLimits(fn object.Object, args []object.Object, limits object.Limits) object.Object {
+	if f, ok := fn.(*object.Function); ok {
+		prev := f.Env.Budget()
+		f.Env.SetBudget(object.NewBudget(limits))
+		defer f.Env.SetBudget(prev)
+	}
+	return unwrapException(applyFunction(fn, args, nil))
+}
+
+// caller builtin関数からMonkeyの関数を呼び出す. envはbuiltin関数の呼び出し元の環境
+type caller struct {
+	env *object.Environment
+}
+
+// Call 呼び出した関数の例外は, Fatalに*object.Exceptionを持つERRORにして返す
+func (c caller) Call(fn object.Object, args ...object.Object) object.Object {
+	result := applyFunction(fn, args, c.env)
+	if exc, ok := result.(*exception); ok {
+		if exc.fatal() {
+			return exc.value
+		}
+		thrown := &object.Exception{Value: exc.value}
+		return &object.Error{Message: thrown.Error(), Fatal: thrown}
+	}
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// StackTrace error()が生成するERRORのStack
+func (c caller) StackTrace() []string {
+	return stackTrace(c.env)
+}
+
+// extendFunctionEnv 引数を束縛した関数の環境を作る. 既定値の評価・引数の分割代入に失敗した場合は例外を返す
+// 省略された引数は, 既定値を評価するまでnullにしておく
+func extendFunctionEnv(fn *object.Function, args []object.Object) (*object.Environment, *exception) {
 	env := object.NewEnclosedEnvironment(fn.Env)
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if paramIdx < len(args) {
+			env.Set(param.Value, args[paramIdx])
+		} else {
+			env.Set(param.Value, NULL)
+		}
+	}
+	if fn.Rest != nil {
+		var rest []object.Object
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		env.Set(fn.Rest.Value, &object.Array{Elements: rest})
 	}
-	return env
+	for paramIdx := len(args); paramIdx < len(fn.Parameters); paramIdx++ {
+		value := Eval(fn.Defaults[paramIdx], env)
+		if exc, ok := value.(*exception); ok {
+			return nil, exc
+		}
+		env.Set(fn.Parameters[paramIdx].Value, value)
+	}
+	for paramIdx, pattern := range fn.Patterns {
+		if pattern == nil {
+			continue
+		}
+		value, _ := env.Get(fn.Parameters[paramIdx].Value)
+		if exc := destructure(pattern, value, env); exc != nil {
+			return nil, exc
+		}
+	}
+	return env, nil
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -384,25 +956,89 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
-		return false
-	default:
-		return true
+	return object.IsTruthy(obj)
+}
+
+// newError 実行時エラーを例外として投げる
+// Stackは呼び出し元へ伝播する途中で, 例外が発生した関数を抜ける時に記録する
+func newError(format string, a ...interface{}) *exception {
+	return &exception{value: &object.Error{Kind: object.KindRuntimeError, Message: fmt.Sprintf(format, a...)}}
+}
+
+// throw valueを例外として投げる. Stackを持たないERRORにはenvで実行中の関数を記録する
+func throw(value object.Object, env *object.Environment) *exception {
+	exc := &exception{value: value}
+	exc.trace(env)
+	return exc
+}
+
+// stackTrace envで実行中の関数の名前. ホストから呼び出した場合(envがnil)はメインプログラムだけ
+func stackTrace(env *object.Environment) []string {
+	if env == nil {
+		return []string{object.MainFrameName}
 	}
+	return env.StackTrace()
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+// trackAlloc 生成したオブジェクトのメモリ量を実行予算に計上する
+func trackAlloc(obj object.Object, env *object.Environment) object.Object {
+	if err := env.Budget().Alloc(obj); err != nil {
+		return newFatalError(err)
+	}
+	return obj
 }
 
-func isError(obj object.Object) bool {
-	if obj != nil {
-		return obj.Type() == object.ERROR_OBJ
+// newFatalError スクリプトから回復できないエラー(実行制限の超過など). catchできない
+func newFatalError(err error) *exception {
+	return &exception{value: &object.Error{Kind: object.KindRuntimeError, Message: err.Error(), Fatal: err}}
+}
+
+// exception 評価中の例外(throwした値・実行時エラー). catchされるまで評価を中断して呼び出し元へ伝播する
+// スクリプトの値にはならず, Eval・Callの呼び出し元へはunwrapExceptionでERRORにして返す
+type exception struct {
+	value object.Object
+}
+
+func (e *exception) Type() object.ObjectType { return object.ERROR_OBJ }
+
+func (e *exception) Inspect() string { return e.toError().Inspect() }
+
+// fatal 実行制限の超過などcatchできないエラー
+func (e *exception) fatal() bool {
+	errObj, ok := e.value.(*object.Error)
+	return ok && errObj.Fatal != nil
+}
+
+// trace 投げられたERRORにStackが無ければ, envで実行中の関数を記録する
+func (e *exception) trace(env *object.Environment) {
+	if errObj, ok := e.value.(*object.Error); ok && errObj.Stack == nil {
+		errObj.Stack = stackTrace(env)
 	}
-	return false
+}
+
+// toError 投げられた値をFatalに*object.Exceptionを持つERRORに包む. 実行制限の超過などFatalなERRORはそのまま返す
+// 値としてのERROR(error()の戻り値など)と区別できるように, 投げられたERRORも包む
+func (e *exception) toError() *object.Error {
+	if errObj, ok := e.value.(*object.Error); ok && errObj.Fatal != nil {
+		return errObj
+	}
+	thrown := &object.Exception{Value: e.value}
+	return &object.Error{Message: thrown.Error(), Fatal: thrown}
+}
+
+func unwrapException(obj object.Object) object.Object {
+	if exc, ok := obj.(*exception); ok {
+		return exc.toError()
+	}
+	return obj
+}
+
+func isError(obj object.Object) bool {
+	_, ok := obj.(*exception)
+	return ok
+}
+
+func isFatal(obj object.Object) bool {
+	exc, ok := obj.(*exception)
+	return ok && exc.fatal()
 }
@@ -0,0 +1,680 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"monkey/object"
+	"reflect"
+	"sort"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// ToObject Goの値をMonkeyのオブジェクトへ変換する
+//
+//	bool                  -> BOOLEAN
+//	int, uint (各サイズ)   -> INTEGER
+//	string                -> STRING
+//	slice, array          -> ARRAY
+//	map                   -> HASH (キーはINTEGER/STRING/BOOLEANへ変換できる型)
+//	struct                -> HASH (公開フィールド名またはタグ `monkey:"name"` がキー)
+//	func                  -> BUILTIN (WrapFuncを参照)
+//	nil, nil pointer      -> NULL
+//	object.Object         -> そのまま
+//
+// 自身を参照するポインタ・map・sliceはエラーを返す
+func ToObject(v interface{}) (object.Object, error) {
+	if v == nil {
+		return object.NULL, nil
+	}
+	return toObject(reflect.ValueOf(v), map[goVisit]bool{})
+}
+
+// goVisit 変換中のポインタ・map・slice. 循環する値の検出に使う
+type goVisit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// visit 変換中の値としてrvをvisitingに記録し, 記録を取り除く関数を返す. 既に変換中であれば循環する値としてエラーを返す
+func visit(rv reflect.Value, visiting map[goVisit]bool) (func(), error) {
+	key := goVisit{ptr: rv.Pointer(), typ: rv.Type()}
+	if rv.Kind() == reflect.Slice {
+		key.len = rv.Len()
+	}
+	if visiting[key] {
+		return nil, fmt.Errorf("cyclic value of type %s", rv.Type())
+	}
+	visiting[key] = true
+	return func() { delete(visiting, key) }, nil
+}
+
+// toObject visitingで変換中の値を引き継いでrvを変換する
+func toObject(rv reflect.Value, visiting map[goVisit]bool) (object.Object, error) {
+	if rv.IsValid() && rv.Type().Implements(objectType) {
+		switch rv.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			if rv.IsNil() {
+				return object.NULL, nil
+			}
+		}
+		return rv.Interface().(object.Object), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return object.NULL, nil
+	case reflect.Bool:
+		return object.NativeBoolToBoolean(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: rv.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("integer overflow: %d", u)
+		}
+		return &object.Integer{Value: int64(u)}, nil
+	case reflect.String:
+		return &object.String{Value: rv.String()}, nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return object.NULL, nil
+		}
+		if rv.Kind() == reflect.Ptr {
+			leave, err := visit(rv, visiting)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+		return toObject(rv.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice {
+			if rv.IsNil() {
+				return object.NULL, nil
+			}
+			leave, err := visit(rv, visiting)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+		elements := make([]object.Object, rv.Len())
+		for i := range elements {
+			el, err := toObject(rv.Index(i), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			elements[i] = el
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return object.NULL, nil
+		}
+		leave, err := visit(rv, visiting)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		return mapToHash(rv, visiting)
+	case reflect.Struct:
+		return structToHash(rv, visiting)
+	case reflect.Func:
+		if rv.IsNil() {
+			return object.NULL, nil
+		}
+		return wrapFunc(rv)
+	default:
+		return nil, fmt.Errorf("unsupported Go type: %s", rv.Type())
+	}
+}
+
+func mapToHash(rv reflect.Value, visiting map[goVisit]bool) (object.Object, error) {
+	// Goのmapの走査順はランダムなので, キーを並べ替えてから変換する
+	keys := rv.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	hash := object.NewHash(len(keys))
+	for _, k := range keys {
+		key, err := toObject(k, visiting)
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %w", k.Interface(), err)
+		}
+		hashKey, ok := object.AsHashable(key)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		value, err := toObject(rv.MapIndex(k), visiting)
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %w", k.Interface(), err)
+		}
+		hash.Set(hashKey, value)
+	}
+	return hash, nil
+}
+
+func structToHash(rv reflect.Value, visiting map[goVisit]bool) (object.Object, error) {
+	fields := structFields(rv.Type())
+	hash := object.NewHash(len(fields))
+	for _, f := range fields {
+		value, err := toObject(rv.FieldByIndex(f.index), visiting)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		hash.Set(&object.String{Value: f.name}, value)
+	}
+	return hash, nil
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields 変換対象の公開フィールド. タグ `monkey:"-"` のフィールドは除く
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("monkey"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, index: f.Index})
+	}
+	return fields
+}
+
+// FromObject Monkeyのオブジェクトをtの型のGoの値へ変換する
+// tがinterface{}の場合はINTEGER -> int64, STRING -> string, BOOLEAN -> bool, NULL -> nil,
+// ARRAY -> []interface{}, HASH -> map[string]interface{}
+// (文字列以外のキーを含む場合はmap[interface{}]interface{}) へ変換する
+// STRUCTはフィールド名をキーとするHASHと同様に, mapやGoの構造体へ変換する. 自身を参照するSTRUCTはエラーを返す
+// Monkeyの関数は変換できない (Interpreter.GlobalValueやWrapFuncで登録した関数の引数では変換できる)
+func FromObject(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	return converter{}.convert(obj, t)
+}
+
+// converter Monkeyのオブジェクトを変換する
+// callerがあればMonkeyの関数をGoの関数へ変換できる
+type converter struct {
+	caller object.Caller
+	// visiting 変換中のSTRUCT. 循環する値の検出に使う
+	visiting map[*object.Struct]bool
+}
+
+// convert objをtの型へ変換する. 変換中のSTRUCTの記録は呼び出しごとに作る
+func (c converter) convert(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	c.visiting = map[*object.Struct]bool{}
+	return c.fromObject(obj, t)
+}
+
+func (c converter) fromObject(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		obj = object.NULL
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(t) && t != reflect.TypeOf((*interface{})(nil)).Elem() {
+		return reflect.ValueOf(obj), nil
+	}
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		v, err := toNative(obj, c.visiting)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(v), nil
+	}
+	if obj == object.NULL {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func:
+			return reflect.Zero(t), nil
+		}
+	}
+	if t.Kind() == reflect.Ptr {
+		v, err := c.fromObject(obj, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(v)
+		return ptr, nil
+	}
+
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return integerToValue(obj.Value, t)
+	case *object.String:
+		if t.Kind() == reflect.String {
+			return reflect.ValueOf(obj.Value).Convert(t), nil
+		}
+	case *object.Boolean:
+		if t.Kind() == reflect.Bool {
+			return reflect.ValueOf(obj.Value).Convert(t), nil
+		}
+	case *object.Array:
+		return c.arrayToValue(obj, t)
+	case *object.Hash:
+		switch t.Kind() {
+		case reflect.Map:
+			return c.hashToMap(obj, t)
+		case reflect.Struct:
+			return c.hashToStruct(obj, t)
+		}
+	case *object.Struct:
+		if t.Kind() == reflect.Map || t.Kind() == reflect.Struct {
+			leave, err := visitStruct(obj, c.visiting)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			defer leave()
+			return c.fromObject(structToFields(obj), t)
+		}
+	case *object.Builtin, *object.Closure, *object.Function:
+		if t.Kind() == reflect.Func {
+			return c.funcOf(obj, t)
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+func integerToValue(i int64, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(i) {
+			return reflect.Value{}, fmt.Errorf("integer %d overflows %s", i, t)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return reflect.Value{}, fmt.Errorf("integer %d overflows %s", i, t)
+		}
+		v.SetUint(uint64(i))
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot convert INTEGER to %s", t)
+	}
+	return v, nil
+}
+
+func (c converter) arrayToValue(arr *object.Array, t reflect.Type) (reflect.Value, error) {
+	var v reflect.Value
+	switch t.Kind() {
+	case reflect.Slice:
+		v = reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+	case reflect.Array:
+		if t.Len() != len(arr.Elements) {
+			return reflect.Value{}, fmt.Errorf("cannot convert ARRAY of length %d to %s", len(arr.Elements), t)
+		}
+		v = reflect.New(t).Elem()
+	default:
+		return reflect.Value{}, fmt.Errorf("cannot convert ARRAY to %s", t)
+	}
+
+	for i, el := range arr.Elements {
+		ev, err := c.fromObject(el, t.Elem())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("index %d: %w", i, err)
+		}
+		v.Index(i).Set(ev)
+	}
+	return v, nil
+}
+
+func (c converter) hashToMap(hash *object.Hash, t reflect.Type) (reflect.Value, error) {
+	m := reflect.MakeMapWithSize(t, hash.Len())
+	for _, pair := range hash.Pairs() {
+		k, err := c.fromObject(pair.Key, t.Key())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+		}
+		v, err := c.fromObject(pair.Value, t.Elem())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+		}
+		m.SetMapIndex(k, v)
+	}
+	return m, nil
+}
+
+func (c converter) hashToStruct(hash *object.Hash, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	for _, f := range structFields(t) {
+		value, ok := hash.Get(&object.String{Value: f.name})
+		if !ok {
+			continue
+		}
+		fv := v.FieldByIndex(f.index)
+		fieldValue, err := c.fromObject(value, fv.Type())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("field %s: %w", f.name, err)
+		}
+		fv.Set(fieldValue)
+	}
+	return v, nil
+}
+
+// visitStruct 変換中のSTRUCTとしてsをvisitingに記録し, 記録を取り除く関数を返す
+// 既に変換中であれば循環する値としてエラーを返す. 値の循環はSTRUCTのフィールドを経由してのみ起きる
+func visitStruct(s *object.Struct, visiting map[*object.Struct]bool) (func(), error) {
+	if visiting[s] {
+		return nil, fmt.Errorf("cannot convert cyclic %s", s.Def.Name)
+	}
+	visiting[s] = true
+	return func() { delete(visiting, s) }, nil
+}
+
+// structToFields フィールド名をキーとしてSTRUCTの値を並べたHASH
+func structToFields(s *object.Struct) *object.Hash {
+	hash := object.NewHash(len(s.Values))
+	for i, name := range s.Def.Fields {
+		hash.Set(&object.String{Value: name}, s.Values[i])
+	}
+	return hash
+}
+
+// toNative interface{}へ代入するための自然なGoの値. visitingは変換中のSTRUCT
+func toNative(obj object.Object, visiting map[*object.Struct]bool) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Array:
+		values := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			v, err := toNative(el, visiting)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			values[i] = v
+		}
+		return values, nil
+	case *object.Hash:
+		stringKeys := true
+		for _, pair := range obj.Pairs() {
+			if pair.Key.Type() != object.STRING_OBJ {
+				stringKeys = false
+				break
+			}
+		}
+		if stringKeys {
+			m := make(map[string]interface{}, obj.Len())
+			for _, pair := range obj.Pairs() {
+				v, err := toNative(pair.Value, visiting)
+				if err != nil {
+					return nil, err
+				}
+				m[pair.Key.(*object.String).Value] = v
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{}, obj.Len())
+		for _, pair := range obj.Pairs() {
+			if pair.Key.Type() == object.ARRAY_OBJ {
+				// []interface{}はGoのmapのキーにできない
+				return nil, fmt.Errorf("cannot convert %s key to interface{}", pair.Key.Type())
+			}
+			k, err := toNative(pair.Key, visiting)
+			if err != nil {
+				return nil, err
+			}
+			v, err := toNative(pair.Value, visiting)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case *object.Struct:
+		leave, err := visitStruct(obj, visiting)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		return toNative(structToFields(obj), visiting)
+	default:
+		return obj, nil
+	}
+}
+
+// funcOf Monkeyの関数をtの型のGoの関数として呼び出せるようにする
+// 変換に失敗した場合や関数がエラーを返した場合は, tの最後の戻り値がerrorであればそこで返し, そうでなければpanicする
+func (c converter) funcOf(fn object.Object, t reflect.Type) (reflect.Value, error) {
+	call := func(args []object.Object) object.Object {
+		return c.caller.Call(fn, args...)
+	}
+	if b, ok := fn.(*object.Builtin); ok && b.CallerFn == nil {
+		call = func(args []object.Object) object.Object { return b.Fn(args...) }
+	} else if c.caller == nil {
+		return reflect.Value{}, fmt.Errorf("cannot convert %s to %s outside of the interpreter", fn.Type(), t)
+	}
+
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		args, err := valuesToObjects(in, t.IsVariadic())
+		if err != nil {
+			return c.failure(err, t)
+		}
+		return c.resultToValues(call(args), t)
+	}), nil
+}
+
+func valuesToObjects(in []reflect.Value, variadic bool) ([]object.Object, error) {
+	if variadic {
+		last := in[len(in)-1]
+		in = in[:len(in)-1]
+		for i := 0; i < last.Len(); i++ {
+			in = append(in, last.Index(i))
+		}
+	}
+
+	args := make([]object.Object, len(in))
+	for i, v := range in {
+		arg, err := toObject(v, map[goVisit]bool{})
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		args[i] = arg
+	}
+	return args, nil
+}
+
+// resultToValues Monkeyの関数の戻り値をtの戻り値の並びへ変換する
+// tの最後の戻り値がerrorであれば, ERRORオブジェクトや変換エラーはそこで返す
+func (c converter) resultToValues(result object.Object, t reflect.Type) []reflect.Value {
+	if errObj, ok := result.(*object.Error); ok {
+		if errObj.Fatal != nil {
+			return c.failure(errObj.Fatal, t)
+		}
+		return c.failure(errors.New(errObj.Message), t)
+	}
+
+	out := zeroValues(t)
+	numValues := t.NumOut()
+	if returnsError(t) {
+		numValues--
+	}
+	if numValues > 0 {
+		v, err := c.convert(result, t.Out(0))
+		if err != nil {
+			return c.failure(err, t)
+		}
+		out[0] = v
+	}
+	return out
+}
+
+// failure errをtの最後の戻り値として返す. tがerrorを返さない関数であればpanicする
+func (c converter) failure(err error, t reflect.Type) []reflect.Value {
+	if !returnsError(t) {
+		panic(err)
+	}
+	out := zeroValues(t)
+	out[len(out)-1] = reflect.ValueOf(&err).Elem()
+	return out
+}
+
+func zeroValues(t reflect.Type) []reflect.Value {
+	out := make([]reflect.Value, t.NumOut())
+	for i := range out {
+		out[i] = reflect.Zero(t.Out(i))
+	}
+	return out
+}
+
+func returnsError(t reflect.Type) bool {
+	return t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+}
+
+// WrapFunc Goの関数をbuiltin関数へ変換する
+// 引数はFromObjectで, 戻り値はToObjectで変換する
+// 関数型の引数にはMonkeyの関数(クロージャ)も渡せる
+// 戻り値は (), (T), (error), (T, error) のいずれかの形をとる. 返されたerrorはERRORオブジェクトになる
+func WrapFunc(fn interface{}) (*object.Builtin, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, fmt.Errorf("not a function: %T", fn)
+	}
+	return wrapFunc(rv)
+}
+
+func wrapFunc(rv reflect.Value) (*object.Builtin, error) {
+	t := rv.Type()
+
+	switch {
+	case t.NumOut() > 2,
+		t.NumOut() == 2 && t.Out(1) != errorType:
+		return nil, fmt.Errorf("unsupported function signature: %s", t)
+	}
+	returnsErr := returnsError(t)
+
+	numIn := t.NumIn()
+	arity := numIn
+	if t.IsVariadic() {
+		arity = object.Variadic
+	}
+
+	return &object.Builtin{
+		Arity: arity,
+		CallerFn: func(caller object.Caller, args ...object.Object) object.Object {
+			c := converter{caller: caller}
+			if t.IsVariadic() && len(args) < numIn-1 {
+				return newError("wrong number of arguments. got=%d, want at least %d", len(args), numIn-1)
+			}
+			if !t.IsVariadic() && len(args) != numIn {
+				return newError("wrong number of arguments. got=%d, want=%d", len(args), numIn)
+			}
+
+			in := make([]reflect.Value, len(args))
+			for i, arg := range args {
+				var argType reflect.Type
+				if t.IsVariadic() && i >= numIn-1 {
+					argType = t.In(numIn - 1).Elem()
+				} else {
+					argType = t.In(i)
+				}
+				v, err := c.convert(arg, argType)
+				if err != nil {
+					return newError("argument %d: %s", i+1, err)
+				}
+				in[i] = v
+			}
+
+			out := rv.Call(in)
+			if returnsErr {
+				if err, _ := out[len(out)-1].Interface().(error); err != nil {
+					return newError("%s", err)
+				}
+				out = out[:len(out)-1]
+			}
+			if len(out) == 0 {
+				return object.NULL
+			}
+
+			result, err := toObject(out[0], map[goVisit]bool{})
+			if err != nil {
+				return newError("return value: %s", err)
+			}
+			return result
+		},
+	}, nil
+}
+
+// RegisterGoFunction Goの関数を変換してbuiltin関数として登録する (WrapFuncを参照)
+func (in *Interpreter) RegisterGoFunction(name string, fn interface{}) error {
+	builtin, err := WrapFunc(fn)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return in.RegisterBuiltin(name, builtin)
+}
+
+// SetGlobalValue Goの値を変換してグローバル変数に設定する (ToObjectを参照)
+func (in *Interpreter) SetGlobalValue(name string, v interface{}) error {
+	obj, err := ToObject(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	in.SetGlobal(name, obj)
+	return nil
+}
+
+// GlobalValue グローバル変数の値をvが指す変数へ変換して代入する
+func (in *Interpreter) GlobalValue(name string, v interface{}) error {
+	obj, ok := in.Global(name)
+	if !ok {
+		return fmt.Errorf("global %s is not defined", name)
+	}
+
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("non-pointer destination: %T", v)
+	}
+	value, err := converter{caller: hostCaller{in}}.convert(obj, ptr.Type().Elem())
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	ptr.Elem().Set(value)
+	return nil
+}
+
+// hostCaller Runの外からInterpreter.Callで関数を呼び出す
+type hostCaller struct {
+	in *Interpreter
+}
+
+func (c hostCaller) Call(fn object.Object, args ...object.Object) object.Object {
+	result, err := c.in.Call(fn, args...)
+	if err != nil {
+		return &object.Error{Message: err.Error(), Fatal: err}
+	}
+	return result
+}
+
+// newError 変換した関数の失敗を表すERRORを生成する. スクリプトへは例外として投げる
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Failure: true}
+}
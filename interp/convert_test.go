@@ -0,0 +1,308 @@
+package interp
+
+import (
+	"errors"
+	"monkey/object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X      int
+	Y      int    `monkey:"y"`
+	Label  string `monkey:"-"`
+	hidden int
+}
+
+// valueObject 値レシーバでobject.Objectを実装する型
+type valueObject struct{}
+
+func (valueObject) Type() object.ObjectType { return "VALUE" }
+func (valueObject) Inspect() string         { return "value" }
+
+// node 自身を参照できるGoの値
+type node struct {
+	Next *node
+}
+
+func TestToObject(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{42, "42"},
+		{uint8(7), "7"},
+		{"monkey", "monkey"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{[]interface{}{1, "a", nil}, "[1, a, null]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{point{X: 1, Y: 2, Label: "ignored"}, ""},
+		{&point{X: 1}, ""},
+		{(*point)(nil), "null"},
+		{&object.Integer{Value: 5}, "5"},
+		{valueObject{}, "value"},
+		{[]*node{{}, nil}, "[{Next: null}, null]"},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Errorf("ToObject(%#v) failed: %s", tt.input, err)
+			continue
+		}
+		if tt.expected != "" && obj.Inspect() != tt.expected {
+			t.Errorf("ToObject(%#v) wrong. want=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	obj, err := ToObject(point{X: 1, Y: 2})
+	if err != nil {
+		t.Fatalf("ToObject failed: %s", err)
+	}
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		t.Fatalf("struct is not converted to Hash. got=%T", obj)
+	}
+	if hash.Inspect() != "{X: 1, y: 2}" {
+		t.Errorf("wrong fields. got=%s", hash.Inspect())
+	}
+
+	if _, err := ToObject(1.5); err == nil {
+		t.Errorf("expected error for float")
+	}
+	if _, err := ToObject(map[point]int{{}: 1}); err == nil {
+		t.Errorf("expected error for unusable hash key")
+	}
+
+	// 自身を参照する値はエラー
+	n := &node{}
+	n.Next = n
+	m := map[string]interface{}{}
+	m["self"] = m
+	s := []interface{}{nil}
+	s[0] = s
+	for _, cyclic := range []interface{}{n, m, s} {
+		if _, err := ToObject(cyclic); err == nil || !strings.Contains(err.Error(), "cyclic value") {
+			t.Errorf("expected cyclic value error for %T. got=%v", cyclic, err)
+		}
+	}
+	// 同じ値を複数回参照するだけなら循環ではない
+	shared := &node{}
+	obj, err = ToObject([]*node{shared, shared})
+	if err != nil || obj.Inspect() != "[{Next: null}, {Next: null}]" {
+		t.Errorf("wrong shared value conversion. got=%v, err=%v", obj, err)
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	source := &object.Hash{}
+	for _, kv := range []struct {
+		key   string
+		value object.Object
+	}{
+		{"X", &object.Integer{Value: 3}},
+		{"y", &object.Integer{Value: 4}},
+	} {
+		source.Set(&object.String{Value: kv.key}, kv.value)
+	}
+
+	var p point
+	v, err := FromObject(source, reflect.TypeOf(p))
+	if err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	p = v.Interface().(point)
+	if p.X != 3 || p.Y != 4 {
+		t.Errorf("wrong struct. got=%+v", p)
+	}
+
+	arr := &object.Array{Elements: []object.Object{&object.Integer{Value: 1}, object.NULL, &object.String{Value: "a"}}}
+	v, err = FromObject(arr, reflect.TypeOf((*interface{})(nil)).Elem())
+	if err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	native := v.Interface().([]interface{})
+	if native[0] != int64(1) || native[1] != nil || native[2] != "a" {
+		t.Errorf("wrong native value. got=%#v", native)
+	}
+
+	if _, err := FromObject(&object.Integer{Value: 300}, reflect.TypeOf(uint8(0))); err == nil {
+		t.Errorf("expected overflow error")
+	}
+	if _, err := FromObject(&object.String{Value: "a"}, reflect.TypeOf(0)); err == nil {
+		t.Errorf("expected conversion error")
+	}
+
+	// STRUCTはフィールド名をキーとして変換する
+	def := &object.StructType{Name: "P", Fields: []string{"X", "y"}}
+	ps, _ := def.New([]object.Object{&object.Integer{Value: 5}, &object.Integer{Value: 6}})
+	v, err = FromObject(ps, reflect.TypeOf(p))
+	if err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	if p = v.Interface().(point); p.X != 5 || p.Y != 6 {
+		t.Errorf("wrong struct. got=%+v", p)
+	}
+
+	// 自身を参照するSTRUCTはエラー
+	nodeDef := &object.StructType{Name: "N", Fields: []string{"next"}}
+	cyclic, _ := nodeDef.New([]object.Object{object.NULL})
+	cyclic.Values[0] = cyclic
+	for _, typ := range []reflect.Type{reflect.TypeOf((*interface{})(nil)).Elem(), reflect.TypeOf(map[string]interface{}{})} {
+		if _, err := FromObject(cyclic, typ); err == nil || !strings.Contains(err.Error(), "cannot convert cyclic N") {
+			t.Errorf("expected cyclic error for %s. got=%v", typ, err)
+		}
+	}
+}
+
+func TestRegisterGoFunction(t *testing.T) {
+	countWords := func(prefix string, lengths []int) (map[string]int, error) {
+		if len(lengths) == 0 {
+			return nil, errors.New("no lengths")
+		}
+		result := map[string]int{}
+		for i, l := range lengths {
+			result[prefix+strings.Repeat("x", i)] = l
+		}
+		return result, nil
+	}
+	sum := func(base int, values ...int) int {
+		for _, v := range values {
+			base += v
+		}
+		return base
+	}
+
+	for _, engine := range engines {
+		in := New(Options{Engine: engine})
+		if err := in.RegisterGoFunction("count", countWords); err != nil {
+			t.Fatalf("[%s] RegisterGoFunction failed: %s", engine, err)
+		}
+		if err := in.RegisterGoFunction("sum", sum); err != nil {
+			t.Fatalf("[%s] RegisterGoFunction failed: %s", engine, err)
+		}
+		if err := in.SetGlobalValue("config", map[string]interface{}{"n": 2}); err != nil {
+			t.Fatalf("[%s] SetGlobalValue failed: %s", engine, err)
+		}
+
+		result, err := in.Run(`let r = count("a", [1, 2]); r["ax"] + sum(1, 2, config["n"]);`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 7)
+
+		var r map[string]int
+		if err := in.GlobalValue("r", &r); err != nil {
+			t.Fatalf("[%s] GlobalValue failed: %s", engine, err)
+		}
+		if !reflect.DeepEqual(r, map[string]int{"a": 1, "ax": 2}) {
+			t.Errorf("[%s] wrong global value. got=%v", engine, r)
+		}
+
+		_, err = in.Run(`count("a", [])`)
+		if err == nil || err.Error() != "no lengths" {
+			t.Errorf("[%s] wrong error. got=%v", engine, err)
+		}
+		_, err = in.Run(`count(1, [])`)
+		if err == nil || err.Error() != "argument 1: cannot convert INTEGER to string" {
+			t.Errorf("[%s] wrong error. got=%v", engine, err)
+		}
+	}
+
+	if _, err := WrapFunc(func() (int, int) { return 0, 0 }); err == nil {
+		t.Errorf("expected error for unsupported signature")
+	}
+}
+
+func TestBuiltinToFunc(t *testing.T) {
+	var length func([]int) int
+	v, err := FromObject(object.GetBuiltinByName("len"), reflect.TypeOf(length))
+	if err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	length = v.Interface().(func([]int) int)
+	if got := length([]int{1, 2, 3}); got != 3 {
+		t.Errorf("wrong result. got=%d", got)
+	}
+
+	var first func([]string) (string, error)
+	v, err = FromObject(object.GetBuiltinByName("len"), reflect.TypeOf(first))
+	if err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	first = v.Interface().(func([]string) (string, error))
+	if _, err := first([]string{"a"}); err == nil {
+		t.Errorf("expected conversion error")
+	}
+}
+
+func TestGoFunctionCallback(t *testing.T) {
+	apply := func(f func(int) (int, error), values []int) ([]int, error) {
+		result := make([]int, len(values))
+		for i, v := range values {
+			r, err := f(v)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = r
+		}
+		return result, nil
+	}
+
+	for _, engine := range engines {
+		in := New(Options{Engine: engine})
+		if err := in.RegisterGoFunction("apply", apply); err != nil {
+			t.Fatalf("[%s] RegisterGoFunction failed: %s", engine, err)
+		}
+
+		result, err := in.Run(`let k = 3; apply(fn(x) { x * k }, [1, 2, 3])`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		if result.Inspect() != "[3, 6, 9]" {
+			t.Errorf("[%s] wrong result. got=%s", engine, result.Inspect())
+		}
+
+		if _, err = in.Run(`apply(fn(x) { x + "a" }, [1])`); err == nil {
+			t.Errorf("[%s] expected error from callback", engine)
+		}
+
+		// コールバックのエラーを処理したホスト関数の後も, 呼び出し元の続きを実行する
+		if err := in.RegisterGoFunction("recover", func(f func() (int, error)) int {
+			if _, err := f(); err != nil {
+				return 5
+			}
+			return 0
+		}); err != nil {
+			t.Fatalf("[%s] RegisterGoFunction failed: %s", engine, err)
+		}
+		result, err = in.Run(`recover(fn() { throw 1 }) + 1`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		if result.Inspect() != "6" {
+			t.Errorf("[%s] wrong result after swallowed callback error. got=%s", engine, result.Inspect())
+		}
+
+		_, err = in.Run(`let inc = fn(x) { x + 1 };`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		var inc func(int) int
+		if err := in.GlobalValue("inc", &inc); err != nil {
+			t.Fatalf("[%s] GlobalValue failed: %s", engine, err)
+		}
+		if got := inc(41); got != 42 {
+			t.Errorf("[%s] wrong result. got=%d", engine, got)
+		}
+	}
+
+	closure := &object.Closure{Fn: &object.CompiledFunction{}}
+	if _, err := FromObject(closure, reflect.TypeOf(func() {})); err == nil {
+		t.Errorf("expected error for closure without interpreter")
+	}
+}
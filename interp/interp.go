@@ -0,0 +1,253 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/module"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+)
+
+// Engine スクリプトの実行方式
+type Engine string
+
+const (
+	// EngineVM compilerでバイトコードへ変換してvmで実行する
+	EngineVM Engine = "vm"
+	// EngineEval evaluatorでASTを直接評価する
+	EngineEval Engine = "eval"
+)
+
+// maxBuiltins OpGetBuiltinのオペランドは1byteなので登録できるbuiltin関数は256個まで
+const maxBuiltins = 256
+
+// Options Interpreterの設定. ゼロ値はVMで無制限に実行する
+type Options struct {
+	Engine Engine
+	// Limits 1回のRunごとの実行制限
+	Limits object.Limits
+	// VM スタック・フレームの上限. Limits, Builtins, Globalsは無視される
+	VM vm.Options
+	// Files ファイル操作のbuiltin関数(read_fileなど)がアクセスできるディレクトリ
+	// nilの場合はobject.SetFileRootsで設定したディレクトリ(標準では無し)を使う
+	Files *object.FileSandbox
+	// Loader importでモジュールを読み込むLoader. nilの場合は標準ライブラリ(module.Std)だけをimportできる
+	Loader object.ModuleLoader
+	// Warn コンパイル・評価の前に見つかった警告(網羅的でないmatch式など)を受け取る関数. nilの場合は警告を捨てる
+	Warn func(message string)
+}
+
+// Interpreter Goのアプリケーションへ埋め込むためのインタプリタ
+// 登録した関数・グローバル変数はRunを跨いで保持される
+type Interpreter struct {
+	options  Options
+	builtins []object.BuiltinDefinition
+
+	// EngineVM
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+
+	// EngineEval
+	env *object.Environment
+}
+
+// New 標準のbuiltin関数を登録したInterpreterを生成する
+func New(options Options) *Interpreter {
+	if options.Engine == "" {
+		options.Engine = EngineVM
+	}
+	if options.Loader == nil {
+		options.Loader = module.Std
+	}
+
+	in := &Interpreter{
+		options:     options,
+		symbolTable: compiler.NewSymbolTable(),
+		constants:   []object.Object{},
+		env:         object.NewEnvironment(),
+	}
+	in.env.Imports().Loader = options.Loader
+	in.env.SetWarningHandler(options.Warn)
+	if options.Engine == EngineVM {
+		in.globals = make([]object.Object, vm.GlobalsSize)
+	}
+
+	for _, def := range object.Builtins {
+		in.register(def.Name, def.Builtin)
+	}
+	if options.Files != nil {
+		for _, def := range options.Files.Builtins() {
+			in.register(def.Name, def.Builtin)
+		}
+	}
+	return in
+}
+
+// RegisterFunction Goの関数をスクリプトから呼び出せるbuiltin関数として登録する
+// arityと異なる数の引数で呼び出された場合はエラーを返す. arityがobject.Variadicの場合は検査しない
+// fnが返したERRORは関数の失敗として, スクリプトへ例外として投げる
+// 同名の関数が登録済みであれば置き換える
+func (in *Interpreter) RegisterFunction(name string, arity int, fn object.BuiltinFunction) error {
+	return in.RegisterBuiltin(name, object.NewBuiltin(arity, func(args ...object.Object) object.Object {
+		result := fn(args...)
+		if errObj, ok := result.(*object.Error); ok && errObj.Fatal == nil {
+			errObj.Failure = true
+		}
+		return result
+	}))
+}
+
+// RegisterBuiltin builtin関数を登録する
+func (in *Interpreter) RegisterBuiltin(name string, builtin *object.Builtin) error {
+	if name == "" {
+		return errors.New("builtin name must not be empty")
+	}
+	if in.indexOfBuiltin(name) < 0 && len(in.builtins) >= maxBuiltins {
+		return fmt.Errorf("too many builtins: %s (max %d)", name, maxBuiltins)
+	}
+	in.register(name, builtin)
+	return nil
+}
+
+func (in *Interpreter) register(name string, builtin *object.Builtin) {
+	def := object.BuiltinDefinition{Name: name, Builtin: builtin}
+
+	index := in.indexOfBuiltin(name)
+	if index < 0 {
+		index = len(in.builtins)
+		in.builtins = append(in.builtins, def)
+	} else {
+		// 実行中のVMが参照しているスライスを書き換えないようにコピーする
+		builtins := make([]object.BuiltinDefinition, len(in.builtins))
+		copy(builtins, in.builtins)
+		builtins[index] = def
+		in.builtins = builtins
+	}
+
+	in.symbolTable.DefineBuiltin(index, name)
+	in.env.Set(name, builtin)
+}
+
+func (in *Interpreter) indexOfBuiltin(name string) int {
+	for i, def := range in.builtins {
+		if def.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetGlobal スクリプトのグローバル変数に値を設定する
+func (in *Interpreter) SetGlobal(name string, value object.Object) {
+	if in.options.Engine == EngineEval {
+		in.env.Set(name, value)
+		return
+	}
+
+	symbol, ok := in.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		symbol = in.symbolTable.Define(name)
+	}
+	in.globals[symbol.Index] = value
+}
+
+// Global スクリプトのグローバル変数の値を取得する
+func (in *Interpreter) Global(name string) (object.Object, bool) {
+	if in.options.Engine == EngineEval {
+		return in.env.Get(name)
+	}
+
+	symbol, ok := in.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return nil, false
+	}
+	value := in.globals[symbol.Index]
+	return value, value != nil
+}
+
+// Run ソースコードを実行し, 最後に評価した式の値を返す
+// 構文エラー・コンパイルエラー・実行時エラーの場合はerrorを返す
+func (in *Interpreter) Run(input string) (object.Object, error) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(p.Errors(), "\n\t"))
+	}
+
+	var result object.Object
+	if in.options.Engine == EngineEval {
+		result = evaluator.EvalWithLimits(program, in.env, in.options.Limits)
+	} else {
+		// コンパイルに失敗した場合に途中で定義したシンボルが残らないよう, 複製したシンボル表でコンパイルする
+		symbolTable := in.symbolTable.Clone()
+		comp := compiler.NewWithState(symbolTable, in.constants)
+		comp.SetLoader(in.options.Loader)
+		err := comp.Compile(program)
+		if err != nil {
+			return nil, fmt.Errorf("compilation failed: %w", err)
+		}
+		in.warn(comp.Warnings())
+		bytecode := comp.Bytecode()
+		in.symbolTable = symbolTable
+		in.constants = bytecode.Constants
+
+		machine := vm.NewWithOptions(bytecode, in.vmOptions())
+		err = machine.Run()
+		if err != nil {
+			return nil, err
+		}
+		result = machine.LastPoppedStackElem()
+	}
+
+	return toResult(result)
+}
+
+func (in *Interpreter) warn(warnings []string) {
+	if in.options.Warn == nil {
+		return
+	}
+	for _, w := range warnings {
+		in.options.Warn(w)
+	}
+}
+
+// Call スクリプトで定義した関数(クロージャ)やbuiltin関数をargsで呼び出す
+// 関数の実行にもOptions.Limitsが適用される
+func (in *Interpreter) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	if in.options.Engine == EngineEval {
+		return toResult(evaluator.CallWithLimits(fn, args, in.options.Limits))
+	}
+
+	machine := vm.NewWithOptions(&compiler.Bytecode{Constants: in.constants}, in.vmOptions())
+	result, err := machine.Call(fn, args...)
+	if err != nil {
+		return nil, err
+	}
+	return toResult(result)
+}
+
+func (in *Interpreter) vmOptions() vm.Options {
+	options := in.options.VM
+	options.Limits = in.options.Limits
+	options.Builtins = in.builtins
+	options.Globals = in.globals
+	return options
+}
+
+// toResult スクリプトの値をRun・Callの戻り値へ変換する
+// catchされなかった例外と実行制限の超過(Fatalを持つERROR)はerrorとして返し, 値としてのERRORはそのまま返す
+func toResult(result object.Object) (object.Object, error) {
+	if result == nil {
+		result = object.NULL
+	}
+	if errObj, ok := result.(*object.Error); ok && errObj.Fatal != nil {
+		return nil, errObj.Fatal
+	}
+	return result, nil
+}
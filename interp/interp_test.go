@@ -0,0 +1,403 @@
+package interp
+
+import (
+	"errors"
+	"monkey/module"
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var engines = []Engine{EngineVM, EngineEval}
+
+func TestRegisterFunction(t *testing.T) {
+	for _, engine := range engines {
+		in := New(Options{Engine: engine})
+		err := in.RegisterFunction("double", 1, func(args ...object.Object) object.Object {
+			i, ok := args[0].(*object.Integer)
+			if !ok {
+				return &object.Error{Message: "double: not an integer"}
+			}
+			return &object.Integer{Value: i.Value * 2}
+		})
+		if err != nil {
+			t.Fatalf("[%s] RegisterFunction failed: %s", engine, err)
+		}
+
+		result, err := in.Run(`let f = fn(x) { double(x) + 1 }; f(20);`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 41)
+
+		_, err = in.Run(`double(1, 2)`)
+		if err == nil || err.Error() != "wrong number of arguments. got=2, want=1" {
+			t.Errorf("[%s] wrong arity error. got=%v", engine, err)
+		}
+	}
+}
+
+func TestOverrideBuiltin(t *testing.T) {
+	for _, engine := range engines {
+		in := New(Options{Engine: engine})
+		var printed []string
+		err := in.RegisterFunction("puts", object.Variadic, func(args ...object.Object) object.Object {
+			for _, arg := range args {
+				printed = append(printed, arg.Inspect())
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("[%s] RegisterFunction failed: %s", engine, err)
+		}
+
+		_, err = in.Run(`puts("a", len([1, 2]))`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		if len(printed) != 2 || printed[0] != "a" || printed[1] != "2" {
+			t.Errorf("[%s] puts was not overridden. got=%v", engine, printed)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	for _, engine := range engines {
+		in := New(Options{Engine: engine})
+		in.SetGlobal("base", &object.Integer{Value: 10})
+
+		_, err := in.Run(`let total = base * 2;`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+
+		total, ok := in.Global("total")
+		if !ok {
+			t.Fatalf("[%s] global total is not defined", engine)
+		}
+		testInteger(t, engine, total, 20)
+
+		in.SetGlobal("base", &object.Integer{Value: 1})
+		result, err := in.Run(`base + total`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 21)
+
+		if _, ok := in.Global("undefined"); ok {
+			t.Errorf("[%s] undefined global was found", engine)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	for _, engine := range engines {
+		in := New(Options{Engine: engine, Limits: object.Limits{MaxInstructions: 1000}})
+
+		if _, err := in.Run(`let = 1;`); err == nil {
+			t.Errorf("[%s] expected parser error", engine)
+		}
+		if _, err := in.Run(`len(1)`); err == nil || err.Error() != "argument to `len` not supported. got INTEGER" {
+			t.Errorf("[%s] wrong error. got=%v", engine, err)
+		}
+
+		_, err := in.Run(`let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) } }; f(900);`)
+		if !errors.Is(err, object.ErrInstructionLimit) {
+			t.Errorf("[%s] expected instruction limit error. got=%v", engine, err)
+		}
+	}
+}
+
+func TestRunCompileError(t *testing.T) {
+	in := New(Options{Engine: EngineVM})
+	if _, err := in.Run(`let total = 1;`); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+
+	// コンパイルに失敗したプログラムの変数は定義されない
+	tests := []string{
+		`let x = 1; y`,
+		`let f = fn() { 1 }; bad`,
+	}
+	for _, input := range tests {
+		if _, err := in.Run(input); err == nil {
+			t.Fatalf("expected compilation error for %q", input)
+		}
+	}
+	for _, input := range []string{`x + 1`, `f()`} {
+		if _, err := in.Run(input); err == nil || !strings.Contains(err.Error(), "undefined variable") {
+			t.Errorf("expected undefined variable error for %q. got=%v", input, err)
+		}
+	}
+
+	result, err := in.Run(`let x = 5; total + x`)
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	testInteger(t, EngineVM, result, 6)
+}
+
+func testInteger(t *testing.T, engine Engine, obj object.Object, expected int64) {
+	t.Helper()
+
+	i, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("[%s] object is not Integer. got=%T (%+v)", engine, obj, obj)
+		return
+	}
+	if i.Value != expected {
+		t.Errorf("[%s] object has wrong value. got=%d, want=%d", engine, i.Value, expected)
+	}
+}
+
+func TestCall(t *testing.T) {
+	for _, engine := range engines {
+		in := New(Options{Engine: engine, Limits: object.Limits{MaxInstructions: 1000}})
+		err := in.RegisterBuiltin("twice", object.NewCallerBuiltin(2, func(c object.Caller, args ...object.Object) object.Object {
+			return c.Call(args[0], c.Call(args[0], args[1]))
+		}))
+		if err != nil {
+			t.Fatalf("[%s] RegisterBuiltin failed: %s", engine, err)
+		}
+
+		_, err = in.Run(`let base = 10; let add = fn(x) { x + base }; let r = twice(add, 1);`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		r, _ := in.Global("r")
+		testInteger(t, engine, r, 21)
+
+		add, _ := in.Global("add")
+		result, err := in.Call(add, &object.Integer{Value: 5})
+		if err != nil {
+			t.Fatalf("[%s] Call failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 15)
+
+		if _, err := in.Call(add); err == nil {
+			t.Errorf("[%s] expected arity error", engine)
+		}
+
+		_, err = in.Run(`let loop = fn(x) { loop(x + 1) };`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		loop, _ := in.Global("loop")
+		_, err = in.Call(loop, &object.Integer{Value: 0})
+		if !errors.Is(err, object.ErrInstructionLimit) {
+			t.Errorf("[%s] expected instruction limit error. got=%v", engine, err)
+		}
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	files, err := object.NewFileSandbox(dir)
+	if err != nil {
+		t.Fatalf("NewFileSandbox failed: %s", err)
+	}
+	defer files.Close()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"write_file(\"a.txt\", \"one\n\")", "null"},
+		{"append_file(\"a.txt\", \"two\r\n\")", "null"},
+		{`read_file("a.txt")`, "one\ntwo\r\n"},
+		{`read_lines("a.txt")`, "[one, two]"},
+		{`exists("a.txt")`, "true"},
+		{`exists("b.txt")`, "false"},
+		{`list_dir(".")`, "[a.txt, link]"},
+		{`len(read_lines("a.txt")) + len(list_dir("` + dir + `"))`, "4"},
+	}
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`read_file("b.txt")`, "read_file: b.txt: no such file or directory"},
+		{`read_file("../x")`, "read_file: ../x: access denied: outside of the allowed directories"},
+		{`exists("` + outside + `")`, "exists: " + outside + ": access denied: outside of the allowed directories"},
+		{`read_file("link/secret.txt")`, "read_file: link/secret.txt: path escapes from parent"},
+		{`write_file("c.txt", 1)`, "argument to `write_file` must be STRING, got INTEGER"},
+		{`read_file("")`, "argument to `read_file` must not be empty"},
+	}
+
+	for _, engine := range engines {
+		os.Remove(filepath.Join(dir, "a.txt"))
+		in := New(Options{Engine: engine, Files: files})
+		for _, tt := range tests {
+			result, err := in.Run(tt.input)
+			if err != nil {
+				t.Errorf("[%s] %s: Run failed: %s", engine, tt.input, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("[%s] %s: wrong result. got=%q, want=%q", engine, tt.input, result.Inspect(), tt.expected)
+			}
+		}
+		for _, tt := range errorTests {
+			_, err := in.Run(tt.input)
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("[%s] %s: wrong error. got=%v, want=%q", engine, tt.input, err, tt.expected)
+			}
+		}
+
+		// Filesを指定しなければファイル操作は禁止
+		_, err = New(Options{Engine: engine}).Run(`read_file("a.txt")`)
+		if err == nil || err.Error() != "read_file: file access is disabled" {
+			t.Errorf("[%s] file access should be disabled by default. got=%v", engine, err)
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	source := `tick(); let n = 40; export let add = fn(x) { x + n };`
+	if err := os.WriteFile(filepath.Join(dir, "counter.monkey"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loader := module.NewLoader(dir)
+
+	for _, engine := range engines {
+		in := New(Options{Engine: engine, Loader: loader})
+		ticks := 0
+		in.RegisterFunction("tick", 0, func(args ...object.Object) object.Object {
+			ticks++
+			return nil
+		})
+
+		if _, err := in.Run(`let m = import "counter"; let n = 1;`); err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		// 2回目のimportではモジュールを評価しない. メインのnとモジュールのnは別の変数
+		result, err := in.Run(`let again = import "counter"; again["add"](n) + m["add"](0)`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 81)
+		if ticks != 1 {
+			t.Errorf("[%s] module should be evaluated once. got=%d", engine, ticks)
+		}
+
+		m, _ := in.Global("m")
+		add, _ := m.(*object.Hash).Get(&object.String{Value: "add"})
+		result, err = in.Call(add, &object.Integer{Value: 2})
+		if err != nil {
+			t.Fatalf("[%s] Call failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 42)
+
+		if _, err := New(Options{Engine: engine}).Run(`import "counter"`); err == nil {
+			t.Errorf("[%s] import without loader should fail", engine)
+		}
+	}
+}
+
+func TestStdLibraryByDefault(t *testing.T) {
+	for _, engine := range engines {
+		result, err := New(Options{Engine: engine}).Run(`let list = import "std/list"; list["sum"]([1, 2, 3])`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 6)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	for _, engine := range engines {
+		in := New(Options{Engine: engine})
+		err := in.RegisterFunction("fail", 1, func(args ...object.Object) object.Object {
+			return &object.Error{Message: "fail: " + args[0].Inspect()}
+		})
+		if err != nil {
+			t.Fatalf("[%s] RegisterFunction failed: %s", engine, err)
+		}
+
+		// 登録した関数のエラーもcatchできる
+		result, err := in.Run(`try { fail(1) } catch (e) { 2 } finally { 3 }`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 2)
+
+		_, err = in.Run(`let f = fn(x) { throw x * 2 }; f(21)`)
+		var thrown *object.Exception
+		if !errors.As(err, &thrown) || err.Error() != "uncaught exception: 42" {
+			t.Fatalf("[%s] wrong error. got=%v", engine, err)
+		}
+		testInteger(t, engine, thrown.Value, 42)
+
+		f, _ := in.Global("f")
+		if _, err := in.Call(f, &object.Integer{Value: 1}); !errors.As(err, &thrown) {
+			t.Errorf("[%s] Call should return the thrown value. got=%v", engine, err)
+		}
+
+		// catchされなかった実行時エラーもerrorで返す
+		if _, err := in.Run(`1 / 0`); !errors.As(err, &thrown) || err.Error() != "division by zero" {
+			t.Errorf("[%s] wrong error. got=%v", engine, err)
+		}
+
+		// 値としてのERRORはそのまま返す
+		for _, input := range []string{`let r = error("x"); r`, `try { throw error("x") } catch (e) { e }`, `fn() { error("x") }()`} {
+			result, err := in.Run(input)
+			if err != nil {
+				t.Fatalf("[%s] %s: Run failed: %s", engine, input, err)
+			}
+			if errObj, ok := result.(*object.Error); !ok || errObj.Message != "x" {
+				t.Errorf("[%s] %s: wrong result. got=%+v", engine, input, result)
+			}
+		}
+		result, err = in.Run(`let g = fn() { try { len(1) } catch (e) { e } }; g`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		result, err = in.Call(result)
+		if errObj, ok := result.(*object.Error); err != nil || !ok || errObj.Message != "argument to `len` not supported. got INTEGER" {
+			t.Errorf("[%s] Call should return the error value. got=%+v, %v", engine, result, err)
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	dir := t.TempDir()
+	source := `export let sign = fn(n) { match n > 0 { true => 1, false => -1 } };`
+	if err := os.WriteFile(filepath.Join(dir, "sign.monkey"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "sign.monkey")
+
+	for _, engine := range engines {
+		var warnings []string
+		in := New(Options{Engine: engine, Loader: module.NewLoader(dir), Warn: func(message string) {
+			warnings = append(warnings, message)
+		}})
+
+		result, err := in.Run(`let f = fn(x) { match x { 1 => "one", _ => "many" } }; match 2 { 1 => 1, 2 => 2 }`)
+		if err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 2)
+		if _, err := in.Run(`import "sign"`); err != nil {
+			t.Fatalf("[%s] Run failed: %s", engine, err)
+		}
+
+		expected := []string{
+			"match on 2 has no wildcard arm and may not be exhaustive",
+			"module " + path + ": match on (n > 0) has no wildcard arm and may not be exhaustive",
+		}
+		if !reflect.DeepEqual(warnings, expected) {
+			t.Errorf("[%s] wrong warnings.\nwant=%q\ngot=%q", engine, expected, warnings)
+		}
+	}
+}
@@ -1,13 +1,35 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"monkey/object"
 	"monkey/repl"
 	"os"
 	"os/user"
+	"strings"
 )
 
+// dirsFlag 複数回指定できるディレクトリのフラグ
+type dirsFlag []string
+
+func (d *dirsFlag) String() string { return strings.Join(*d, ",") }
+
+func (d *dirsFlag) Set(dir string) error {
+	*d = append(*d, dir)
+	return nil
+}
+
 func main() {
+	var allowDirs dirsFlag
+	flag.Var(&allowDirs, "allow-dir", "directory that file builtins may access (repeatable). file access is disabled by default")
+	flag.Parse()
+
+	if err := object.SetFileRoots(allowDirs...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
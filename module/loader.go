@@ -0,0 +1,110 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Ext モジュールのファイルの拡張子. importのパスで省略した場合に補う
+const Ext = ".monkey"
+
+// Loader importのパスをファイルへ解決し, 構文解析したモジュールをキャッシュする
+//
+//	import "./util"   importを書いたファイル(メインプログラムではBaseDir)からの相対パス
+//	import "lib/util" SearchPathのディレクトリを前から順に探す
+//	import "std/list" 埋め込みの標準ライブラリ(Std)
+type Loader struct {
+	// BaseDir メインプログラムからの相対パスの基準. 空の場合はカレントディレクトリ
+	BaseDir string
+	// SearchPath 相対パスでないimportを探すディレクトリ
+	SearchPath []string
+
+	mu       sync.Mutex
+	programs map[string]*ast.Program
+}
+
+// NewLoader searchPathからモジュールを探すLoaderを生成する
+func NewLoader(searchPath ...string) *Loader {
+	return &Loader{SearchPath: searchPath}
+}
+
+// Resolve fromのファイルに書かれたimportのpathを, モジュールのファイルの絶対パスへ解決する
+// fromが空の場合はメインプログラムからのimportとして扱う
+func (l *Loader) Resolve(from, path string) (string, error) {
+	if path == "" {
+		return "", errors.New("import path must not be empty")
+	}
+	if isStd(from, path) {
+		return Std.Resolve(from, path)
+	}
+	name := path
+	if filepath.Ext(name) == "" {
+		name += Ext
+	}
+
+	var candidates []string
+	switch {
+	case filepath.IsAbs(name):
+		candidates = []string{name}
+	case strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../"):
+		dir := l.BaseDir
+		if from != "" {
+			dir = filepath.Dir(from)
+		}
+		candidates = []string{filepath.Join(dir, name)}
+	default:
+		for _, dir := range l.SearchPath {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		return filepath.Abs(candidate)
+	}
+	return "", fmt.Errorf("cannot find module %q", path)
+}
+
+// Load Resolveで解決したパスのモジュールを読み込み, 構文解析する. 同じパスは2回目以降キャッシュを返す
+func (l *Loader) Load(path string) (*ast.Program, error) {
+	if isStd("", path) {
+		return Std.Load(path)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if program, ok := l.programs[path]; ok {
+		return program, nil
+	}
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("parser errors in %s:\n\t%s", path, strings.Join(p.Errors(), "\n\t"))
+	}
+
+	if l.programs == nil {
+		l.programs = map[string]*ast.Program{}
+	}
+	l.programs[path] = program
+	return program, nil
+}
+
+// Library 起動時にコンパイルした標準ライブラリを返す. compiler.LibraryLoaderを満たす
+func (l *Loader) Library() *compiler.Library { return stdLibrary }
@@ -0,0 +1,89 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main/util.monkey", "main/sub/x.monkey", "lib/a.monkey", "lib2/a.monkey", "lib2/b.monkey"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := NewLoader(filepath.Join(dir, "lib"), filepath.Join(dir, "lib2"))
+	l.BaseDir = filepath.Join(dir, "main")
+	from := filepath.Join(dir, "main/sub/x.monkey")
+
+	tests := []struct {
+		from     string
+		path     string
+		expected string
+	}{
+		{"", "./util", "main/util.monkey"},
+		{"", "./util.monkey", "main/util.monkey"},
+		{"", "./sub/x", "main/sub/x.monkey"},
+		{from, "../util", "main/util.monkey"},
+		{from, "./x", "main/sub/x.monkey"},
+		{"", "a", "lib/a.monkey"},
+		{from, "b", "lib2/b.monkey"},
+		{"", filepath.Join(dir, "lib2/a"), "lib2/a.monkey"},
+	}
+	for _, tt := range tests {
+		resolved, err := l.Resolve(tt.from, tt.path)
+		if err != nil {
+			t.Errorf("Resolve(%q, %q) failed: %s", tt.from, tt.path, err)
+			continue
+		}
+		if resolved != filepath.Join(dir, tt.expected) {
+			t.Errorf("Resolve(%q, %q) wrong. got=%q, want=%q", tt.from, tt.path, resolved, filepath.Join(dir, tt.expected))
+		}
+	}
+
+	for _, path := range []string{"", "./missing", "util", "./sub"} {
+		if _, err := l.Resolve("", path); err == nil {
+			t.Errorf("Resolve(%q) should fail", path)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "m.monkey")
+	if err := os.WriteFile(path, []byte("export let x = 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLoader()
+	program, err := l.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+	if program.String() != "export let x = 1;" {
+		t.Errorf("program wrong. got=%q", program.String())
+	}
+
+	// 2回目はファイルを読まずにキャッシュを返す
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := l.Load(path)
+	if err != nil || cached != program {
+		t.Errorf("Load should return the cached program. got=%p, %v", cached, err)
+	}
+
+	broken := filepath.Join(dir, "broken.monkey")
+	if err := os.WriteFile(broken, []byte("let x 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Load(broken); err == nil {
+		t.Errorf("Load should fail with parser errors")
+	}
+}
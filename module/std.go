@@ -0,0 +1,100 @@
+package module
+
+import (
+	"fmt"
+	"io/fs"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"monkey/std"
+	"path"
+	"sort"
+	"strings"
+)
+
+// StdPrefix 標準ライブラリのモジュールのimportパスの接頭辞
+const StdPrefix = "std/"
+
+// stdPrograms 起動時に構文解析した標準ライブラリのモジュール. key: importパス(例: "std/list")
+var stdPrograms = parseStd(std.FS)
+
+// stdLibrary 起動時にコンパイルした標準ライブラリのモジュール
+// コンパイラは最初のimportで定数・グローバル変数の位置をずらして取り込むので, ソースからコンパイルし直さない
+var stdLibrary = compileStd()
+
+func parseStd(fsys fs.FS) map[string]*ast.Program {
+	names, err := fs.Glob(fsys, "*"+Ext)
+	if err != nil {
+		panic(err)
+	}
+
+	programs := make(map[string]*ast.Program, len(names))
+	for _, name := range names {
+		source, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			panic(err)
+		}
+		p := parser.New(lexer.New(string(source)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			// 埋め込んだソースの誤りなので起動時に気付けるようにする
+			panic(fmt.Sprintf("parser errors in %s%s:\n\t%s", StdPrefix, name, strings.Join(p.Errors(), "\n\t")))
+		}
+		programs[StdPrefix+strings.TrimSuffix(name, Ext)] = program
+	}
+	return programs
+}
+
+func compileStd() *compiler.Library {
+	lib, err := compiler.CompileLibrary(Std, StdModules()...)
+	if err != nil {
+		// 埋め込んだソースの誤りなので起動時に気付けるようにする
+		panic(fmt.Sprintf("compile error in standard library: %s", err))
+	}
+	return lib
+}
+
+// StdModules 標準ライブラリのモジュールのimportパス
+func StdModules() []string {
+	names := make([]string, 0, len(stdPrograms))
+	for name := range stdPrograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Std 標準ライブラリのモジュールだけをimportできるLoader. ファイルは読み込まない
+var Std = stdLoader{}
+
+type stdLoader struct{}
+
+// Resolve "std/"で始まるパスと, 標準ライブラリのモジュールからの相対パスを解決する
+func (stdLoader) Resolve(from, p string) (string, error) {
+	if strings.HasPrefix(from, StdPrefix) && (strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../")) {
+		p = path.Join(path.Dir(from), p)
+	}
+	p = strings.TrimSuffix(p, Ext)
+	if _, ok := stdPrograms[p]; !ok {
+		return "", fmt.Errorf("cannot find module %q", p)
+	}
+	return p, nil
+}
+
+// Load 起動時に構文解析したモジュールを返す
+func (stdLoader) Load(p string) (*ast.Program, error) {
+	program, ok := stdPrograms[p]
+	if !ok {
+		return nil, fmt.Errorf("cannot find module %q", p)
+	}
+	return program, nil
+}
+
+// Library 起動時にコンパイルした標準ライブラリを返す. compiler.LibraryLoaderを満たす
+func (stdLoader) Library() *compiler.Library { return stdLibrary }
+
+// isStd 標準ライブラリのモジュールとして解決するimportであればtrue
+func isStd(from, p string) bool {
+	return strings.HasPrefix(p, StdPrefix) || strings.HasPrefix(from, StdPrefix)
+}
@@ -0,0 +1,74 @@
+package module
+
+import (
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/parser"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStdModules(t *testing.T) {
+	expected := []string{"std/list", "std/math", "std/string"}
+	if modules := StdModules(); !reflect.DeepEqual(modules, expected) {
+		t.Fatalf("wrong std modules. got=%v, want=%v", modules, expected)
+	}
+
+	// 起動時に全ての標準ライブラリをコンパイルし, importしたコンパイラへ取り込む
+	for _, loader := range []compiler.LibraryLoader{Std, NewLoader()} {
+		if loader.Library() != stdLibrary {
+			t.Errorf("%T should provide the precompiled std library", loader)
+		}
+	}
+	for _, name := range expected {
+		comp := compiler.New()
+		comp.SetLoader(Std)
+		program := parser.New(lexer.New(`import "` + name + `"`)).ParseProgram()
+		if err := comp.Compile(program); err != nil {
+			t.Errorf("%s: compiler error: %s", name, err)
+		}
+	}
+}
+
+func TestStdResolve(t *testing.T) {
+	l := NewLoader()
+	tests := []struct {
+		from     string
+		path     string
+		expected string
+	}{
+		{"", "std/list", "std/list"},
+		{"", "std/list.monkey", "std/list"},
+		{"std/list", "./math", "std/math"},
+		{"std/list", "../std/math", "std/math"},
+	}
+	for _, tt := range tests {
+		for _, loader := range []interface {
+			Resolve(from, path string) (string, error)
+		}{Std, l} {
+			resolved, err := loader.Resolve(tt.from, tt.path)
+			if err != nil || resolved != tt.expected {
+				t.Errorf("%T.Resolve(%q, %q) wrong. got=%q, %v, want=%q", loader, tt.from, tt.path, resolved, err, tt.expected)
+			}
+		}
+	}
+
+	for _, path := range []string{"std/missing", "./list", "list"} {
+		if _, err := Std.Resolve("", path); err == nil {
+			t.Errorf("Std.Resolve(%q) should fail", path)
+		}
+	}
+	if _, err := l.Resolve("std/list", "lib"); err == nil {
+		t.Errorf("std modules should not import files")
+	}
+}
+
+func TestParseStdPanicsOnParserErrors(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("parseStd should panic")
+		}
+	}()
+	parseStd(fstest.MapFS{"broken.monkey": {Data: []byte("let x 1;")}})
+}
@@ -2,29 +2,38 @@ package object
 
 import "fmt"
 
-var Builtins = []struct {
+// BuiltinDefinition 名前付きのbuiltin関数
+// Compilerはスライス上の位置をBuiltinScopeのシンボルのIndexとして使う
+type BuiltinDefinition struct {
 	Name    string
 	Builtin *Builtin
-}{
+}
+
+// Builtins 標準のbuiltin関数. 末尾以外に追加するとコンパイル済みのIndexがずれるので注意
+var Builtins = []BuiltinDefinition{
 	{
 		"len",
-		&Builtin{Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
-			switch arg := args[0].(type) {
-			case *Array:
-				return &Integer{Value: int64(len(arg.Elements))}
-			case *String:
-				return &Integer{Value: int64(len(arg.Value))}
-			default:
-				return newError("argument to `len` not supported. got %s", args[0].Type())
-			}
-		}},
+		&Builtin{
+			Arity: 1,
+			Fn: func(args ...Object) Object {
+				if len(args) != 1 {
+					return newError(KindArgumentError, "wrong number of arguments. got=%d, want=1", len(args))
+				}
+				switch arg := args[0].(type) {
+				case *Array:
+					return &Integer{Value: int64(len(arg.Elements))}
+				case *String:
+					return &Integer{Value: int64(len(arg.Value))}
+				default:
+					return newError(KindTypeError, "argument to `len` not supported. got %s", args[0].Type())
+				}
+			},
+		},
 	},
 	{
 		"puts",
 		&Builtin{
+			Arity: Variadic,
 			Fn: func(args ...Object) Object {
 				for _, arg := range args {
 					fmt.Println(arg.Inspect())
@@ -36,13 +45,15 @@ var Builtins = []struct {
 	{
 		"first",
 		&Builtin{
+			Arity:   1,
+			Borrows: true,
 			Fn: func(args ...Object) Object {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
+					return newError(KindArgumentError, "wrong number of arguments. got=%d, want=1", len(args))
 				}
 
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
+					return newError(KindTypeError, "argument to `first` must be ARRAY, got %s", args[0].Type())
 				}
 
 				arr := args[0].(*Array)
@@ -56,13 +67,15 @@ var Builtins = []struct {
 	{
 		"last",
 		&Builtin{
+			Arity:   1,
+			Borrows: true,
 			Fn: func(args ...Object) Object {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
+					return newError(KindArgumentError, "wrong number of arguments. got=%d, want=1", len(args))
 				}
 
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
+					return newError(KindTypeError, "argument to `last` must be ARRAY, got %s", args[0].Type())
 				}
 
 				arr := args[0].(*Array)
@@ -76,13 +89,14 @@ var Builtins = []struct {
 	{
 		"rest",
 		&Builtin{
+			Arity: 1,
 			Fn: func(args ...Object) Object {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
+					return newError(KindArgumentError, "wrong number of arguments. got=%d, want=1", len(args))
 				}
 
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
+					return newError(KindTypeError, "argument to `rest` must be ARRAY, got %s", args[0].Type())
 				}
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
@@ -102,13 +116,14 @@ var Builtins = []struct {
 	{
 		"push",
 		&Builtin{
+			Arity: 2,
 			Fn: func(args ...Object) Object {
 				if len(args) != 2 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
+					return newError(KindArgumentError, "wrong number of arguments. got=%d, want=1", len(args))
 				}
 
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+					return newError(KindTypeError, "argument to `push` must be ARRAY, got %s", args[0].Type())
 				}
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
@@ -123,6 +138,57 @@ var Builtins = []struct {
 	},
 }
 
+func init() {
+	Builtins = append(Builtins, arrayBuiltins...)
+	Builtins = append(Builtins, stringBuiltins...)
+	Builtins = append(Builtins, hashBuiltins...)
+	Builtins = append(Builtins, jsonBuiltins...)
+	Builtins = append(Builtins, fileBuiltins...)
+	Builtins = append(Builtins, errorBuiltins...)
+	Builtins = append(Builtins, typeBuiltins...)
+	initMethods()
+}
+
+// NewBuiltin 引数の数を検査するbuiltin関数を生成する. arityがVariadicの場合は検査しない
+func NewBuiltin(arity int, fn BuiltinFunction) *Builtin {
+	if arity < 0 {
+		return &Builtin{Arity: Variadic, Fn: fn}
+	}
+
+	return &Builtin{
+		Arity: arity,
+		Fn: func(args ...Object) Object {
+			if len(args) != arity {
+				return newError(KindArgumentError, "wrong number of arguments. got=%d, want=%d", len(args), arity)
+			}
+			return fn(args...)
+		},
+	}
+}
+
+// NewCallerBuiltin Monkeyの関数を呼び出すbuiltin関数を生成する. 引数の数はNewBuiltinと同様に検査する
+func NewCallerBuiltin(arity int, fn CallerFunction) *Builtin {
+	if arity < 0 {
+		return &Builtin{Arity: Variadic, CallerFn: fn}
+	}
+
+	return &Builtin{
+		Arity: arity,
+		CallerFn: func(c Caller, args ...Object) Object {
+			if len(args) != arity {
+				return newError(KindArgumentError, "wrong number of arguments. got=%d, want=%d", len(args), arity)
+			}
+			return fn(c, args...)
+		},
+	}
+}
+
+// borrowing 既存のオブジェクトを返すbuiltin関数として, 戻り値を実行予算に計上しないようにする
+func borrowing(b *Builtin) *Builtin {
+	b.Borrows = true
+	return b
+}
+
 // GetBuiltinByName Buitin関数を取得する
 func GetBuiltinByName(name string) *Builtin {
 	for _, def := range Builtins {
@@ -133,6 +199,7 @@ func GetBuiltinByName(name string) *Builtin {
 	return nil
 }
 
-func newError(format string, a ...interface{}) *Error {
-	return &Error{Message: fmt.Sprintf(format, a...)}
+// newError builtin関数の失敗を表すERRORを生成する
+func newError(kind, format string, a ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...), Failure: true}
 }
@@ -0,0 +1,338 @@
+package object
+
+import "sort"
+
+// maxRangeLength rangeで生成できる配列の最大要素数
+// 実行予算のメモリ計上は生成後なので, 巨大な配列を確保する前にここで止める
+const maxRangeLength = 1 << 24
+
+// arrayBuiltins 配列を操作する高階関数. コールバックにはMonkeyの関数(クロージャ)・builtin関数を渡せる
+var arrayBuiltins = []BuiltinDefinition{
+	{"map", NewCallerBuiltin(2, builtinMap)},
+	{"filter", NewCallerBuiltin(2, builtinFilter)},
+	{"reduce", borrowing(NewCallerBuiltin(3, builtinReduce))},
+	{"each", NewCallerBuiltin(2, builtinEach)},
+	{"find", borrowing(NewCallerBuiltin(2, builtinFind))},
+	{"any", NewCallerBuiltin(2, builtinAny)},
+	{"all", NewCallerBuiltin(2, builtinAll)},
+	{"sort", NewBuiltin(1, builtinSort)},
+	{"sort_by", NewCallerBuiltin(2, builtinSortBy)},
+	{"zip", NewBuiltin(Variadic, builtinZip)},
+	{"flatten", NewBuiltin(1, builtinFlatten)},
+	{"range", NewBuiltin(Variadic, builtinRange)},
+}
+
+// map(arr, fn) 各要素にfnを適用した新しい配列を返す
+func builtinMap(c Caller, args ...Object) Object {
+	arr, errObj := arrayArg("map", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	elements := make([]Object, len(arr.Elements))
+	for i, el := range arr.Elements {
+		result := c.Call(args[1], el)
+		if isError(result) {
+			return result
+		}
+		elements[i] = result
+	}
+	return &Array{Elements: elements}
+}
+
+// filter(arr, fn) fnが真を返した要素だけの新しい配列を返す
+func builtinFilter(c Caller, args ...Object) Object {
+	arr, errObj := arrayArg("filter", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	elements := []Object{}
+	for _, el := range arr.Elements {
+		result := c.Call(args[1], el)
+		if isError(result) {
+			return result
+		}
+		if IsTruthy(result) {
+			elements = append(elements, el)
+		}
+	}
+	return &Array{Elements: elements}
+}
+
+// reduce(arr, initial, fn) fn(acc, el)を先頭から順に適用して畳み込む
+func builtinReduce(c Caller, args ...Object) Object {
+	arr, errObj := arrayArg("reduce", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	acc := args[1]
+	for _, el := range arr.Elements {
+		acc = c.Call(args[2], acc, el)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+// each(arr, fn) 各要素でfnを呼び出す. 戻り値はnull
+func builtinEach(c Caller, args ...Object) Object {
+	arr, errObj := arrayArg("each", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	for _, el := range arr.Elements {
+		if result := c.Call(args[1], el); isError(result) {
+			return result
+		}
+	}
+	return nil
+}
+
+// find(arr, fn) fnが最初に真を返した要素を返す. 見つからなければnull
+func builtinFind(c Caller, args ...Object) Object {
+	arr, errObj := arrayArg("find", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	for _, el := range arr.Elements {
+		result := c.Call(args[1], el)
+		if isError(result) {
+			return result
+		}
+		if IsTruthy(result) {
+			return el
+		}
+	}
+	return nil
+}
+
+// any(arr, fn) fnが真を返す要素が1つでもあればtrue
+func builtinAny(c Caller, args ...Object) Object {
+	arr, errObj := arrayArg("any", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	for _, el := range arr.Elements {
+		result := c.Call(args[1], el)
+		if isError(result) {
+			return result
+		}
+		if IsTruthy(result) {
+			return TRUE
+		}
+	}
+	return FALSE
+}
+
+// all(arr, fn) 全ての要素でfnが真を返せばtrue. 空配列はtrue
+func builtinAll(c Caller, args ...Object) Object {
+	arr, errObj := arrayArg("all", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	for _, el := range arr.Elements {
+		result := c.Call(args[1], el)
+		if isError(result) {
+			return result
+		}
+		if !IsTruthy(result) {
+			return FALSE
+		}
+	}
+	return TRUE
+}
+
+// sort(arr) 整数または文字列の配列を昇順に並べた新しい配列を返す
+func builtinSort(args ...Object) Object {
+	arr, errObj := arrayArg("sort", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	elements := make([]Object, len(arr.Elements))
+	copy(elements, arr.Elements)
+	keys := make([]Object, len(arr.Elements))
+	copy(keys, arr.Elements)
+	if errObj := sortObjects("sort", keys, elements); errObj != nil {
+		return errObj
+	}
+	return &Array{Elements: elements}
+}
+
+// sort_by(arr, fn) fnが返すキー(整数または文字列)の昇順に並べた新しい配列を返す. 安定ソート
+func builtinSortBy(c Caller, args ...Object) Object {
+	arr, errObj := arrayArg("sort_by", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	elements := make([]Object, len(arr.Elements))
+	copy(elements, arr.Elements)
+	keys := make([]Object, len(elements))
+	for i, el := range elements {
+		key := c.Call(args[1], el)
+		if isError(key) {
+			return key
+		}
+		keys[i] = key
+	}
+	if errObj := sortObjects("sort_by", keys, elements); errObj != nil {
+		return errObj
+	}
+	return &Array{Elements: elements}
+}
+
+// sortObjects keysの順にkeysとelementsを並べ替える
+// keysは全て整数または全て文字列でなければならない
+func sortObjects(name string, keys, elements []Object) *Error {
+	if len(keys) == 0 {
+		return nil
+	}
+	keyType := keys[0].Type()
+	if keyType != INTEGER_OBJ && keyType != STRING_OBJ {
+		return newError(KindTypeError, "argument to `%s` must be sorted by INTEGER or STRING, got %s", name, keyType)
+	}
+	for _, key := range keys {
+		if key.Type() != keyType {
+			return newError(KindTypeError, "argument to `%s` has mixed types: %s and %s", name, keyType, key.Type())
+		}
+	}
+
+	sort.Stable(&objectSorter{keys: keys, elements: elements})
+	return nil
+}
+
+type objectSorter struct {
+	keys     []Object
+	elements []Object
+}
+
+func (s *objectSorter) Len() int { return len(s.keys) }
+
+func (s *objectSorter) Less(i, j int) bool {
+	switch key := s.keys[i].(type) {
+	case *Integer:
+		return key.Value < s.keys[j].(*Integer).Value
+	case *String:
+		return key.Value < s.keys[j].(*String).Value
+	}
+	return false
+}
+
+func (s *objectSorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.elements[i], s.elements[j] = s.elements[j], s.elements[i]
+}
+
+// zip(a, b, ...) 各配列の同じ位置の要素をまとめた配列の配列を返す. 長さは最も短い配列に合わせる
+func builtinZip(args ...Object) Object {
+	if len(args) < 2 {
+		return newError(KindArgumentError, "wrong number of arguments. got=%d, want at least 2", len(args))
+	}
+
+	arrays := make([]*Array, len(args))
+	length := -1
+	for i, arg := range args {
+		arr, errObj := arrayArg("zip", arg)
+		if errObj != nil {
+			return errObj
+		}
+		arrays[i] = arr
+		if length < 0 || len(arr.Elements) < length {
+			length = len(arr.Elements)
+		}
+	}
+
+	elements := make([]Object, length)
+	for i := range elements {
+		tuple := make([]Object, len(arrays))
+		for j, arr := range arrays {
+			tuple[j] = arr.Elements[i]
+		}
+		elements[i] = &Array{Elements: tuple}
+	}
+	return &Array{Elements: elements}
+}
+
+// flatten(arr) 入れ子になった配列を再帰的に展開した新しい配列を返す
+func builtinFlatten(args ...Object) Object {
+	arr, errObj := arrayArg("flatten", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return &Array{Elements: flattenElements([]Object{}, arr.Elements)}
+}
+
+func flattenElements(dst, elements []Object) []Object {
+	for _, el := range elements {
+		if inner, ok := el.(*Array); ok {
+			dst = flattenElements(dst, inner.Elements)
+		} else {
+			dst = append(dst, el)
+		}
+	}
+	return dst
+}
+
+// range(end), range(start, end), range(start, end, step)
+// startからendの手前までstepずつ増える整数の配列を返す
+func builtinRange(args ...Object) Object {
+	if len(args) < 1 || len(args) > 3 {
+		return newError(KindArgumentError, "wrong number of arguments. got=%d, want=1..3", len(args))
+	}
+
+	params := []int64{0, 0, 1}
+	for i, arg := range args {
+		integer, ok := arg.(*Integer)
+		if !ok {
+			return newError(KindTypeError, "argument to `range` must be INTEGER, got %s", arg.Type())
+		}
+		params[i] = integer.Value
+	}
+	if len(args) == 1 {
+		params[0], params[1] = 0, params[0]
+	}
+	start, end, step := params[0], params[1], params[2]
+	if step == 0 {
+		return newError(KindValueError, "`range` step must not be 0")
+	}
+
+	// 差がint64に収まらない場合もあるのでuint64で数える
+	var length uint64
+	switch {
+	case step > 0 && start < end:
+		length = (uint64(end)-uint64(start)-1)/uint64(step) + 1
+	case step < 0 && start > end:
+		length = (uint64(start)-uint64(end)-1)/uint64(-step) + 1
+	}
+	if length > maxRangeLength {
+		return newError(KindValueError, "`range` is too large: %d elements (max %d)", length, maxRangeLength)
+	}
+
+	elements := make([]Object, length)
+	for i := range elements {
+		elements[i] = &Integer{Value: start + int64(i)*step}
+	}
+	return &Array{Elements: elements}
+}
+
+func arrayArg(name string, arg Object) (*Array, *Error) {
+	arr, ok := arg.(*Array)
+	if !ok {
+		return nil, newError(KindTypeError, "argument to `%s` must be ARRAY, got %s", name, arg.Type())
+	}
+	return arr, nil
+}
+
+// isError Callerで呼び出した関数が例外を投げた. 関数が値として返したERRORは含まない
+func isError(obj Object) bool {
+	errObj, ok := obj.(*Error)
+	return ok && errObj.Fatal != nil
+}
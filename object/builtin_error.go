@@ -0,0 +1,75 @@
+package object
+
+var errorBuiltins = []BuiltinDefinition{
+	{"error", NewCallerBuiltin(Variadic, builtinError)},
+	{"is_error", NewBuiltin(Variadic, builtinIsError)},
+}
+
+// error(message), error(message, data) ERRORを生成する
+// dataのkindはエラーの種類(デフォルトは"Error"), causeはラップする元のERRORとして扱い, それ以外はe.dataに入る
+//
+//	error("not found", {kind: "NotFound", code: 404, cause: e})
+func builtinError(c Caller, args ...Object) Object {
+	if len(args) < 1 || len(args) > 2 {
+		return newError(KindArgumentError, "wrong number of arguments. got=%d, want=1..2", len(args))
+	}
+	message, ok := args[0].(*String)
+	if !ok {
+		return newError(KindTypeError, "argument to `error` must be STRING, got %s", args[0].Type())
+	}
+
+	errObj := &Error{Message: message.Value, Kind: KindError}
+	if tracer, ok := c.(StackTracer); ok {
+		errObj.Stack = tracer.StackTrace()
+	}
+	if len(args) == 1 {
+		return errObj
+	}
+
+	options, errArg := hashArg("error", args[1])
+	if errArg != nil {
+		return errArg
+	}
+	data := NewHash(options.Len())
+	for _, pair := range options.Pairs() {
+		key, _ := pair.Key.(*String)
+		switch {
+		case key != nil && key.Value == "kind":
+			kind, ok := pair.Value.(*String)
+			if !ok || kind.Value == "" {
+				return newError(KindTypeError, "`error` kind must be a non-empty STRING, got %s", pair.Value.Inspect())
+			}
+			errObj.Kind = kind.Value
+		case key != nil && key.Value == "cause":
+			cause, ok := pair.Value.(*Error)
+			if !ok {
+				return newError(KindTypeError, "`error` cause must be ERROR, got %s", pair.Value.Type())
+			}
+			errObj.Cause = cause
+		default:
+			hashKey, _ := AsHashable(pair.Key)
+			data.Set(hashKey, pair.Value)
+		}
+	}
+	if data.Len() > 0 {
+		errObj.Data = data
+	}
+	return errObj
+}
+
+// is_error(value), is_error(value, kind) valueがERRORであればtrue
+// kindを指定した場合は, valueまたはそのcauseを辿ったERRORのいずれかの種類がkindであればtrue
+func builtinIsError(args ...Object) Object {
+	if len(args) < 1 || len(args) > 2 {
+		return newError(KindArgumentError, "wrong number of arguments. got=%d, want=1..2", len(args))
+	}
+	errObj, ok := args[0].(*Error)
+	if len(args) == 1 || !ok {
+		return NativeBoolToBoolean(ok)
+	}
+	kind, ok := args[1].(*String)
+	if !ok {
+		return newError(KindTypeError, "argument to `is_error` must be STRING, got %s", args[1].Type())
+	}
+	return NativeBoolToBoolean(errObj.HasKind(kind.Value))
+}
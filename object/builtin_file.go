@@ -0,0 +1,285 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrFileAccessDisabled 許可されたディレクトリが1つも設定されていない
+var ErrFileAccessDisabled = errors.New("file access is disabled")
+
+// FileSandbox ファイル操作のbuiltin関数がアクセスできるディレクトリの集合
+// ディレクトリの外へは(..やシンボリックリンクを経由しても)アクセスできない
+// ゼロ値はディレクトリを持たず, 全てのファイル操作がエラーになる
+type FileSandbox struct {
+	mu    sync.RWMutex
+	roots []fileRoot
+}
+
+type fileRoot struct {
+	path string
+	root *os.Root
+}
+
+// NewFileSandbox dirsへのアクセスを許可するFileSandboxを生成する
+// 相対パスは1つ目のディレクトリを基準に解決する
+func NewFileSandbox(dirs ...string) (*FileSandbox, error) {
+	s := &FileSandbox{}
+	if err := s.SetRoots(dirs...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetRoots アクセスを許可するディレクトリを置き換える. dirsが空の場合はファイル操作を禁止する
+func (s *FileSandbox) SetRoots(dirs ...string) error {
+	roots := make([]fileRoot, 0, len(dirs))
+	for _, dir := range dirs {
+		path, err := filepath.Abs(dir)
+		if err == nil {
+			path, err = filepath.EvalSymlinks(path)
+		}
+		var root *os.Root
+		if err == nil {
+			root, err = os.OpenRoot(path)
+		}
+		if err != nil {
+			closeRoots(roots)
+			return fmt.Errorf("cannot allow directory %s: %w", dir, err)
+		}
+		roots = append(roots, fileRoot{path: path, root: root})
+	}
+
+	s.mu.Lock()
+	old := s.roots
+	s.roots = roots
+	s.mu.Unlock()
+	closeRoots(old)
+	return nil
+}
+
+// Close 開いているディレクトリを閉じる. 以降のファイル操作はエラーになる
+func (s *FileSandbox) Close() error {
+	return s.SetRoots()
+}
+
+func closeRoots(roots []fileRoot) {
+	for _, r := range roots {
+		r.root.Close()
+	}
+}
+
+// resolve pathを含むディレクトリと, そのディレクトリからの相対パスを返す
+func (s *FileSandbox) resolve(path string) (*os.Root, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.roots) == 0 {
+		return nil, "", ErrFileAccessDisabled
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.roots[0].path, path)
+	}
+	path = filepath.Clean(path)
+	for _, r := range s.roots {
+		rel, err := filepath.Rel(r.path, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return r.root, rel, nil
+		}
+	}
+	return nil, "", errors.New("access denied: outside of the allowed directories")
+}
+
+// Builtins sのディレクトリを対象にするファイル操作のbuiltin関数
+func (s *FileSandbox) Builtins() []BuiltinDefinition {
+	return []BuiltinDefinition{
+		{"read_file", NewBuiltin(1, s.builtinReadFile)},
+		{"write_file", NewBuiltin(2, s.builtinWriteFile)},
+		{"append_file", NewBuiltin(2, s.builtinAppendFile)},
+		{"read_lines", NewBuiltin(1, s.builtinReadLines)},
+		{"list_dir", NewBuiltin(1, s.builtinListDir)},
+		{"exists", NewBuiltin(1, s.builtinExists)},
+	}
+}
+
+// defaultFileSandbox 標準のbuiltin関数が使うFileSandbox. SetFileRootsで設定するまでファイル操作は禁止
+var defaultFileSandbox = &FileSandbox{}
+
+var fileBuiltins = defaultFileSandbox.Builtins()
+
+// SetFileRoots 標準のファイル操作のbuiltin関数がアクセスできるディレクトリを設定する
+func SetFileRoots(dirs ...string) error {
+	return defaultFileSandbox.SetRoots(dirs...)
+}
+
+// read_file(path) ファイルの内容を文字列として返す
+func (s *FileSandbox) builtinReadFile(args ...Object) Object {
+	content, errObj := s.readFile("read_file", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return &String{Value: content}
+}
+
+// read_lines(path) ファイルの内容を行ごとの文字列の配列として返す. 改行文字(\n, \r\n)は含めない
+func (s *FileSandbox) builtinReadLines(args ...Object) Object {
+	content, errObj := s.readFile("read_lines", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	if content == "" {
+		return &Array{Elements: []Object{}}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return stringsToArray(lines)
+}
+
+func (s *FileSandbox) readFile(name string, arg Object) (string, *Error) {
+	path, errObj := pathArg(name, arg)
+	if errObj != nil {
+		return "", errObj
+	}
+	root, rel, err := s.resolve(path)
+	if err != nil {
+		return "", fileError(name, path, err)
+	}
+
+	f, err := root.Open(rel)
+	if err != nil {
+		return "", fileError(name, path, err)
+	}
+	defer f.Close()
+
+	// 巨大なファイルを全て読み込む前に止める
+	data, err := io.ReadAll(io.LimitReader(f, maxStringLength+1))
+	if err != nil {
+		return "", fileError(name, path, err)
+	}
+	if len(data) > maxStringLength {
+		return "", newError(KindIOError, "%s: %s: file is too large (max %d bytes)", name, path, maxStringLength)
+	}
+	return string(data), nil
+}
+
+// write_file(path, content) contentをファイルへ書き込む. 既存のファイルは上書きする
+func (s *FileSandbox) builtinWriteFile(args ...Object) Object {
+	return s.writeFile("write_file", os.O_TRUNC, args)
+}
+
+// append_file(path, content) contentをファイルの末尾へ追記する. ファイルが無ければ作成する
+func (s *FileSandbox) builtinAppendFile(args ...Object) Object {
+	return s.writeFile("append_file", os.O_APPEND, args)
+}
+
+func (s *FileSandbox) writeFile(name string, flag int, args []Object) Object {
+	path, errObj := pathArg(name, args[0])
+	if errObj != nil {
+		return errObj
+	}
+	content, ok := args[1].(*String)
+	if !ok {
+		return newError(KindTypeError, "argument to `%s` must be STRING, got %s", name, args[1].Type())
+	}
+	root, rel, err := s.resolve(path)
+	if err != nil {
+		return fileError(name, path, err)
+	}
+
+	f, err := root.OpenFile(rel, os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if err != nil {
+		return fileError(name, path, err)
+	}
+	_, err = io.WriteString(f, content.Value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fileError(name, path, err)
+	}
+	return nil
+}
+
+// list_dir(path) ディレクトリ内のファイル名の配列を名前順に返す
+func (s *FileSandbox) builtinListDir(args ...Object) Object {
+	path, errObj := pathArg("list_dir", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	root, rel, err := s.resolve(path)
+	if err != nil {
+		return fileError("list_dir", path, err)
+	}
+
+	f, err := root.Open(rel)
+	if err != nil {
+		return fileError("list_dir", path, err)
+	}
+	defer f.Close()
+
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		return fileError("list_dir", path, err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	sort.Strings(names)
+	return stringsToArray(names)
+}
+
+// exists(path) ファイルまたはディレクトリが存在すればtrue
+func (s *FileSandbox) builtinExists(args ...Object) Object {
+	path, errObj := pathArg("exists", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	root, rel, err := s.resolve(path)
+	if err != nil {
+		return fileError("exists", path, err)
+	}
+
+	_, err = root.Stat(rel)
+	if errors.Is(err, fs.ErrNotExist) {
+		return FALSE
+	}
+	if err != nil {
+		return fileError("exists", path, err)
+	}
+	return TRUE
+}
+
+func pathArg(name string, arg Object) (string, *Error) {
+	path, ok := arg.(*String)
+	if !ok {
+		return "", newError(KindTypeError, "argument to `%s` must be STRING, got %s", name, arg.Type())
+	}
+	if path.Value == "" {
+		return "", newError(KindValueError, "argument to `%s` must not be empty", name)
+	}
+	return path.Value, nil
+}
+
+// fileError Goのエラーをスクリプトのエラーへ変換する
+// PathErrorのパスはsandbox内の相対パスなので, スクリプトが指定したパスに置き換える
+func fileError(name, path string, err error) *Error {
+	if errors.Is(err, ErrFileAccessDisabled) {
+		return newError(KindIOError, "%s: %s", name, err)
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return newError(KindIOError, "%s: %s: %s", name, path, err)
+}
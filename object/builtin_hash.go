@@ -0,0 +1,165 @@
+package object
+
+// hashBuiltins ハッシュを操作する関数
+// 引数のハッシュは変更せず, 変更が必要な操作(delete・merge)は新しいハッシュを返す
+// keys・values・entriesはキーを挿入した順に並べる
+var hashBuiltins = []BuiltinDefinition{
+	{"keys", NewBuiltin(1, builtinKeys)},
+	{"values", NewBuiltin(1, builtinValues)},
+	{"entries", NewBuiltin(1, builtinEntries)},
+	{"from_entries", NewBuiltin(1, builtinFromEntries)},
+	{"has", NewBuiltin(2, builtinHas)},
+	{"get", borrowing(NewBuiltin(3, builtinGet))},
+	{"delete", NewBuiltin(2, builtinDelete)},
+	{"merge", NewBuiltin(Variadic, builtinMerge)},
+}
+
+// keys(h) キーの配列を返す
+func builtinKeys(args ...Object) Object {
+	hash, errObj := hashArg("keys", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	pairs := hash.Pairs()
+	elements := make([]Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = pair.Key
+	}
+	return &Array{Elements: elements}
+}
+
+// values(h) 値の配列を返す
+func builtinValues(args ...Object) Object {
+	hash, errObj := hashArg("values", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	pairs := hash.Pairs()
+	elements := make([]Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = pair.Value
+	}
+	return &Array{Elements: elements}
+}
+
+// entries(h) [キー, 値]の配列を返す. map・filter・eachと組み合わせて反復に使う
+func builtinEntries(args ...Object) Object {
+	hash, errObj := hashArg("entries", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	pairs := hash.Pairs()
+	elements := make([]Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = &Array{Elements: []Object{pair.Key, pair.Value}}
+	}
+	return &Array{Elements: elements}
+}
+
+// from_entries(arr) [キー, 値]の配列からハッシュを生成する. entriesの逆
+func builtinFromEntries(args ...Object) Object {
+	arr, errObj := arrayArg("from_entries", args[0])
+	if errObj != nil {
+		return errObj
+	}
+
+	hash := NewHash(len(arr.Elements))
+	for _, el := range arr.Elements {
+		entry, ok := el.(*Array)
+		if !ok || len(entry.Elements) != 2 {
+			return newError(KindTypeError, "argument to `from_entries` must be ARRAY of [key, value], got %s", el.Inspect())
+		}
+		key, errObj := hashKeyArg(entry.Elements[0])
+		if errObj != nil {
+			return errObj
+		}
+		hash.Set(key, entry.Elements[1])
+	}
+	return hash
+}
+
+// has(h, k) kがキーとして存在すればtrue
+func builtinHas(args ...Object) Object {
+	hash, errObj := hashArg("has", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	key, errObj := hashKeyArg(args[1])
+	if errObj != nil {
+		return errObj
+	}
+
+	_, ok := hash.Get(key)
+	return NativeBoolToBoolean(ok)
+}
+
+// get(h, k, default) kの値を返す. 存在しなければdefaultを返す
+func builtinGet(args ...Object) Object {
+	hash, errObj := hashArg("get", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	key, errObj := hashKeyArg(args[1])
+	if errObj != nil {
+		return errObj
+	}
+
+	if value, ok := hash.Get(key); ok {
+		return value
+	}
+	return args[2]
+}
+
+// delete(h, k) kを取り除いた新しいハッシュを返す
+func builtinDelete(args ...Object) Object {
+	hash, errObj := hashArg("delete", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	key, errObj := hashKeyArg(args[1])
+	if errObj != nil {
+		return errObj
+	}
+
+	result := hash.Copy()
+	result.Delete(key)
+	return result
+}
+
+// merge(a, b, ...) 全てのハッシュを併せた新しいハッシュを返す. 同じキーは最初に現れた位置に後のハッシュの値を使う
+func builtinMerge(args ...Object) Object {
+	if len(args) < 1 {
+		return newError(KindArgumentError, "wrong number of arguments. got=%d, want at least 1", len(args))
+	}
+
+	result := &Hash{}
+	for _, arg := range args {
+		hash, errObj := hashArg("merge", arg)
+		if errObj != nil {
+			return errObj
+		}
+		for _, pair := range hash.Pairs() {
+			result.Set(pair.Key.(Hashable), pair.Value)
+		}
+	}
+	return result
+}
+
+func hashArg(name string, arg Object) (*Hash, *Error) {
+	hash, ok := arg.(*Hash)
+	if !ok {
+		return nil, newError(KindTypeError, "argument to `%s` must be HASH, got %s", name, arg.Type())
+	}
+	return hash, nil
+}
+
+func hashKeyArg(arg Object) (Hashable, *Error) {
+	key, ok := AsHashable(arg)
+	if !ok {
+		return nil, newError(KindTypeError, "unusable as hash key: %s", arg.Type())
+	}
+	return key, nil
+}
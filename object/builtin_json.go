@@ -0,0 +1,227 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// jsonBuiltins JSONとオブジェクトを相互に変換する関数
+// ハッシュのキーは文字列のみ対応し, 挿入順に出力する. JSONの数値は整数のみ対応する
+var jsonBuiltins = []BuiltinDefinition{
+	{"json_encode", NewBuiltin(Variadic, builtinJSONEncode)},
+	{"json_decode", NewBuiltin(1, builtinJSONDecode)},
+}
+
+// json_encode(value), json_encode(value, indent) valueをJSON文字列へ変換する
+// indentには字下げの空白数(整数)または字下げに使う文字列を指定する
+func builtinJSONEncode(args ...Object) Object {
+	if len(args) < 1 || len(args) > 2 {
+		return newError(KindArgumentError, "wrong number of arguments. got=%d, want=1..2", len(args))
+	}
+
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, args[0]); err != nil {
+		return newError(KindTypeError, "json_encode: %s", err)
+	}
+	if len(args) == 1 {
+		return &String{Value: buf.String()}
+	}
+
+	var indent string
+	switch arg := args[1].(type) {
+	case *Integer:
+		if arg.Value < 0 || arg.Value > 16 {
+			return newError(KindValueError, "json_encode: indent must be between 0 and 16. got %d", arg.Value)
+		}
+		indent = strings.Repeat(" ", int(arg.Value))
+	case *String:
+		indent = arg.Value
+	default:
+		return newError(KindTypeError, "argument to `json_encode` must be INTEGER or STRING, got %s", arg.Type())
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", indent); err != nil {
+		return newError(KindValueError, "json_encode: %s", err)
+	}
+	return &String{Value: out.String()}
+}
+
+func encodeJSON(buf *bytes.Buffer, obj Object) error {
+	switch obj := obj.(type) {
+	case *Null:
+		buf.WriteString("null")
+	case *Boolean:
+		buf.WriteString(strconv.FormatBool(obj.Value))
+	case *Integer:
+		buf.WriteString(strconv.FormatInt(obj.Value, 10))
+	case *String:
+		encodeJSONString(buf, obj.Value)
+	case *Array:
+		buf.WriteByte('[')
+		for i, el := range obj.Elements {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, el); err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+		buf.WriteByte(']')
+	case *Hash:
+		buf.WriteByte('{')
+		for i, pair := range obj.Pairs() {
+			key, ok := pair.Key.(*String)
+			if !ok {
+				return fmt.Errorf("unsupported key type %s (keys must be STRING)", pair.Key.Type())
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodeJSONString(buf, key.Value)
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, pair.Value); err != nil {
+				return fmt.Errorf("key %q: %w", key.Value, err)
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unsupported type %s", obj.Type())
+	}
+	return nil
+}
+
+func encodeJSONString(buf *bytes.Buffer, s string) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	// stringのEncodeは失敗しない
+	_ = enc.Encode(s)
+	buf.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+}
+
+// json_decode(s) JSON文字列をオブジェクトへ変換する
+// オブジェクトはハッシュ(キーの出現順), 配列は配列, 数値は整数になる
+func builtinJSONDecode(args ...Object) Object {
+	str, ok := args[0].(*String)
+	if !ok {
+		return newError(KindTypeError, "argument to `json_decode` must be STRING, got %s", args[0].Type())
+	}
+
+	dec := json.NewDecoder(strings.NewReader(str.Value))
+	dec.UseNumber()
+	obj, err := decodeJSON(dec)
+	if err == nil {
+		// 値の後ろに余分な入力が続いていないこと
+		offset := dec.InputOffset()
+		if _, err = dec.Token(); err == io.EOF {
+			return obj
+		} else if err == nil {
+			err = &jsonError{offset: skipSpace(str.Value, offset), msg: "unexpected data after top-level value"}
+		}
+	}
+	return newError(KindValueError, "json_decode: %s", describeJSONError(str.Value, err))
+}
+
+// jsonError 入力上の位置を持つエラー
+type jsonError struct {
+	offset int64
+	msg    string
+}
+
+func (e *jsonError) Error() string { return e.msg }
+
+func decodeJSON(dec *json.Decoder) (Object, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case nil:
+		return NULL, nil
+	case bool:
+		return NativeBoolToBoolean(tok), nil
+	case string:
+		return &String{Value: tok}, nil
+	case json.Number:
+		i, err := strconv.ParseInt(tok.String(), 10, 64)
+		if err != nil {
+			return nil, &jsonError{
+				offset: dec.InputOffset() - int64(len(tok)),
+				msg:    fmt.Sprintf("number %s is not a 64-bit integer", tok),
+			}
+		}
+		return &Integer{Value: i}, nil
+	case json.Delim:
+		switch tok {
+		case '[':
+			elements := []Object{}
+			for dec.More() {
+				el, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, el)
+			}
+			_, err := dec.Token() // ']'
+			return &Array{Elements: elements}, err
+		case '{':
+			hash := &Hash{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				hash.Set(&String{Value: keyTok.(string)}, value)
+			}
+			_, err := dec.Token() // '}'
+			return hash, err
+		}
+	}
+	return nil, fmt.Errorf("unexpected token %v", tok)
+}
+
+// describeJSONError エラーに入力上の位置(行, 列)を付け加える
+func describeJSONError(input string, err error) string {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var valueErr *jsonError
+	switch {
+	case err == io.EOF, err == io.ErrUnexpectedEOF:
+		err = errors.New("unexpected end of input")
+		offset = int64(len(input))
+	case errors.As(err, &syntaxErr):
+		if syntaxErr.Error() == "unexpected end of JSON input" {
+			err = errors.New("unexpected end of input")
+			offset = int64(len(input))
+			break
+		}
+		// Offsetは不正な文字を読んだ直後を指す
+		offset = syntaxErr.Offset - 1
+	case errors.As(err, &valueErr):
+		offset = valueErr.offset
+	default:
+		return err.Error()
+	}
+
+	line := strings.Count(input[:offset], "\n") + 1
+	column := offset - int64(strings.LastIndex(input[:offset], "\n"))
+	return fmt.Sprintf("%s (line %d, column %d)", err, line, column)
+}
+
+func skipSpace(input string, offset int64) int64 {
+	for offset < int64(len(input)) && strings.IndexByte(" \t\r\n", input[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
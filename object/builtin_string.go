@@ -0,0 +1,296 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxStringLength repeat・pad_left・pad_rightで生成できる文字列の最大バイト数
+const maxStringLength = 1 << 24
+
+// stringBuiltins 文字列を操作する関数
+// 位置・長さは(lenと異なり)バイトではなく文字(rune)単位で数える
+var stringBuiltins = []BuiltinDefinition{
+	{"split", NewBuiltin(2, builtinSplit)},
+	{"join", NewBuiltin(2, builtinJoin)},
+	{"trim", NewBuiltin(1, builtinTrim)},
+	{"upper", NewBuiltin(1, builtinUpper)},
+	{"lower", NewBuiltin(1, builtinLower)},
+	{"replace", NewBuiltin(3, builtinReplace)},
+	{"contains", NewBuiltin(2, builtinContains)},
+	{"starts_with", NewBuiltin(2, builtinStartsWith)},
+	{"ends_with", NewBuiltin(2, builtinEndsWith)},
+	{"index_of", NewBuiltin(2, builtinIndexOf)},
+	{"substr", NewBuiltin(Variadic, builtinSubstr)},
+	{"repeat", NewBuiltin(2, builtinRepeat)},
+	{"pad_left", NewBuiltin(Variadic, builtinPadLeft)},
+	{"pad_right", NewBuiltin(Variadic, builtinPadRight)},
+	{"chars", NewBuiltin(1, builtinChars)},
+	{"format", NewBuiltin(Variadic, builtinFormat)},
+}
+
+// split(s, sep) sepで分割した文字列の配列を返す. sepが空文字列なら1文字ずつ分割する
+func builtinSplit(args ...Object) Object {
+	strs, errObj := stringArgs("split", args...)
+	if errObj != nil {
+		return errObj
+	}
+	return stringsToArray(strings.Split(strs[0], strs[1]))
+}
+
+// join(arr, sep) 配列の要素をsepで連結する. 文字列以外の要素はInspectの表記で連結する
+func builtinJoin(args ...Object) Object {
+	arr, errObj := arrayArg("join", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	sep, ok := args[1].(*String)
+	if !ok {
+		return newError(KindTypeError, "argument to `join` must be STRING, got %s", args[1].Type())
+	}
+
+	strs := make([]string, len(arr.Elements))
+	for i, el := range arr.Elements {
+		strs[i] = el.Inspect()
+	}
+	return &String{Value: strings.Join(strs, sep.Value)}
+}
+
+// trim(s) 前後の空白を取り除く
+func builtinTrim(args ...Object) Object {
+	strs, errObj := stringArgs("trim", args...)
+	if errObj != nil {
+		return errObj
+	}
+	return &String{Value: strings.TrimSpace(strs[0])}
+}
+
+// upper(s) 大文字へ変換する
+func builtinUpper(args ...Object) Object {
+	strs, errObj := stringArgs("upper", args...)
+	if errObj != nil {
+		return errObj
+	}
+	return &String{Value: strings.ToUpper(strs[0])}
+}
+
+// lower(s) 小文字へ変換する
+func builtinLower(args ...Object) Object {
+	strs, errObj := stringArgs("lower", args...)
+	if errObj != nil {
+		return errObj
+	}
+	return &String{Value: strings.ToLower(strs[0])}
+}
+
+// replace(s, old, new) 全てのoldをnewへ置き換える
+func builtinReplace(args ...Object) Object {
+	strs, errObj := stringArgs("replace", args...)
+	if errObj != nil {
+		return errObj
+	}
+	return &String{Value: strings.ReplaceAll(strs[0], strs[1], strs[2])}
+}
+
+// contains(s, sub) subを含んでいればtrue
+func builtinContains(args ...Object) Object {
+	strs, errObj := stringArgs("contains", args...)
+	if errObj != nil {
+		return errObj
+	}
+	return NativeBoolToBoolean(strings.Contains(strs[0], strs[1]))
+}
+
+// starts_with(s, prefix) prefixで始まっていればtrue
+func builtinStartsWith(args ...Object) Object {
+	strs, errObj := stringArgs("starts_with", args...)
+	if errObj != nil {
+		return errObj
+	}
+	return NativeBoolToBoolean(strings.HasPrefix(strs[0], strs[1]))
+}
+
+// ends_with(s, suffix) suffixで終わっていればtrue
+func builtinEndsWith(args ...Object) Object {
+	strs, errObj := stringArgs("ends_with", args...)
+	if errObj != nil {
+		return errObj
+	}
+	return NativeBoolToBoolean(strings.HasSuffix(strs[0], strs[1]))
+}
+
+// index_of(s, sub) subが最初に現れる文字位置を返す. 見つからなければ-1
+func builtinIndexOf(args ...Object) Object {
+	strs, errObj := stringArgs("index_of", args...)
+	if errObj != nil {
+		return errObj
+	}
+
+	i := strings.Index(strs[0], strs[1])
+	if i < 0 {
+		return &Integer{Value: -1}
+	}
+	return &Integer{Value: int64(utf8.RuneCountInString(strs[0][:i]))}
+}
+
+// substr(s, start), substr(s, start, length) start文字目からlength文字(省略時は末尾まで)を返す
+// 範囲外の位置は文字列の範囲に切り詰める
+func builtinSubstr(args ...Object) Object {
+	if len(args) < 2 || len(args) > 3 {
+		return newError(KindArgumentError, "wrong number of arguments. got=%d, want=2..3", len(args))
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return newError(KindTypeError, "argument to `substr` must be STRING, got %s", args[0].Type())
+	}
+	runes := []rune(str.Value)
+
+	bounds := []int64{0, int64(len(runes))}
+	for i, arg := range args[1:] {
+		integer, ok := arg.(*Integer)
+		if !ok {
+			return newError(KindTypeError, "argument to `substr` must be INTEGER, got %s", arg.Type())
+		}
+		bounds[i] = integer.Value
+	}
+	start := clamp(bounds[0], 0, int64(len(runes)))
+	end := clamp(start+clamp(bounds[1], 0, int64(len(runes))), start, int64(len(runes)))
+	return &String{Value: string(runes[start:end])}
+}
+
+func clamp(v, min, max int64) int64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// repeat(s, n) sをn回繰り返した文字列を返す
+func builtinRepeat(args ...Object) Object {
+	str, ok := args[0].(*String)
+	if !ok {
+		return newError(KindTypeError, "argument to `repeat` must be STRING, got %s", args[0].Type())
+	}
+	n, ok := args[1].(*Integer)
+	if !ok {
+		return newError(KindTypeError, "argument to `repeat` must be INTEGER, got %s", args[1].Type())
+	}
+	if n.Value < 0 {
+		return newError(KindValueError, "`repeat` count must not be negative. got %d", n.Value)
+	}
+	if len(str.Value) > 0 && n.Value > maxStringLength/int64(len(str.Value)) {
+		return newError(KindValueError, "`repeat` result is too large (max %d bytes)", maxStringLength)
+	}
+	return &String{Value: strings.Repeat(str.Value, int(n.Value))}
+}
+
+// pad_left(s, width), pad_left(s, width, pad) 文字数がwidthになるまで先頭をpad(省略時は空白)で埋める
+func builtinPadLeft(args ...Object) Object {
+	return pad("pad_left", true, args)
+}
+
+// pad_right(s, width), pad_right(s, width, pad) 文字数がwidthになるまで末尾をpad(省略時は空白)で埋める
+func builtinPadRight(args ...Object) Object {
+	return pad("pad_right", false, args)
+}
+
+func pad(name string, left bool, args []Object) Object {
+	if len(args) < 2 || len(args) > 3 {
+		return newError(KindArgumentError, "wrong number of arguments. got=%d, want=2..3", len(args))
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return newError(KindTypeError, "argument to `%s` must be STRING, got %s", name, args[0].Type())
+	}
+	width, ok := args[1].(*Integer)
+	if !ok {
+		return newError(KindTypeError, "argument to `%s` must be INTEGER, got %s", name, args[1].Type())
+	}
+	padding := " "
+	if len(args) == 3 {
+		p, ok := args[2].(*String)
+		if !ok {
+			return newError(KindTypeError, "argument to `%s` must be STRING, got %s", name, args[2].Type())
+		}
+		if p.Value == "" {
+			return newError(KindValueError, "`%s` padding must not be empty", name)
+		}
+		padding = p.Value
+	}
+	if width.Value > maxStringLength {
+		return newError(KindValueError, "`%s` result is too large (max %d bytes)", name, maxStringLength)
+	}
+
+	count := int(width.Value) - utf8.RuneCountInString(str.Value)
+	if count <= 0 {
+		return str
+	}
+	fill := []rune(strings.Repeat(padding, count/utf8.RuneCountInString(padding)+1))[:count]
+	if left {
+		return &String{Value: string(fill) + str.Value}
+	}
+	return &String{Value: str.Value + string(fill)}
+}
+
+// chars(s) 1文字ずつの文字列の配列を返す
+func builtinChars(args ...Object) Object {
+	strs, errObj := stringArgs("chars", args...)
+	if errObj != nil {
+		return errObj
+	}
+	return stringsToArray(strings.Split(strs[0], ""))
+}
+
+// format(f, args...) fmt.Sprintfと同じ書式で文字列を生成する
+// 整数・文字列・真偽値・nullはGoの値として, それ以外はInspectの表記として渡す
+func builtinFormat(args ...Object) Object {
+	if len(args) < 1 {
+		return newError(KindArgumentError, "wrong number of arguments. got=%d, want at least 1", len(args))
+	}
+	f, ok := args[0].(*String)
+	if !ok {
+		return newError(KindTypeError, "argument to `format` must be STRING, got %s", args[0].Type())
+	}
+
+	values := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		switch arg := arg.(type) {
+		case *Integer:
+			values[i] = arg.Value
+		case *String:
+			values[i] = arg.Value
+		case *Boolean:
+			values[i] = arg.Value
+		case *Null:
+			values[i] = nil
+		default:
+			values[i] = arg.Inspect()
+		}
+	}
+	return &String{Value: fmt.Sprintf(f.Value, values...)}
+}
+
+// stringArgs 全ての引数が文字列であることを確認してGoの文字列へ変換する
+func stringArgs(name string, args ...Object) ([]string, *Error) {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := arg.(*String)
+		if !ok {
+			return nil, newError(KindTypeError, "argument to `%s` must be STRING, got %s", name, arg.Type())
+		}
+		strs[i] = str.Value
+	}
+	return strs, nil
+}
+
+func stringsToArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, s := range strs {
+		elements[i] = &String{Value: s}
+	}
+	return &Array{Elements: elements}
+}
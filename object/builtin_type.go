@@ -0,0 +1,10 @@
+package object
+
+var typeBuiltins = []BuiltinDefinition{
+	{"type", NewBuiltin(1, builtinType)},
+}
+
+// type(value) 値の型名. 構造体はstruct宣言の名前, それ以外は"INTEGER"や"HASH"などのObjectType
+func builtinType(args ...Object) Object {
+	return &String{Value: typeName(args[0])}
+}
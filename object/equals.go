@@ -0,0 +1,125 @@
+package object
+
+// Equaler 値として等しいかを比較できるオブジェクト
+type Equaler interface {
+	Equals(other Object) bool
+}
+
+// Equals aとbが値として等しければtrueを返す
+// Equalerを実装していないオブジェクトは同一のオブジェクトの場合だけ等しい
+func Equals(a, b Object) bool {
+	return equals(a, b, nil)
+}
+
+// structPair 比較中の構造体の組
+type structPair struct {
+	a, b *Struct
+}
+
+// equals 比較中の構造体の組comparingを引き継いでaとbを比較する. 配列・ハッシュを経由した循環も検出する
+func equals(a, b Object, comparing map[structPair]bool) bool {
+	switch a := a.(type) {
+	case *Array:
+		return a.equals(b, comparing)
+	case *Hash:
+		return a.equals(b, comparing)
+	case *Struct:
+		return a.equals(b, comparing)
+	}
+	if eq, ok := a.(Equaler); ok {
+		return eq.Equals(b)
+	}
+	return a == b
+}
+
+// Equals meets the object.Equaler interface
+func (i *Integer) Equals(other Object) bool {
+	o, ok := other.(*Integer)
+	return ok && i.Value == o.Value
+}
+
+// Equals meets the object.Equaler interface
+func (b *Boolean) Equals(other Object) bool {
+	o, ok := other.(*Boolean)
+	return ok && b.Value == o.Value
+}
+
+// Equals meets the object.Equaler interface
+func (s *String) Equals(other Object) bool {
+	o, ok := other.(*String)
+	return ok && s.Value == o.Value
+}
+
+// Equals meets the object.Equaler interface
+func (n *Null) Equals(other Object) bool {
+	_, ok := other.(*Null)
+	return ok
+}
+
+// Equals 同じstruct宣言の型で, 全てのフィールドが等しければtrue
+// 循環する構造体は, 比較中の組に再び出会った時点で等しいとみなす
+func (s *Struct) Equals(other Object) bool {
+	return s.equals(other, nil)
+}
+
+func (s *Struct) equals(other Object, comparing map[structPair]bool) bool {
+	o, ok := other.(*Struct)
+	if !ok || s.Def != o.Def {
+		return false
+	}
+	if s == o {
+		return true
+	}
+	pair := structPair{s, o}
+	if comparing[pair] {
+		return true
+	}
+	if comparing == nil {
+		comparing = map[structPair]bool{}
+	}
+	comparing[pair] = true
+
+	for i, value := range s.Values {
+		if !equals(value, o.Values[i], comparing) {
+			return false
+		}
+	}
+	return true
+}
+
+// Equals 同じ長さで, 全ての要素が順に等しければtrue
+func (ao *Array) Equals(other Object) bool {
+	return ao.equals(other, nil)
+}
+
+func (ao *Array) equals(other Object, comparing map[structPair]bool) bool {
+	o, ok := other.(*Array)
+	if !ok || len(ao.Elements) != len(o.Elements) {
+		return false
+	}
+	for i, el := range ao.Elements {
+		if !equals(el, o.Elements[i], comparing) {
+			return false
+		}
+	}
+	return true
+}
+
+// Equals 同じキーの集合を持ち, 各キーの値が等しければtrue. ペアの順序は問わない
+func (h *Hash) Equals(other Object) bool {
+	return h.equals(other, nil)
+}
+
+func (h *Hash) equals(other Object, comparing map[structPair]bool) bool {
+	o, ok := other.(*Hash)
+	if !ok || h.Len() != o.Len() {
+		return false
+	}
+	for _, pair := range h.entries {
+		value, ok := o.Get(pair.Key.(Hashable))
+		if !ok || !equals(pair.Value, value, comparing) {
+			return false
+		}
+	}
+	return true
+}
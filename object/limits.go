@@ -0,0 +1,150 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInstructionLimit 実行できる命令数(evaluatorでは評価ステップ数)の上限を超えた
+	ErrInstructionLimit = errors.New("instruction limit exceeded")
+	// ErrTimeout 実行時間の上限を超えた
+	ErrTimeout = errors.New("execution timed out")
+	// ErrMemoryLimit スクリプトが確保したメモリ量の上限を超えた
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+	// ErrStackOverflow 関数呼び出しの深さ(VMではスタック)が上限に達した
+	ErrStackOverflow = errors.New("stack overflow")
+)
+
+// budgetCheckInterval 時刻とcontextを確認する間隔(ステップ数)
+// 毎ステップtime.Nowを呼ぶとdispatch loopが遅くなるため間引く
+const budgetCheckInterval = 1024
+
+// Limits スクリプト実行時のリソース上限. ゼロ値のフィールドは無制限
+type Limits struct {
+	Context         context.Context
+	MaxInstructions int64
+	Timeout         time.Duration
+	// MaxMemory スクリプトが確保するオブジェクトの累計バイト数(概算)の上限
+	MaxMemory int64
+}
+
+// Budget Limitsに対する消費量を記録する
+// nilのBudgetは無制限として扱う
+type Budget struct {
+	ctx       context.Context
+	maxSteps  int64
+	deadline  time.Time
+	maxMemory int64
+
+	steps     int64
+	allocated int64
+	err       error // 一度上限を超えたら以降は常にこのエラーを返す
+}
+
+// NewBudget Limitsから実行予算を生成する. Timeoutは生成時点から数える
+func NewBudget(limits Limits) *Budget {
+	b := &Budget{
+		ctx:       limits.Context,
+		maxSteps:  limits.MaxInstructions,
+		maxMemory: limits.MaxMemory,
+	}
+	if limits.Timeout > 0 {
+		b.deadline = time.Now().Add(limits.Timeout)
+	}
+	return b
+}
+
+// Step 1ステップ分の予算を消費する. 上限を超えた場合はエラーを返す
+func (b *Budget) Step() error {
+	if b == nil {
+		return nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+
+	b.steps++
+	if b.maxSteps > 0 && b.steps > b.maxSteps {
+		b.err = ErrInstructionLimit
+		return b.err
+	}
+	if b.steps%budgetCheckInterval == 0 {
+		b.err = b.check()
+	}
+	return b.err
+}
+
+// Alloc objが確保したメモリ量を計上する. 上限を超えた場合はエラーを返す
+func (b *Budget) Alloc(obj Object) error {
+	if b == nil {
+		return nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+
+	b.allocated += SizeOf(obj)
+	if b.maxMemory > 0 && b.allocated > b.maxMemory {
+		b.err = ErrMemoryLimit
+	}
+	return b.err
+}
+
+// Allocated これまでに計上したメモリ量(バイト)
+func (b *Budget) Allocated() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.allocated
+}
+
+// Steps これまでに消費したステップ数
+func (b *Budget) Steps() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.steps
+}
+
+func (b *Budget) check() error {
+	if b.ctx != nil {
+		if err := b.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// おおよそのオブジェクトサイズ(バイト). 64bit環境のヘッダ・ポインタサイズを元にした概算
+const (
+	objectHeaderSize = 16
+	sliceHeaderSize  = 24
+	pointerSize      = 16 // interface値 (型情報 + データへのポインタ)
+	hashPairSize     = 2*pointerSize + 16
+)
+
+// SizeOf 配列・文字列・ハッシュ・クロージャ・構造体が確保したおおよそのバイト数を返す
+// 要素が参照する先のオブジェクトは含めない(それぞれの生成時に計上される)
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return objectHeaderSize + int64(len(obj.Value))
+	case *Array:
+		return objectHeaderSize + sliceHeaderSize + int64(len(obj.Elements))*pointerSize
+	case *Hash:
+		return objectHeaderSize + int64(obj.Len())*hashPairSize
+	case *Closure:
+		return objectHeaderSize + sliceHeaderSize + int64(len(obj.Free))*pointerSize
+	case *Struct:
+		return objectHeaderSize + pointerSize + sliceHeaderSize + int64(len(obj.Values))*pointerSize
+	case *Function:
+		return objectHeaderSize + 3*pointerSize
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,77 @@
+package object
+
+import "fmt"
+
+// methodNames 組み込み型のメソッドとして呼び出せるbuiltin関数. レシーバを第1引数として渡す
+//
+//	"abc".upper() は upper("abc"), arr.map(f) は map(arr, f) と同じ
+var methodNames = map[ObjectType][]string{
+	STRING_OBJ: {"len", "split", "trim", "upper", "lower", "replace", "contains", "starts_with", "ends_with",
+		"index_of", "substr", "repeat", "pad_left", "pad_right", "chars", "format"},
+	ARRAY_OBJ: {"len", "first", "last", "rest", "push", "map", "filter", "reduce", "each", "find", "any", "all",
+		"sort", "sort_by", "zip", "flatten", "join"},
+	HASH_OBJ: {"keys", "values", "entries", "has", "get", "delete", "merge"},
+}
+
+// methods 型ごとのメソッド表. key: 型 / value: メソッド名からbuiltin関数
+var methods = map[ObjectType]map[string]*Builtin{}
+
+// initMethods Builtinsからmethodsを作る. Builtinsを全て登録した後に呼び出す
+func initMethods() {
+	builtins := map[string]*Builtin{}
+	for _, def := range Builtins {
+		builtins[def.Name] = def.Builtin
+	}
+	for t, names := range methodNames {
+		methods[t] = map[string]*Builtin{}
+		for _, name := range names {
+			methods[t][name] = builtins[name]
+		}
+	}
+}
+
+// BoundMethod レシーバを束縛したメソッド. 呼び出すとReceiverを第1引数にしてMethodを呼び出す
+type BoundMethod struct {
+	Receiver Object
+	Method   Object
+	Name     string
+}
+
+// Type meets the object.Object interface
+func (bm *BoundMethod) Type() ObjectType { return BOUND_METHOD_OBJ }
+
+// Inspect meets the object.Object interface
+func (bm *BoundMethod) Inspect() string {
+	return fmt.Sprintf("BoundMethod[%s.%s]", typeName(bm.Receiver), bm.Name)
+}
+
+// FindMethod receiver.name(...) で呼び出す関数を探す
+// 構造体はimplのメソッド・フィールド, ハッシュはキーの値・組み込みメソッド, それ以外の型は組み込みメソッドの順に探す
+// メソッドはレシーバを束縛したBOUND_METHODで, フィールド・キーの値はそのまま返す
+func FindMethod(receiver Object, name string) (Object, error) {
+	switch r := receiver.(type) {
+	case *Struct:
+		if method, ok := r.Def.Methods[name]; ok {
+			return &BoundMethod{Receiver: r, Method: method, Name: name}, nil
+		}
+		if value, err := r.Field(name); err == nil {
+			return value, nil
+		}
+	case *Hash:
+		if value, ok := r.Get(&String{Value: name}); ok {
+			return value, nil
+		}
+	}
+	if method, ok := methods[receiver.Type()][name]; ok {
+		return &BoundMethod{Receiver: receiver, Method: method, Name: name}, nil
+	}
+	return nil, fmt.Errorf("%s has no method %s", typeName(receiver), name)
+}
+
+// typeName type()が返す型名. 構造体はstruct宣言の名前
+func typeName(obj Object) string {
+	if s, ok := obj.(*Struct); ok {
+		return s.Def.Name
+	}
+	return string(obj.Type())
+}
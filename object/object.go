@@ -2,6 +2,7 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
 	"monkey/ast"
@@ -25,8 +26,38 @@ const (
 	HASH_OBJ              = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE"
+	JUMP_TABLE_OBJ        = "JUMP_TABLE"
+	STRUCT_TYPE_OBJ       = "STRUCT_TYPE"
+	STRUCT_OBJ            = "STRUCT"
+	BOUND_METHOD_OBJ      = "BOUND_METHOD"
 )
 
+// VMとevaluatorで共有するシングルトン
+// 真偽値とnullは参照で比較されるため, Goから値を渡す場合もこれらを使う
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
+// IsTruthy 条件式での真偽を返す. falseとnull以外は全て真
+func IsTruthy(obj Object) bool {
+	switch obj {
+	case nil, NULL, FALSE:
+		return false
+	default:
+		return true
+	}
+}
+
+// NativeBoolToBoolean Goのboolを対応するシングルトンへ変換する
+func NativeBoolToBoolean(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
+
 // Object 全ての値は異なる型で定義される
 type Object interface {
 	Type() ObjectType
@@ -76,11 +107,15 @@ func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
 
 // Inspect fulfill the object.Object interface
 func (ao *Array) Inspect() string {
+	return ao.inspect(nil)
+}
+
+func (ao *Array) inspect(visiting map[*Struct]bool) string {
 	var out bytes.Buffer
 
 	var elements []string
 	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+		elements = append(elements, inspect(e, visiting))
 	}
 
 	out.WriteString("[")
@@ -111,9 +146,34 @@ func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 // Inspect fulfill the object.Object interface
 func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
 
+// Error.Kindの値. スクリプトからはe.kindの文字列で比較する
+const (
+	KindError         = "Error"         // error()で種類を指定しなかった場合
+	KindTypeError     = "TypeError"     // 引数の型が正しくない
+	KindArgumentError = "ArgumentError" // 引数の数が正しくない
+	KindValueError    = "ValueError"    // 引数の値が範囲外・不正
+	KindIOError       = "IOError"       // ファイル操作の失敗
+	KindRuntimeError  = "RuntimeError"  // VM・evaluatorの実行時エラー
+)
+
 // Error Error Objectをラップする
+// スクリプトからはe.message, e.kind, e.cause, e.stack, e.dataとして参照する
 type Error struct {
 	Message string
+	// Kind エラーの種類. 空の場合はKindError
+	Kind string
+	// Cause このエラーの原因としてラップしたエラー
+	Cause *Error
+	// Data error()に渡した追加の情報
+	Data *Hash
+	// Stack 生成または最初に投げられた時点で実行中の関数. 内側から順に並ぶ
+	Stack []string
+	// Failure builtin関数の失敗. FailureのERRORをbuiltin関数が返した場合は値ではなく例外として投げ,
+	// 投げる時にfalseに戻す. そのためcatchしたERRORをbuiltin関数が返しても値として扱う
+	Failure bool
+	// Fatal 実行制限の超過などスクリプト側で回復できないエラー. nilでなければ実行を中断する
+	// builtin関数から呼び出した関数の例外(*Exception)もここに入れて呼び出し元へ伝える
+	Fatal error
 }
 
 // Type fulfill the object.Object interface
@@ -122,11 +182,75 @@ func (e *Error) Type() ObjectType { return ERROR_OBJ }
 // Inspect fulfill the object.Object interface
 func (e *Error) Inspect() string { return "ERROR: " + e.Message }
 
+// KindName e.kindの値
+func (e *Error) KindName() string {
+	if e.Kind == "" {
+		return KindError
+	}
+	return e.Kind
+}
+
+// Field e.name (e["name"]) の値. message, kind, cause, stack, data以外はDataから探し, 無ければnull
+func (e *Error) Field(name string) Object {
+	switch name {
+	case "message":
+		return &String{Value: e.Message}
+	case "kind":
+		return &String{Value: e.KindName()}
+	case "cause":
+		if e.Cause == nil {
+			return NULL
+		}
+		return e.Cause
+	case "stack":
+		return stringsToArray(e.Stack)
+	case "data":
+		if e.Data == nil {
+			return NULL
+		}
+		return e.Data
+	}
+	if e.Data != nil {
+		if value, ok := e.Data.Get(&String{Value: name}); ok {
+			return value
+		}
+	}
+	return NULL
+}
+
+// HasKind eまたはCauseを辿ったエラーのいずれかの種類がkindであればtrue
+func (e *Error) HasKind(kind string) bool {
+	for err := e; err != nil; err = err.Cause {
+		if err.KindName() == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Exception throwされた値をGoのerrorとして運ぶ. catchされなければ実行結果のエラーになる
+type Exception struct {
+	Value Object
+}
+
+func (e *Exception) Error() string {
+	if errObj, ok := e.Value.(*Error); ok {
+		return errObj.Message
+	}
+	return "uncaught exception: " + e.Value.Inspect()
+}
+
 // Function function
 type Function struct {
 	Parameters []*ast.Identifier
-	Body       *ast.BlockStatement
-	Env        *Environment
+	// Patterns, Defaults, Rest 分割代入する引数のパターン・引数の既定値・残りの引数. ast.FunctionLiteralと同じ
+	Patterns []ast.Pattern
+	Defaults []ast.Expression
+	Rest     *ast.Identifier
+	Body     *ast.BlockStatement
+	Env      *Environment
+	// Name 関数宣言 fn name() {} の名前. 無名関数では空
+	Name string
 }
 
 // Type fulfill the object.Object interface
@@ -139,11 +263,24 @@ func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
 	var params []string
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
+	for i, p := range f.Parameters {
+		param := p.String()
+		if i < len(f.Patterns) && f.Patterns[i] != nil {
+			param = f.Patterns[i].String()
+		}
+		if i < len(f.Defaults) && f.Defaults[i] != nil {
+			param += " = " + f.Defaults[i].String()
+		}
+		params = append(params, param)
+	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
 	}
 
 	out.WriteString("fn")
+	if f.Name != "" {
+		out.WriteString(" " + f.Name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ","))
 	out.WriteString(") {\n")
@@ -155,9 +292,68 @@ func (f *Function) Inspect() string {
 
 type BuiltinFunction func(args ...Object) Object
 
-// Builtin ビルトイン関数
+// Caller builtin関数からMonkeyの関数(クロージャ・builtin関数)を呼び出すためのインターフェース
+// VMとevaluatorがそれぞれ実装する. 呼び出した関数が例外を投げた場合はFatalを設定したErrorを返す
+type Caller interface {
+	Call(fn Object, args ...Object) Object
+}
+
+// StackTracer 実行中の関数の履歴を返すCaller. error()がERRORのStackに記録する
+type StackTracer interface {
+	// StackTrace 内側から順に実行中の関数の名前を返す. 最後はメインプログラム
+	StackTrace() []string
+}
+
+// StackFrameName スタックトレースに表示する関数の名前. 無名関数は"<fn>"
+func StackFrameName(name string) string {
+	if name == "" {
+		return "<fn>"
+	}
+	return name
+}
+
+// Arity "wrong number of arguments" のエラーに表示する引数の数
+// requiredは省略できない引数の数, maxは既定値を持つ引数を含めた数, restは残りの引数を受け取るかどうか
+func Arity(required, max int, rest bool) string {
+	switch {
+	case rest:
+		return fmt.Sprintf("at least %d", required)
+	case required == max:
+		return fmt.Sprintf("%d", required)
+	default:
+		return fmt.Sprintf("%d..%d", required, max)
+	}
+}
+
+// MainFrameName スタックトレースの最後に表示するメインプログラムの名前
+const MainFrameName = "<main>"
+
+// ModuleFrameName スタックトレースに表示するモジュールのトップレベルの名前
+func ModuleFrameName(path string) string {
+	return "<module " + path + ">"
+}
+
+// CallerFunction 引数で受け取ったMonkeyの関数を呼び出すbuiltin関数
+type CallerFunction func(c Caller, args ...Object) Object
+
+// Variadic 可変長引数を取るbuiltin関数のArity
+const Variadic = -1
+
+// Builtin ビルトイン関数. FnかCallerFnのどちらかを設定する
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn       BuiltinFunction
+	CallerFn CallerFunction
+	Arity    int // 引数の数. Variadicの場合は可変長
+	// Borrows 戻り値が引数の要素やMonkeyの関数の戻り値など既存のオブジェクトで, 実行予算に計上しない
+	Borrows bool
+}
+
+// Invoke builtin関数を呼び出す. CallerFnにはcを渡す
+func (b *Builtin) Invoke(c Caller, args ...Object) Object {
+	if b.CallerFn != nil {
+		return b.CallerFn(c, args...)
+	}
+	return b.Fn(args...)
 }
 
 // Type fulfill the object.Object interface
@@ -167,22 +363,65 @@ func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string { return "builtin function" }
 
 // NewEnclosedEnvironment 外側の環境を参照する新しい環境を生成する
+// 実行予算は外側の環境と共有する
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	return &Environment{store: make(map[string]Object), outer: outer}
 }
 
 // NewEnvironment create new environment object
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{store: s, outer: nil, shared: &sharedState{}}
+}
+
+// NewModuleEnvironment pathのモジュールを評価する環境を生成する
+// envとは別の名前空間を持ち, envの最も外側の環境のbuiltin関数の束縛だけを引き継ぐ
+// 実行予算とimportの状態はenvと共有する
+func NewModuleEnvironment(env *Environment, path string) *Environment {
+	root := env.root()
+	modEnv := &Environment{store: make(map[string]Object), shared: root.shared, module: path}
+	for name, val := range root.store {
+		if _, ok := val.(*Builtin); ok {
+			modEnv.store[name] = val
+		}
+	}
+	return modEnv
 }
 
 // Environment 環境: 束縛されている変数の一覧を持つ
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	// 以下は最も外側の環境だけが持つ
+	shared  *sharedState
+	module  string   // モジュールのファイル. メインプログラムでは空
+	exports []string // exportされた変数名
+}
+
+// sharedState メインプログラムと全てのモジュールの環境で共有する状態
+type sharedState struct {
+	budget  *Budget
+	imports Imports
+	frames  []string // evaluatorで実行中の関数. 外側から順に並ぶ
+	warn    func(message string)
+}
+
+// ModuleLoader importのパスを解決し, モジュールを構文解析する. module.Loaderが実装する
+type ModuleLoader interface {
+	// Resolve fromのファイル(メインプログラムでは空)に書かれたimportのpathを, モジュールを一意に表すパスへ解決する
+	Resolve(from, path string) (string, error)
+	// Load Resolveで解決したパスのモジュールを構文解析する
+	Load(path string) (*ast.Program, error)
+}
+
+// Imports evaluatorのimportの状態
+type Imports struct {
+	Loader ModuleLoader
+	// Modules 評価済みのモジュールのexport. key: モジュールのファイルの絶対パス
+	Modules map[string]*Hash
+	// Loading 評価中のモジュール(循環importの検出用)
+	Loading []string
 }
 
 // Get get a value from the environment
@@ -200,6 +439,106 @@ func (e *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+// CopyTo この環境で定義した変数(外側の環境の変数は含まない)をdstにも設定する. exceptの変数は除く
+func (e *Environment) CopyTo(dst *Environment, except string) {
+	for name, val := range e.store {
+		if name != except {
+			dst.Set(name, val)
+		}
+	}
+}
+
+// SetBudget 実行予算を設定する. 予算は最も外側の環境に保持され, 内側の環境・モジュールの環境すべてで共有される
+func (e *Environment) SetBudget(b *Budget) {
+	e.root().shared.budget = b
+}
+
+// Budget 実行予算を返す. 設定されていなければnil(無制限)
+func (e *Environment) Budget() *Budget {
+	return e.root().shared.budget
+}
+
+// SetWarningHandler evaluatorが警告(網羅的でないmatch式など)を報告する関数を設定する. nilの場合は警告を捨てる
+func (e *Environment) SetWarningHandler(warn func(message string)) {
+	e.root().shared.warn = warn
+}
+
+// Warn SetWarningHandlerで設定した関数へ警告を報告する
+func (e *Environment) Warn(message string) {
+	if warn := e.root().shared.warn; warn != nil {
+		warn(message)
+	}
+}
+
+// Imports importの状態を返す. 内側の環境・モジュールの環境すべてで共有される
+func (e *Environment) Imports() *Imports {
+	return &e.root().shared.imports
+}
+
+// ModulePath 環境が属するモジュールのファイルを返す. メインプログラムでは空
+func (e *Environment) ModulePath() string {
+	return e.root().module
+}
+
+// Export nameをモジュールの外から参照できる変数として記録する
+// 最も外側の環境(トップレベル)でなければ記録せずfalseを返す
+func (e *Environment) Export(name string) bool {
+	if e.outer != nil {
+		return false
+	}
+	for _, exported := range e.exports {
+		if exported == name {
+			return true
+		}
+	}
+	e.exports = append(e.exports, name)
+	return true
+}
+
+// Exports exportされた変数をexportした順に並べたハッシュを返す
+func (e *Environment) Exports() *Hash {
+	root := e.root()
+	hash := NewHash(len(root.exports))
+	for _, name := range root.exports {
+		hash.Set(&String{Value: name}, root.store[name])
+	}
+	return hash
+}
+
+// PushFrame evaluatorが関数の呼び出しを記録する. 呼び出しから戻る時にPopFrameで取り除く
+func (e *Environment) PushFrame(name string) {
+	shared := e.root().shared
+	shared.frames = append(shared.frames, name)
+}
+
+// PopFrame 最後にPushFrameで記録した呼び出しを取り除く
+func (e *Environment) PopFrame() {
+	shared := e.root().shared
+	shared.frames = shared.frames[:len(shared.frames)-1]
+}
+
+// CallDepth evaluatorで実行中の関数呼び出しの深さ
+func (e *Environment) CallDepth() int {
+	return len(e.root().shared.frames)
+}
+
+// StackTrace 内側から順に実行中の関数の名前を返す. 最後はメインプログラム
+func (e *Environment) StackTrace() []string {
+	frames := e.root().shared.frames
+	stack := make([]string, 0, len(frames)+1)
+	for i := len(frames) - 1; i >= 0; i-- {
+		stack = append(stack, frames[i])
+	}
+	return append(stack, MainFrameName)
+}
+
+func (e *Environment) root() *Environment {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e
+}
+
 type HashKey struct {
 	Type  ObjectType
 	Value int64
@@ -207,6 +546,7 @@ type HashKey struct {
 
 // Hashable Hash化可能なオブジェクト
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
@@ -233,6 +573,43 @@ func (s *String) HashKey() HashKey {
 	return HashKey{Type: s.Type(), Value: int64(h.Sum64())}
 }
 
+// HashKey null
+func (n *Null) HashKey() HashKey {
+	return HashKey{Type: n.Type()}
+}
+
+// HashKey 要素のHashKeyを順に連結したハッシュ値
+// 要素が全てハッシュ化可能な場合だけキーとして使える (AsHashableを参照)
+// 配列を変更する手段はないため, キーにした後で値が変わることはない
+func (ao *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, el := range ao.Elements {
+		key := el.(Hashable).HashKey()
+		h.Write([]byte(key.Type))
+		binary.LittleEndian.PutUint64(buf[:], uint64(key.Value))
+		h.Write(buf[:])
+	}
+
+	return HashKey{Type: ao.Type(), Value: int64(h.Sum64())}
+}
+
+// AsHashable objがハッシュのキーとして使えればHashableとして返す
+// 配列は全ての要素がキーとして使える場合だけ使える
+func AsHashable(obj Object) (Hashable, bool) {
+	if arr, ok := obj.(*Array); ok {
+		for _, el := range arr.Elements {
+			if _, ok := AsHashable(el); !ok {
+				return nil, false
+			}
+		}
+		return arr, true
+	}
+
+	key, ok := obj.(Hashable)
+	return key, ok
+}
+
 // HashPair HashKey生成元のKeyとValue
 type HashPair struct {
 	Key   Object
@@ -242,8 +619,85 @@ type HashPair struct {
 // Hash Monkey hash(map)　object
 // Memo: map[HashKey]Objectにしない理由: Inspectでkey/valueを表示するため
 // HashKeyには実際のkeyは格納されていない. (P242)
+// ペアは挿入順に保持する. buckets はHashKeyごとのペアの位置で,
+// HashKeyが衝突した場合もキーをEqualsで比較して区別する
+// ゼロ値は空のハッシュとして使える
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	buckets map[HashKey][]int
+	entries []HashPair
+}
+
+// NewHash size個のペアを格納できる空のハッシュを生成する
+func NewHash(size int) *Hash {
+	return &Hash{
+		buckets: make(map[HashKey][]int, size),
+		entries: make([]HashPair, 0, size),
+	}
+}
+
+// Set keyにvalueを設定する. 既存のキーは元の位置のまま値を置き換える
+func (h *Hash) Set(key Hashable, value Object) {
+	if i, ok := h.find(key); ok {
+		h.entries[i] = HashPair{Key: key, Value: value}
+		return
+	}
+
+	if h.buckets == nil {
+		h.buckets = make(map[HashKey][]int)
+	}
+	hashKey := key.HashKey()
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.entries))
+	h.entries = append(h.entries, HashPair{Key: key, Value: value})
+}
+
+// Get keyの値を返す
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	i, ok := h.find(key)
+	if !ok {
+		return nil, false
+	}
+	return h.entries[i].Value, true
+}
+
+// Delete keyのペアを取り除く. 残りのペアの順序は保たれる
+func (h *Hash) Delete(key Hashable) bool {
+	i, ok := h.find(key)
+	if !ok {
+		return false
+	}
+
+	h.entries = append(h.entries[:i], h.entries[i+1:]...)
+	// 後ろのペアの位置がずれるので作り直す
+	h.buckets = make(map[HashKey][]int, len(h.entries))
+	for j, pair := range h.entries {
+		hashKey := pair.Key.(Hashable).HashKey()
+		h.buckets[hashKey] = append(h.buckets[hashKey], j)
+	}
+	return true
+}
+
+func (h *Hash) find(key Hashable) (int, bool) {
+	for _, i := range h.buckets[key.HashKey()] {
+		if Equals(h.entries[i].Key, key) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Len ペアの数
+func (h *Hash) Len() int { return len(h.entries) }
+
+// Pairs 挿入順のペアを返す. 返したスライスを変更してはならない
+func (h *Hash) Pairs() []HashPair { return h.entries }
+
+// Copy 同じペアを持つ新しいハッシュを返す
+func (h *Hash) Copy() *Hash {
+	hash := NewHash(h.Len())
+	for _, pair := range h.entries {
+		hash.Set(pair.Key.(Hashable), pair.Value)
+	}
+	return hash
 }
 
 // Type meets the object.Object interface
@@ -251,10 +705,14 @@ func (h *Hash) Type() ObjectType { return HASH_OBJ }
 
 // Inspect meets the object.Object interface
 func (h *Hash) Inspect() string {
+	return h.inspect(nil)
+}
+
+func (h *Hash) inspect(visiting map[*Struct]bool) string {
 	var out bytes.Buffer
 	var pairs []string
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	for _, pair := range h.entries {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), inspect(pair.Value, visiting)))
 	}
 
 	out.WriteString("{")
@@ -264,11 +722,133 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// StructType struct宣言で定義した構造体の型. 呼び出すとフィールドを宣言順に引数で初期化したSTRUCTを生成する
+type StructType struct {
+	Name   string
+	Fields []string
+	// Methods implで定義したメソッド. key: メソッド名 / value: 第1引数でレシーバを受け取る関数
+	Methods map[string]Object
+}
+
+// Type meets the object.Object interface
+func (st *StructType) Type() ObjectType { return STRUCT_TYPE_OBJ }
+
+// Inspect meets the object.Object interface
+func (st *StructType) Inspect() string {
+	return "struct " + st.Name + " { " + strings.Join(st.Fields, ", ") + " }"
+}
+
+// New argsでフィールドを初期化したSTRUCTを生成する. argsはコピーする
+func (st *StructType) New(args []Object) (*Struct, error) {
+	if len(args) != len(st.Fields) {
+		return nil, fmt.Errorf("wrong number of arguments: want=%d, got=%d", len(st.Fields), len(args))
+	}
+	values := make([]Object, len(args))
+	copy(values, args)
+	return &Struct{Def: st, Values: values}, nil
+}
+
+// Impl メソッドを追加する. 同じ名前のメソッドは置き換える
+func (st *StructType) Impl(name string, method Object) {
+	if st.Methods == nil {
+		st.Methods = map[string]Object{}
+	}
+	st.Methods[name] = method
+}
+
+func (st *StructType) fieldIndex(name string) int {
+	for i, field := range st.Fields {
+		if field == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Struct 構造体の値. フィールドは p.x = 1 で書き換えられる
+// Values: Def.Fieldsと同じ順に並んだフィールドの値
+type Struct struct {
+	Def    *StructType
+	Values []Object
+}
+
+// Type meets the object.Object interface. 構造体の名前はtype()で取得する
+func (s *Struct) Type() ObjectType { return STRUCT_OBJ }
+
+// Inspect meets the object.Object interface
+// 自身を(間接的に)参照するフィールドは<cycle>と表示する
+func (s *Struct) Inspect() string {
+	return s.inspect(nil)
+}
+
+func (s *Struct) inspect(visiting map[*Struct]bool) string {
+	if visiting[s] {
+		return "<cycle>"
+	}
+	if visiting == nil {
+		visiting = map[*Struct]bool{}
+	}
+	visiting[s] = true
+	defer delete(visiting, s)
+
+	var fields []string
+	for i, name := range s.Def.Fields {
+		fields = append(fields, name+": "+inspect(s.Values[i], visiting))
+	}
+	return s.Def.Name + "{" + strings.Join(fields, ", ") + "}"
+}
+
+// inspect 表示中の構造体visitingを引き継いでobjを表示する. 配列・ハッシュを経由した循環も検出する
+func inspect(obj Object, visiting map[*Struct]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.inspect(visiting)
+	case *Hash:
+		return obj.inspect(visiting)
+	case *Struct:
+		return obj.inspect(visiting)
+	default:
+		return obj.Inspect()
+	}
+}
+
+// Field s.name の値. 宣言していないフィールドはエラー
+func (s *Struct) Field(name string) (Object, error) {
+	i := s.Def.fieldIndex(name)
+	if i < 0 {
+		return nil, fmt.Errorf("%s has no field %s", s.Def.Name, name)
+	}
+	return s.Values[i], nil
+}
+
+// SetField s.name = value. 宣言していないフィールドはエラー
+func (s *Struct) SetField(name string, value Object) error {
+	i := s.Def.fieldIndex(name)
+	if i < 0 {
+		return fmt.Errorf("%s has no field %s", s.Def.Name, name)
+	}
+	s.Values[i] = value
+	return nil
+}
+
 // CompiledFunction コンパイラ用
 type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	// NumDefaults 既定値を持つ引数の数. NumParametersのうち最後のNumDefaults個は省略できる
+	NumDefaults int
+	// DefaultEntries 省略された引数の既定値を計算するコードの開始位置. 既定値を持つ引数の順に並ぶ
+	// 省略された最初の引数の位置から実行すると, 残りの既定値を計算してから関数本体へ進む
+	DefaultEntries []int
+	// Rest NumParametersより後ろの引数を配列にまとめ, NumParameters番目のローカル変数に入れる
+	Rest bool
+	// Name 関数宣言 fn name() {} の名前. スタックトレースとInspectに使う. 無名関数では空
+	Name string
+	// Handlers try式の例外ハンドラ表. OpTryのオペランドで参照する
+	Handlers []code.Handler
+	// FreeNames 関数宣言の自由変数の名前. 代入される前の自由変数を参照した時のエラーに使う
+	FreeNames []string
 }
 
 // Type meets the object.Object interface
@@ -276,9 +856,61 @@ func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
 
 // Inspect meets the object.Object interface
 func (cf *CompiledFunction) Inspect() string {
+	if cf.Name != "" {
+		return fmt.Sprintf("CompiledFunction[%s]", cf.Name)
+	}
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
 
+// JumpTable match式のジャンプ表. コンパイラが定数に置き, OpJumpTableが参照する
+// Targets: リテラルのパターンの値とアームの位置(登録順), Default: どれにも該当しない場合の位置
+type JumpTable struct {
+	Targets []JumpTarget
+	Default int
+
+	buckets map[HashKey][]int // HashKeyからTargetsの位置. 衝突した値は同じバケットに並ぶ
+}
+
+// JumpTarget Keyと等しい値の場合にジャンプするアームの位置
+type JumpTarget struct {
+	Key Hashable
+	Pos int
+}
+
+// NewJumpTable 空のジャンプ表を生成する
+func NewJumpTable(defaultPos int) *JumpTable {
+	return &JumpTable{Default: defaultPos, buckets: map[HashKey][]int{}}
+}
+
+// Add keyの値のアームの位置を登録する. 等しい値が登録済みの場合は何もせずfalseを返す
+func (jt *JumpTable) Add(key Hashable, pos int) bool {
+	if _, ok := jt.Lookup(key); ok {
+		return false
+	}
+	hashKey := key.HashKey()
+	jt.buckets[hashKey] = append(jt.buckets[hashKey], len(jt.Targets))
+	jt.Targets = append(jt.Targets, JumpTarget{Key: key, Pos: pos})
+	return true
+}
+
+// Lookup keyと等しい値のアームの位置を返す. HashKeyが衝突した値はEqualsで区別する
+func (jt *JumpTable) Lookup(key Hashable) (int, bool) {
+	for _, i := range jt.buckets[key.HashKey()] {
+		if Equals(jt.Targets[i].Key, key) {
+			return jt.Targets[i].Pos, true
+		}
+	}
+	return 0, false
+}
+
+// Type meets the object.Object interface
+func (jt *JumpTable) Type() ObjectType { return JUMP_TABLE_OBJ }
+
+// Inspect meets the object.Object interface
+func (jt *JumpTable) Inspect() string {
+	return fmt.Sprintf("JumpTable[%p]", jt)
+}
+
 // Closure CompiledFunctionへの参照と自由変数をプロパティに持つ
 // 全ての関数をClosureとして扱う
 type Closure struct {
@@ -291,5 +923,8 @@ func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
 
 // Inspect meets the object.Object interface
 func (c *Closure) Inspect() string {
+	if c.Fn.Name != "" {
+		return fmt.Sprintf("Closure[%s]", c.Fn.Name)
+	}
 	return fmt.Sprintf("Closure[%p]", c)
 }
@@ -0,0 +1,185 @@
+package object
+
+import "testing"
+
+// collidingKey 全て同じHashKeyを返す文字列キー
+type collidingKey struct {
+	*String
+}
+
+func (k collidingKey) HashKey() HashKey {
+	return HashKey{Type: STRING_OBJ, Value: 42}
+}
+
+func (k collidingKey) Equals(other Object) bool {
+	o, ok := other.(collidingKey)
+	return ok && k.Value == o.Value
+}
+
+func TestHashCollisions(t *testing.T) {
+	a := collidingKey{&String{Value: "a"}}
+	b := collidingKey{&String{Value: "b"}}
+
+	hash := &Hash{}
+	hash.Set(a, &Integer{Value: 1})
+	hash.Set(b, &Integer{Value: 2})
+	hash.Set(collidingKey{&String{Value: "a"}}, &Integer{Value: 3})
+
+	if hash.Len() != 2 {
+		t.Fatalf("hash has wrong number of pairs. got=%d", hash.Len())
+	}
+	if hash.Inspect() != "{a: 3, b: 2}" {
+		t.Errorf("hash.Inspect() wrong. got=%s", hash.Inspect())
+	}
+
+	tests := []struct {
+		key      Hashable
+		expected int64
+	}{
+		{a, 3},
+		{b, 2},
+	}
+	for _, tt := range tests {
+		value, ok := hash.Get(tt.key)
+		if !ok {
+			t.Fatalf("no value for %s", tt.key.Inspect())
+		}
+		if value.(*Integer).Value != tt.expected {
+			t.Errorf("wrong value for %s. want=%d, got=%s", tt.key.Inspect(), tt.expected, value.Inspect())
+		}
+	}
+
+	if _, ok := hash.Get(collidingKey{&String{Value: "c"}}); ok {
+		t.Errorf("found a value for a missing key with the same HashKey")
+	}
+
+	if !hash.Delete(a) {
+		t.Fatalf("Delete returned false")
+	}
+	if value, ok := hash.Get(b); !ok || value.(*Integer).Value != 2 {
+		t.Errorf("wrong value after Delete. got=%v", value)
+	}
+	if _, ok := hash.Get(a); ok {
+		t.Errorf("deleted key is still found")
+	}
+}
+
+func TestEquals(t *testing.T) {
+	array := &Array{}
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Integer{Value: 2}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&String{Value: "1"}, &Integer{Value: 1}, false},
+		{&Boolean{Value: true}, TRUE, true},
+		{NULL, &Null{}, true},
+		{NULL, FALSE, false},
+		{array, array, true},
+	}
+
+	for _, tt := range tests {
+		if got := Equals(tt.a, tt.b); got != tt.expected {
+			t.Errorf("Equals(%s, %s) wrong. want=%t, got=%t", tt.a.Inspect(), tt.b.Inspect(), tt.expected, got)
+		}
+	}
+}
+
+func TestCyclicStruct(t *testing.T) {
+	def := &StructType{Name: "N", Fields: []string{"v"}}
+	a, _ := def.New([]Object{NULL})
+	b, _ := def.New([]Object{NULL})
+	a.Values[0] = a
+	b.Values[0] = &Array{Elements: []Object{b}}
+
+	if got := a.Inspect(); got != "N{v: <cycle>}" {
+		t.Errorf("wrong Inspect. got=%q", got)
+	}
+	if got := b.Inspect(); got != "N{v: [<cycle>]}" {
+		t.Errorf("wrong Inspect. got=%q", got)
+	}
+	// 循環していない共有は省略しない
+	shared, _ := def.New([]Object{&Integer{Value: 1}})
+	pair := &Array{Elements: []Object{shared, shared}}
+	if got := pair.Inspect(); got != "[N{v: 1}, N{v: 1}]" {
+		t.Errorf("wrong Inspect. got=%q", got)
+	}
+
+	if !Equals(a, a) {
+		t.Errorf("cyclic struct should equal itself")
+	}
+	if Equals(a, b) {
+		t.Errorf("%s should not equal %s", a.Inspect(), b.Inspect())
+	}
+	c, _ := def.New([]Object{NULL})
+	c.Values[0] = c
+	if !Equals(a, c) {
+		t.Errorf("structurally equal cyclic structs should be equal")
+	}
+}
+
+func TestJumpTable(t *testing.T) {
+	a := collidingKey{&String{Value: "a"}}
+	b := collidingKey{&String{Value: "b"}}
+	c := collidingKey{&String{Value: "c"}}
+	table := NewJumpTable(-1)
+	if !table.Add(a, 10) || !table.Add(b, 20) {
+		t.Fatalf("colliding keys should be added")
+	}
+	if table.Add(collidingKey{&String{Value: "a"}}, 30) {
+		t.Errorf("duplicate key should not be added")
+	}
+
+	tests := []struct {
+		key      Hashable
+		expected int
+		found    bool
+	}{
+		{a, 10, true},
+		{b, 20, true},
+		{c, 0, false},
+	}
+	for _, tt := range tests {
+		pos, ok := table.Lookup(tt.key)
+		if pos != tt.expected || ok != tt.found {
+			t.Errorf("Lookup(%s) wrong. want=(%d, %t), got=(%d, %t)", tt.key.Inspect(), tt.expected, tt.found, pos, ok)
+		}
+	}
+}
+
+func TestFindMethod(t *testing.T) {
+	def := &StructType{Name: "P", Fields: []string{"keys"}}
+	def.Impl("get", &Builtin{})
+	p, _ := def.New([]Object{&Integer{Value: 1}})
+
+	hash := NewHash(1)
+	hash.Set(&String{Value: "keys"}, &Integer{Value: 2})
+
+	tests := []struct {
+		receiver Object
+		name     string
+		expected string
+	}{
+		{p, "get", "BoundMethod[P.get]"},
+		{p, "keys", "1"},
+		{hash, "keys", "2"},
+		{hash, "values", "BoundMethod[HASH.values]"},
+		{&String{Value: "a"}, "upper", "BoundMethod[STRING.upper]"},
+		{p, "upper", "P has no method upper"},
+		{&Integer{Value: 1}, "upper", "INTEGER has no method upper"},
+	}
+	for _, tt := range tests {
+		method, err := FindMethod(tt.receiver, tt.name)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = method.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("FindMethod(%s, %q) wrong. want=%q, got=%q", tt.receiver.Inspect(), tt.name, tt.expected, got)
+		}
+	}
+}
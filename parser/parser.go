@@ -13,6 +13,7 @@ const (
 	_ int = iota
 	// LOWEST 最低優先度
 	LOWEST
+	ASSIGN      // p.x = 1
 	EQUALS      // == !=
 	LESSGREATER // < >
 	SUM         // +
@@ -23,6 +24,7 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:   ASSIGN,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
@@ -35,6 +37,7 @@ var precedences = map[token.TokenType]int{
 	token.LPAREN: CALL,
 	// 配列インデックスは[をオペレータとするinfix
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
 }
 
 type (
@@ -68,12 +71,16 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.NULL, p.parseNull)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiterals)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -87,6 +94,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseFieldExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 
 	return p
 }
@@ -127,6 +136,28 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.STRUCT:
+		if stmt := p.parseStructStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
+	case token.IMPL:
+		if stmt := p.parseImplStatement(); stmt != nil {
+			return stmt
+		}
+		return nil
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) {
+			if stmt := p.parseFunctionStatement(); stmt != nil {
+				return stmt
+			}
+			return nil
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -147,13 +178,22 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	// peekToken: x
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
-		return nil
-	}
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		// let [a, b] = x; let {name} = x; の場合
+		p.nextToken()
+		stmt.Pattern = p.parseDestructuringPattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 
-	// curToken: x
-	// peekToken: =
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		// curToken: x
+		// peekToken: =
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -175,6 +215,162 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+// export let <identifier> = <expression> か export fn <identifier>(...) {...} の形式であればExportStatementを返す
+func (p *Parser) parseExportStatement() ast.Statement {
+	stmt := &ast.ExportStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.FUNCTION) {
+		p.nextToken()
+		if !p.peekTokenIs(token.IDENT) {
+			p.peekError(token.IDENT)
+			return nil
+		}
+		fs := p.parseFunctionStatement()
+		if fs == nil {
+			return nil
+		}
+		stmt.Statement = fs
+		stmt.Name = fs.Name
+		return stmt
+	}
+	if p.peekTokenIs(token.STRUCT) {
+		p.nextToken()
+		ss := p.parseStructStatement()
+		if ss == nil {
+			return nil
+		}
+		stmt.Statement = ss
+		stmt.Name = ss.Name
+		return stmt
+	}
+
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+	let := p.parseLetStatement()
+	if let == nil {
+		return nil
+	}
+	if let.Pattern != nil {
+		p.errors = append(p.errors, fmt.Sprintf("export requires a single name, got %s", let.Pattern.String()))
+		return nil
+	}
+	stmt.Statement = let
+	stmt.Name = let.Name
+	return stmt
+}
+
+// struct <identifier> { <field>, <field>, ... } の形式であればStructStatementを返す
+func (p *Parser) parseStructStatement() *ast.StructStatement {
+	// struct Point { x, y } の場合
+	// curToken: struct
+	// peekToken: Point
+	stmt := &ast.StructStatement{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		field := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if seen[field.Value] {
+			p.errors = append(p.errors, fmt.Sprintf("duplicate field %s in struct %s", field.Value, stmt.Name.Value))
+			return nil
+		}
+		seen[field.Value] = true
+		stmt.Fields = append(stmt.Fields, field)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// impl <identifier> { fn <method>(self, ...) { ... } ... } の形式であればImplStatementを返す
+func (p *Parser) parseImplStatement() *ast.ImplStatement {
+	// impl Point { fn norm(self) { ... } } の場合
+	// curToken: impl
+	// peekToken: Point
+	stmt := &ast.ImplStatement{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+			continue
+		}
+		if !p.expectPeek(token.FUNCTION) || !p.peekTokenIs(token.IDENT) {
+			if p.curTokenIs(token.FUNCTION) {
+				p.peekError(token.IDENT)
+			}
+			return nil
+		}
+		method := p.parseFunctionStatement()
+		if method == nil {
+			return nil
+		}
+		if len(method.Function.Parameters) == 0 && method.Function.Rest == nil {
+			p.errors = append(p.errors, fmt.Sprintf("method %s.%s must take the receiver as its first parameter", stmt.Name.Value, method.Name.Value))
+			return nil
+		}
+		method.Function.Name = stmt.Name.Value + "." + method.Name.Value
+		stmt.Methods = append(stmt.Methods, method)
+	}
+	p.nextToken()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// fn <identifier>(<parameters>) { <body> } の形式であればFunctionStatementを返す
+func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
+	// fn add(x, y) { x + y } の場合
+	// curToken: fn
+	// peekToken: add
+	stmt := &ast.FunctionStatement{Token: p.curToken}
+	p.nextToken()
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	lit := &ast.FunctionLiteral{Token: stmt.Token, Name: stmt.Name.Value}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.parseFunctionParameters(lit) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	lit.Body = p.parseBlockStatement()
+	stmt.Function = lit
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	// return 5; の場合
 	// curToken: return
@@ -195,6 +391,23 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() ast.Statement {
+	// throw x; の場合
+	// curToken: throw
+	// peekToken: x
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
@@ -260,6 +473,21 @@ func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
 
+func (p *Parser) parseNull() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
+// import "path" パスは文字列リテラルのみ(コンパイル時に解決するため)
+func (p *Parser) parseImportExpression() ast.Expression {
+	exp := &ast.ImportExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	exp.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -336,6 +564,250 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	// try { x } catch (e) { y } finally { z } の場合
+	// curToken: try
+	// peekToken: {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	// curToken: }
+	// peekToken: catch
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.CatchParam = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	// curToken: }
+	// peekToken: finally
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.errors = append(p.errors, "try requires catch or finally")
+		return nil
+	}
+	return expression
+}
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	// match x { 0 => a, _ if y => { b } } の場合
+	// curToken: match
+	// peekToken: x
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		expression.Arms = append(expression.Arms, arm)
+
+		// ブロックの後のカンマは省略できる
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if arm.Body.Token.Type != token.LBRACE && !p.peekTokenIs(token.RBRACE) {
+			p.peekError(token.COMMA)
+			return nil
+		}
+	}
+	p.nextToken()
+
+	if len(expression.Arms) == 0 {
+		p.errors = append(p.errors, "match requires at least one arm")
+		return nil
+	}
+	return expression
+}
+
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	// curToken: パターンの最初のトークン
+	arm := &ast.MatchArm{Pattern: p.parsePattern()}
+	if arm.Pattern == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	p.nextToken()
+	if p.curTokenIs(token.LBRACE) {
+		arm.Body = p.parseBlockStatement()
+		return arm
+	}
+	// 式は1文のブロックとして持つ. ハッシュを返す場合は ({...}) と書く
+	tok := p.curToken
+	stmt := &ast.ExpressionStatement{Token: tok, Expression: p.parseExpression(LOWEST)}
+	arm.Body = &ast.BlockStatement{Token: tok, Statements: []ast.Statement{stmt}}
+	return arm
+}
+
+// parsePattern curTokenから始まるmatch式のパターンを構文解析する
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		return &ast.BindingPattern{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+	case token.INT, token.STRING, token.TRUE, token.FALSE, token.NULL:
+		return &ast.LiteralPattern{Value: p.prefixParseFns[p.curToken.Type]()}
+	case token.MINUS:
+		if !p.peekTokenIs(token.INT) {
+			break
+		}
+		return &ast.LiteralPattern{Value: p.parsePrefixExpression()}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseHashPattern()
+	}
+	p.errors = append(p.errors, fmt.Sprintf("unexpected %s in pattern", p.curToken.Literal))
+	return nil
+}
+
+// parseDestructuringPattern 分割代入のパターンを構文解析する. どの値にもマッチしうるリテラルのパターンは書けない
+func (p *Parser) parseDestructuringPattern() ast.Pattern {
+	pattern := p.parsePattern()
+	if pattern == nil {
+		return nil
+	}
+	if literal := findLiteralPattern(pattern); literal != nil {
+		p.errors = append(p.errors, fmt.Sprintf("literal pattern %s is not allowed in destructuring", literal.String()))
+		return nil
+	}
+	return pattern
+}
+
+// findLiteralPattern pattern自身またはその要素のリテラルのパターンを返す. 無い場合はnil
+func findLiteralPattern(pattern ast.Pattern) ast.Pattern {
+	switch pattern := pattern.(type) {
+	case *ast.LiteralPattern:
+		return pattern
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			if literal := findLiteralPattern(el); literal != nil {
+				return literal
+			}
+		}
+	case *ast.HashPattern:
+		for _, value := range pattern.Values {
+			if literal := findLiteralPattern(value); literal != nil {
+				return literal
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			// ...restは最後の要素にだけ書ける
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = p.parsePattern()
+			if !p.expectPeek(token.RBRACKET) {
+				return nil
+			}
+			return pattern
+		}
+		element := p.parsePattern()
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+
+	return pattern
+}
+
+func (p *Parser) parseHashPattern() ast.Pattern {
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		switch {
+		case p.curTokenIs(token.IDENT) && !p.peekTokenIs(token.COLON):
+			// {name} は {"name": name} と同じ
+			key := &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+			name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			pattern.Keys = append(pattern.Keys, key)
+			pattern.Values = append(pattern.Values, &ast.BindingPattern{Name: name})
+			if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+			continue
+		case p.curTokenIs(token.INT), p.curTokenIs(token.STRING), p.curTokenIs(token.TRUE), p.curTokenIs(token.FALSE):
+			pattern.Keys = append(pattern.Keys, p.prefixParseFns[p.curToken.Type]())
+		default:
+			p.errors = append(p.errors, fmt.Sprintf("hash pattern key must be a literal, got %s", p.curToken.Literal))
+			return nil
+		}
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		value := p.parsePattern()
+		if value == nil {
+			return nil
+		}
+		pattern.Values = append(pattern.Values, value)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+
+	return pattern
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	// if (x > y) { x } else { y } の場合
 	// curToken: if
@@ -395,7 +867,9 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 	// curToken: "("
 	// peekToken: x
-	lit.Parameters = p.parseFunctionParameters()
+	if !p.parseFunctionParameters(lit) {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -406,38 +880,94 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	var identifiers []*ast.Identifier
-
+// parseFunctionParameters 引数を構文解析してlitに設定する
+// 分割代入する引数・既定値を持つ引数が無い場合, lit.Patterns・lit.Defaultsはnil
+func (p *Parser) parseFunctionParameters(lit *ast.FunctionLiteral) bool {
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return true
 	}
 
+	var hasPattern, hasDefault bool
 	p.nextToken()
+	for {
+		if p.curTokenIs(token.ELLIPSIS) {
+			// fn(a, ...rest) の場合. 残りの引数は最後にだけ書ける
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+		if p.curTokenIs(token.LBRACKET) || p.curTokenIs(token.LBRACE) {
+			// fn([a, b], {name}) の場合. 引数は参照できない名前で受け取り, 関数の先頭で分割代入する
+			tok := p.curToken
+			pattern := p.parseDestructuringPattern()
+			if pattern == nil {
+				return false
+			}
+			name := fmt.Sprintf("<param %d>", len(lit.Parameters))
+			lit.Parameters = append(lit.Parameters, &ast.Identifier{Token: tok, Value: name})
+			lit.Patterns = append(lit.Patterns, pattern)
+			hasPattern = true
+		} else {
+			lit.Parameters = append(lit.Parameters, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+			lit.Patterns = append(lit.Patterns, nil)
+		}
 
-	for p.peekTokenIs(token.COMMA) {
+		var defaultValue ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			// fn(a, b = 1) の場合
+			p.nextToken()
+			p.nextToken()
+			defaultValue = p.parseExpression(LOWEST)
+			hasDefault = true
+		} else if hasDefault {
+			p.errors = append(p.errors, fmt.Sprintf("parameter %s without default value follows parameters with default values", p.curToken.Literal))
+			return false
+		}
+		lit.Defaults = append(lit.Defaults, defaultValue)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
 	}
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+	if !hasPattern {
+		lit.Patterns = nil
+	}
+	if !hasDefault {
+		lit.Defaults = nil
 	}
-	return identifiers
+	return p.expectPeek(token.RPAREN)
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	if field, ok := function.(*ast.FieldExpression); ok {
+		// p.norm(1) はメソッド呼び出し
+		exp := &ast.MethodCallExpression{Token: p.curToken, Receiver: field.Left, Method: field.Field}
+		exp.Arguments = p.parseList(token.RPAREN, p.parseCallArgument)
+		return exp
+	}
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
-	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Arguments = p.parseList(token.RPAREN, p.parseCallArgument)
 	return exp
 }
 
+// parseCallArgument 関数呼び出しの引数. ...argsは配列を引数として展開する
+func (p *Parser) parseCallArgument() ast.Expression {
+	if p.curTokenIs(token.ELLIPSIS) {
+		exp := &ast.SpreadExpression{Token: p.curToken}
+		p.nextToken()
+		exp.Value = p.parseExpression(LOWEST)
+		return exp
+	}
+	return p.parseExpression(LOWEST)
+}
+
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
 
@@ -451,6 +981,39 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseFieldExpression(left ast.Expression) ast.Expression {
+	// e.message の場合
+	// curToken: .
+	// peekToken: message
+	exp := &ast.FieldExpression{Token: p.curToken, Left: left}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Field = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
+}
+
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	// p.x = 1 の場合
+	// curToken: =
+	// peekToken: 1
+	if left == nil {
+		return nil
+	}
+	target, ok := left.(*ast.FieldExpression)
+	if !ok {
+		p.errors = append(p.errors, fmt.Sprintf("cannot assign to %s", left.String()))
+		return nil
+	}
+	exp := &ast.AssignExpression{Token: p.curToken, Target: target}
+
+	p.nextToken()
+	// p.x = q.y = 1 は右結合
+	exp.Value = p.parseExpression(LOWEST)
+	return exp
+}
+
 func (p *Parser) parseArrayLiterals() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
@@ -473,6 +1036,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -487,6 +1051,11 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 }
 
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+	return p.parseList(end, func() ast.Expression { return p.parseExpression(LOWEST) })
+}
+
+// parseList endまでのカンマ区切りの要素をparseElementで構文解析する
+func (p *Parser) parseList(end token.TokenType, parseElement func() ast.Expression) []ast.Expression {
 	var list []ast.Expression
 	if p.peekTokenIs(end) {
 		p.nextToken()
@@ -494,12 +1063,12 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	}
 
 	p.nextToken()
-	list = append(list, p.parseExpression(LOWEST))
+	list = append(list, parseElement())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		list = append(list, p.parseExpression(LOWEST))
+		list = append(list, parseElement())
 	}
 
 	if !p.expectPeek(end) {
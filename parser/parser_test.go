@@ -281,6 +281,422 @@ func TestBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestNullLiteral(t *testing.T) {
+	input := "null;"
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not enough statements. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+
+	null, ok := stmt.Expression.(*ast.NullLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.NullLiteral. got=%T", stmt.Expression)
+	}
+	if null.TokenLiteral() != "null" {
+		t.Errorf("null.TokenLiteral not %s. got=%s", "null", null.TokenLiteral())
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	input := `let m = import "./math"; export let x = m["x"];`
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program has not enough statements. got=%d", len(program.Statements))
+	}
+	let, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T", program.Statements[0])
+	}
+	imp, ok := let.Value.(*ast.ImportExpression)
+	if !ok {
+		t.Fatalf("let.Value not *ast.ImportExpression. got=%T", let.Value)
+	}
+	if imp.Path.Value != "./math" {
+		t.Errorf("imp.Path.Value not %q. got=%q", "./math", imp.Path.Value)
+	}
+
+	export, ok := program.Statements[1].(*ast.ExportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not ast.ExportStatement. got=%T", program.Statements[1])
+	}
+	if !testLetStatement(t, export.Statement, "x") {
+		return
+	}
+	if program.String() != `let m = import "./math";export let x = (m[x]);` {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+
+	for _, input := range []string{`import math`, `export fn() {}`} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestTryThrow(t *testing.T) {
+	input := `try { throw x; } catch (e) { e } finally { 1 }`
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not enough statements. got=%d", len(program.Statements))
+	}
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	try, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression not *ast.TryExpression. got=%T", stmt.Expression)
+	}
+	throw, ok := try.Block.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("try.Block.Statements[0] not *ast.ThrowStatement. got=%T", try.Block.Statements[0])
+	}
+	testIdentifier(t, throw.Value, "x")
+	testIdentifier(t, try.CatchParam, "e")
+	if len(try.Catch.Statements) != 1 || len(try.Finally.Statements) != 1 {
+		t.Fatalf("wrong catch or finally block. got=%q", try.String())
+	}
+	if program.String() != "try throw x; catch (e) e finally 1" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+
+	for _, input := range []string{`try { 1 }`, `try { 1 } catch { 2 }`, `try { 1 } catch (1) { 2 }`, `throw;`} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	input := `match x { 0 => a, -1 => b, [y, _] if y > 1 => { y }, {"type": "a", "v": v} => v, _ => null }`
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not enough statements. got=%d", len(program.Statements))
+	}
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	match, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression not *ast.MatchExpression. got=%T", stmt.Expression)
+	}
+	testIdentifier(t, match.Subject, "x")
+	if len(match.Arms) != 5 {
+		t.Fatalf("match.Arms wrong length. got=%d", len(match.Arms))
+	}
+
+	patterns := []struct {
+		pattern  ast.Pattern
+		expected string
+	}{
+		{&ast.LiteralPattern{}, "0"},
+		{&ast.LiteralPattern{}, "(-1)"},
+		{&ast.ArrayPattern{}, "[y, _]"},
+		{&ast.HashPattern{}, "{type:a, v:v}"},
+		{&ast.WildcardPattern{}, "_"},
+	}
+	for i, tt := range patterns {
+		pattern := match.Arms[i].Pattern
+		if fmt.Sprintf("%T", pattern) != fmt.Sprintf("%T", tt.pattern) || pattern.String() != tt.expected {
+			t.Errorf("arms[%d] pattern wrong. expected=%T %q, got=%T %q", i, tt.pattern, tt.expected, pattern, pattern.String())
+		}
+	}
+	array := match.Arms[2].Pattern.(*ast.ArrayPattern)
+	if _, ok := array.Elements[0].(*ast.BindingPattern); !ok {
+		t.Errorf("array.Elements[0] not *ast.BindingPattern. got=%T", array.Elements[0])
+	}
+	if match.Arms[2].Guard == nil || match.Arms[2].Guard.String() != "(y > 1)" {
+		t.Errorf("arms[2] guard wrong. got=%v", match.Arms[2].Guard)
+	}
+
+	expected := "match x { 0 => a, (-1) => b, [y, _] if (y > 1) => y, {type:a, v:v} => v, _ => null }"
+	if program.String() != expected {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+
+	for _, input := range []string{
+		`match x { }`,
+		`match x { 1 => a 2 => b }`,
+		`match x { 1 a }`,
+		`match x { y + 1 => a }`,
+		`match x { {a: 1} => a }`,
+		`match x { [1, => a }`,
+	} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let [a, b] = x;`, "let [a, b] = x;"},
+		{`let [a, [b, _], ...rest] = x;`, "let [a, [b, _], ...rest] = x;"},
+		{`let {name, age} = person;`, "let {name:name, age:age} = person;"},
+		{`let {"point": [x, y]} = shape;`, "let {point:[x, y]} = shape;"},
+		{`fn([a, b], c, {d}) { a }`, "fn([a, b], c, {d:d}) a"},
+		{`match x { [y, ...ys] => ys }`, "match x { [y, ...ys] => ys }"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	// 分割代入する引数は参照できない名前で受け取る
+	p := New(lexer.New(`fn(a, [b]) { b }`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(function.Parameters) != 2 || function.Parameters[1].Value != "<param 1>" {
+		t.Fatalf("function.Parameters wrong. got=%v", function.Parameters)
+	}
+	if len(function.Patterns) != 2 || function.Patterns[0] != nil || function.Patterns[1].String() != "[b]" {
+		t.Fatalf("function.Patterns wrong. got=%v", function.Patterns)
+	}
+
+	for _, input := range []string{
+		`let [a, 1] = x;`,
+		`let {"a": "b"} = x;`,
+		`let [...a, b] = x;`,
+		`let [a, ...] = x;`,
+		`export let [a] = x;`,
+		`fn([1]) { 1 }`,
+	} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestFunctionParameterDefaults(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`fn(a, b = 2) { a }`, "fn(a, b = 2) a"},
+		{`fn(a = 1, b = a + 1) { b }`, "fn(a = 1, b = (a + 1)) b"},
+		{`fn(a, ...rest) { rest }`, "fn(a, ...rest) rest"},
+		{`fn([a, b] = [1, 2], ...rest) { a }`, "fn([a, b] = [1, 2], ...rest) a"},
+		{`f(1, ...xs, ...[2, 3])`, "f(1, ...xs, ...[2, 3])"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New(`fn(a, b = 2, ...c) { a }`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if len(function.Defaults) != 2 || function.Defaults[0] != nil || function.Defaults[1].String() != "2" {
+		t.Fatalf("function.Defaults wrong. got=%v", function.Defaults)
+	}
+	if function.Rest == nil || function.Rest.Value != "c" {
+		t.Fatalf("function.Rest wrong. got=%v", function.Rest)
+	}
+
+	for _, input := range []string{
+		`fn(a = 1, b) { a }`,
+		`fn(...a, b) { a }`,
+		`fn(...a = []) { a }`,
+		`fn(...[a]) { a }`,
+		`[...xs]`,
+	} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestFunctionStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`fn add(a, b) { a + b }`, "fn add(a, b) (a + b)"},
+		{`fn add(a, b = 1) { a + b };`, "fn add(a, b = 1) (a + b)"},
+		{`export fn f() { 1 }`, "export fn f() 1"},
+		{`fn(x) { x }(1)`, "fn(x) x(1)"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New(`fn add(a) { a } fn sub(a) { a }`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[1].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not *ast.FunctionStatement. got=%T", program.Statements[1])
+	}
+	if stmt.Name.Value != "sub" || stmt.Function.Name != "sub" {
+		t.Errorf("wrong function name. got=%q, %q", stmt.Name.Value, stmt.Function.Name)
+	}
+
+	for _, input := range []string{
+		`fn f { 1 }`,
+		`fn f() 1`,
+		`export fn() { 1 }`,
+	} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestStructStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`struct Point { x, y }`, "struct Point { x, y }"},
+		{`struct Point { x, y, };`, "struct Point { x, y }"},
+		{`struct Unit {}`, "struct Unit {  }"},
+		{`export struct Point { x }`, "export struct Point { x }"},
+		{`p.x = 1`, "((p.x) = 1)"},
+		{`p.x = q.y = a + 1`, "((p.x) = ((q.y) = (a + 1)))"},
+		{`a.b.c = f(1) == 2`, "(((a.b).c) = (f(1) == 2))"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New(`struct Point { x, y }`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt, ok := program.Statements[0].(*ast.StructStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.StructStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Name.Value != "Point" || len(stmt.Fields) != 2 || stmt.Fields[1].Value != "y" {
+		t.Errorf("wrong struct. got=%s", stmt.String())
+	}
+
+	tests = []struct {
+		input    string
+		expected string
+	}{
+		{`struct Point { x, x }`, "duplicate field x in struct Point"},
+		{`struct { x }`, "expected next totken to be IDENT, got { instead"},
+		{`x = 1`, "cannot assign to x"},
+		{`a[0] = 1`, "cannot assign to (a[0])"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("wrong parser errors for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`p.norm()`, "p.norm()"},
+		{`p.scale(2, ...xs)`, "p.scale(2, ...xs)"},
+		{`a.b.c(1).d`, "((a.b).c(1).d)"},
+		{`"abc".upper()`, "abc.upper()"},
+		{`impl Point { fn norm(self) { self.x } }`, "impl Point { fn Point.norm(self) (self.x) }"},
+		{`impl Point { fn a(self) { 1 }; fn b(self, k) { k } }`, "impl Point { fn Point.a(self) 1 fn Point.b(self, k) k }"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New(`p.norm(1)`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	call, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MethodCallExpression)
+	if !ok {
+		t.Fatalf("expression is not *ast.MethodCallExpression. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	if call.Receiver.String() != "p" || call.Method.Value != "norm" || len(call.Arguments) != 1 {
+		t.Errorf("wrong method call. got=%s", call.String())
+	}
+
+	tests = []struct {
+		input    string
+		expected string
+	}{
+		{`impl Point { fn norm() { 1 } }`, "method Point.norm must take the receiver as its first parameter"},
+		{`impl Point { fn (self) { 1 } }`, "expected next totken to be IDENT, got ( instead"},
+		{`impl Point { let x = 1; }`, "expected next totken to be FUNCTION, got LET instead"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("wrong parser errors for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := `[1, 2 * 2, 3 + 3]`
 	l := lexer.New(input)
@@ -506,6 +922,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a.b.c * e.message",
+			"(((a.b).c) * (e.message))",
+		},
+		{
+			"f(x).kind[0]",
+			"((f(x).kind)[0])",
+		},
 	}
 
 	for _, tt := range tests {
@@ -825,6 +1249,27 @@ func TestParsingHashLiteralstringKeys(t *testing.T) {
 	}
 }
 
+func TestParsingHashLiteralKeyOrder(t *testing.T) {
+	input := `{"b": 1, "a": 2, 3: 3}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	hash, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+
+	if len(hash.Keys) != len(hash.Pairs) {
+		t.Fatalf("hash.Keys has wrong length. got=%d", len(hash.Keys))
+	}
+	if hash.String() != "{b:1, a:2, 3:3}" {
+		t.Errorf("hash.String() wrong. got=%q", hash.String())
+	}
+}
+
 func TestParsingEmptyHashLiteral(t *testing.T) {
 	input := "{}"
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"monkey/compiler"
+	"monkey/module"
 	"monkey/object"
 	"monkey/vm"
 
@@ -24,6 +25,11 @@ func Start(in io.Reader, out io.Writer) {
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
 	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	// importはカレントディレクトリから探す
+	loader := module.NewLoader()
 
 	for {
 		fmt.Print(PROMPT)
@@ -43,12 +49,18 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		comp := compiler.NewWithState(symbolTable, constants)
+		next := symbolTable.Clone()
+		comp := compiler.NewWithState(next, constants)
+		comp.SetLoader(loader)
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
 		}
+		symbolTable = next
+		for _, w := range comp.Warnings() {
+			fmt.Fprintf(out, "warning: %s\n", w)
+		}
 
 		machine := vm.NewWithGlobalStore(comp.Bytecode(), globals)
 		err = machine.Run()
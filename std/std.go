@@ -0,0 +1,10 @@
+// Package std Monkeyで書かれた標準ライブラリのソース
+// import "std/list" のように"std/" + ファイル名(拡張子なし)でimportする
+package std
+
+import "embed"
+
+// FS 標準ライブラリのモジュールのソース
+//
+//go:embed *.monkey
+var FS embed.FS
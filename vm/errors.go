@@ -0,0 +1,57 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"monkey/object"
+)
+
+// ErrStackOverflow スタックまたはフレームが上限に達した
+var ErrStackOverflow = object.ErrStackOverflow
+
+// maxTraceFrames RuntimeErrorのトレースに含める最大フレーム数
+const maxTraceFrames = 16
+
+// RuntimeError VM実行時のエラー. エラー発生時点のコールスタックを保持する
+type RuntimeError struct {
+	Err   error
+	Trace []string // 内側のフレームから順に並ぶ
+}
+
+func (e *RuntimeError) Error() string {
+	var out bytes.Buffer
+	out.WriteString(e.Err.Error())
+	for _, t := range e.Trace {
+		out.WriteString("\n\tat ")
+		out.WriteString(t)
+	}
+	return out.String()
+}
+
+// Unwrap errors.Is/errors.Asで元のエラーを判定できるようにする
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// isFatal catchできないエラー. 実行制限の超過とstack overflowはRuntimeErrorとして生成される
+func isFatal(err error) bool {
+	var runtimeErr *RuntimeError
+	return errors.As(err, &runtimeErr)
+}
+
+// exceptionValue catchブロックへ渡す値. 実行時エラーはERRORオブジェクトとして渡す
+func exceptionValue(err error) object.Object {
+	var exception *object.Exception
+	if errors.As(err, &exception) {
+		return exception.Value
+	}
+	return &object.Error{Kind: object.KindRuntimeError, Message: err.Error()}
+}
+
+// completion finallyブロックの後に再開するthrowまたはreturn. スクリプトからは参照できない
+type completion struct {
+	err         error
+	returnValue object.Object
+}
+
+func (c *completion) Type() object.ObjectType { return "COMPLETION" }
+
+func (c *completion) Inspect() string { return "completion" }
@@ -9,10 +9,18 @@ import (
 // CompiledFunctionとip(instruction point)をプロパティに持つ
 // monkeyの実装では関数のみにFrameを使用する
 // basePointer: stack上でのフレームの開始位置を記憶する
+// handlers: 実行中のtry式のハンドラ. 内側のtry式が末尾に並ぶ
 type Frame struct {
 	cl          *object.Closure
 	ip          int
 	basePointer int
+	handlers    []activeHandler
+}
+
+// activeHandler OpTryで登録したハンドラ. 例外が起きた場合はspをOpTryの時点へ戻してから移動する
+type activeHandler struct {
+	code.Handler
+	sp int
 }
 
 // NewFrame 新しいFrameを生成する
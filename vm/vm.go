@@ -1,6 +1,8 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"monkey/code"
 	"monkey/compiler"
@@ -16,9 +18,53 @@ const GlobalsSize = 65536
 // MaxFrams The limit number of VM frames
 const MaxFrames = 1024
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+// InitialStackSize スタックの初期サイズ. 不足すると上限まで倍々で拡張する
+const InitialStackSize = 64
+
+// InitialFrames フレーム配列の初期サイズ
+const InitialFrames = 16
+
+// Options VMの設定. ゼロ値のフィールドはデフォルト値を使う
+type Options struct {
+	InitialStackSize int // default: InitialStackSize
+	MaxStackSize     int // default: StackSize
+	MaxFrames        int // default: MaxFrames
+
+	// Limits 命令数・実行時間・メモリ量・contextによる実行制限. Runの開始時点から数える
+	Limits object.Limits
+
+	// Builtins OpGetBuiltinで参照するbuiltin関数. default: object.Builtins
+	// コンパイル時のシンボル表のBuiltinScopeと同じ並びである必要がある
+	Builtins []object.BuiltinDefinition
+	// Globals 既存のグローバル変数ストアを再利用する場合に指定する
+	Globals []object.Object
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxStackSize <= 0 {
+		o.MaxStackSize = StackSize
+	}
+	if o.InitialStackSize <= 0 {
+		o.InitialStackSize = InitialStackSize
+	}
+	if o.InitialStackSize > o.MaxStackSize {
+		o.InitialStackSize = o.MaxStackSize
+	}
+	if o.MaxFrames <= 0 {
+		o.MaxFrames = MaxFrames
+	}
+	if o.Builtins == nil {
+		o.Builtins = object.Builtins
+	}
+	if o.Globals == nil {
+		o.Globals = []object.Object{}
+	}
+	return o
+}
+
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
 
 // VM Virtual Machine
 type VM struct {
@@ -31,37 +77,50 @@ type VM struct {
 	// Frames
 	frames      []*Frame
 	framesIndex int
+
+	options Options
+	budget  *object.Budget
+	caller  *builtinCaller
 }
 
 // New constructor for VM
 // main frame: main関数を参照するフレーム
 func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithOptions(bytecode, Options{})
+}
+
+// NewWithOptions スタック・フレームの上限を指定してVMを生成する
+// スタックとフレームは必要になった時点で上限まで拡張される
+func NewWithOptions(bytecode *compiler.Bytecode, options Options) *VM {
+	options = options.withDefaults()
 
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Handlers: bytecode.Handlers}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, 1, InitialFrames)
 	frames[0] = mainFrame
 
-	return &VM{
+	vm := &VM{
 		constants: bytecode.Constants,
 		// Stack
-		stack: make([]object.Object, StackSize),
+		stack: make([]object.Object, options.InitialStackSize),
 		sp:    0,
 		// Global
-		globals: make([]object.Object, GlobalsSize),
+		globals: options.Globals,
 		// Frame
 		frames:      frames,
 		framesIndex: 1,
+
+		options: options,
 	}
+	vm.caller = &builtinCaller{vm: vm}
+	return vm
 }
 
 // NewWithGlobalStore Reusing an existing global store.
 func NewWithGlobalStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
-	vm := New(bytecode)
-	vm.globals = s
-	return vm
+	return NewWithOptions(bytecode, Options{Globals: s})
 }
 
 // StackTop スタックの一番上の要素を返す
@@ -77,14 +136,90 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
+// RunContext ctxがキャンセルされた時点で実行を中断する
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.options.Limits.Context = ctx
+	return vm.Run()
+}
+
 // Run バイトコード処理実行
+// Options.Limitsを超えた場合はobject.ErrInstructionLimit, object.ErrTimeout,
+// object.ErrMemoryLimitまたはcontextのエラーをラップしたRuntimeErrorを返す
 func (vm *VM) Run() error {
+	vm.budget = object.NewBudget(vm.options.Limits)
+	return vm.run(0)
+}
+
+// Call Monkeyの関数(クロージャ・builtin関数)をargsで呼び出し, 戻り値を返す
+// builtin関数の実行中(Run中)にも, Runの後にホストのGoコードからも呼び出せる
+// Runの前に呼び出した場合はOptions.Limitsの予算で実行する
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	if vm.budget == nil {
+		vm.budget = object.NewBudget(vm.options.Limits)
+	}
+
+	sp := vm.sp
+	err := vm.push(fn)
+	for i := 0; err == nil && i < len(args); i++ {
+		err = vm.push(args[i])
+	}
+	if err == nil {
+		err = vm.call(len(args))
+	}
+	if err != nil {
+		vm.sp = sp
+		return nil, err
+	}
+	return vm.pop(), nil
+}
+
+// call Stack上の関数を呼び出し, 戻り値がStackへpushされるまで実行する
+// エラーの場合は呼び出した関数のフレームを取り除き, 呼び出し元のフレームへ戻す
+func (vm *VM) call(numArgs int) error {
+	framesIndex := vm.framesIndex
+	err := vm.executeCall(numArgs)
+	if err == nil && vm.framesIndex != framesIndex {
+		// builtin関数は呼び出しの時点で戻り値がpushされている
+		err = vm.run(framesIndex)
+	}
+	if err != nil {
+		vm.framesIndex = framesIndex
+		return err
+	}
+	return nil
+}
+
+// run フレーム数がminFramesに戻るまで(main関数ではInstructionsの終わりまで)命令を実行する
+// 例外はminFramesより内側のフレームのハンドラでcatchし, 実行を続ける
+func (vm *VM) run(minFrames int) error {
+	for {
+		err := vm.execute(minFrames)
+		if err == nil || isFatal(err) {
+			return err
+		}
+		var exception *object.Exception
+		if !errors.As(err, &exception) {
+			// 実行時エラーはRuntimeErrorの種類のERRORとして投げる
+			err = vm.throw(&object.Error{Kind: object.KindRuntimeError, Message: err.Error()})
+		}
+		if !vm.handleException(err, minFrames) {
+			return err
+		}
+	}
+}
+
+// execute runの本体. エラーが起きた時点で中断する
+func (vm *VM) execute(minFrames int) error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
 	// curretFrameのInstructionsを全てfetchするまでループする
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	for vm.framesIndex > minFrames && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if err := vm.budget.Step(); err != nil {
+			return vm.newRuntimeError(err)
+		}
+
 		vm.currentFrame().ip++
 
 		ip = vm.currentFrame().ip
@@ -101,7 +236,10 @@ func (vm *VM) Run() error {
 			}
 			vm.currentFrame().ip += 2
 		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
-			vm.executeBinaryOperation(op)
+			err := vm.executeBinaryOperation(op)
+			if err != nil {
+				return err
+			}
 		case code.OpTrue:
 			err := vm.push(True)
 			if err != nil {
@@ -118,7 +256,11 @@ func (vm *VM) Run() error {
 
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements // Stackに上の各配列要素が、一つの配列オブジェクトとなるので要素分spを減らす
-			err := vm.push(array)
+			err := vm.alloc(array)
+			if err != nil {
+				return err
+			}
+			err = vm.push(array)
 			if err != nil {
 				return err
 			}
@@ -131,6 +273,10 @@ func (vm *VM) Run() error {
 				return err
 			}
 			vm.sp = vm.sp - numElements
+			err = vm.alloc(hash)
+			if err != nil {
+				return err
+			}
 			err = vm.push(hash)
 			if err != nil {
 				return err
@@ -144,6 +290,45 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpGetMethod:
+			name := vm.pop().(*object.String).Value
+			receiver := vm.pop()
+
+			method, err := object.FindMethod(receiver, name)
+			if err != nil {
+				return err
+			}
+			err = vm.push(method)
+			if err != nil {
+				return err
+			}
+		case code.OpImpl:
+			methods := vm.pop().(*object.Hash)
+			target := vm.pop()
+
+			def, ok := target.(*object.StructType)
+			if !ok {
+				return fmt.Errorf("cannot impl methods for %s", target.Type())
+			}
+			for _, pair := range methods.Pairs() {
+				def.Impl(pair.Key.(*object.String).Value, pair.Value)
+			}
+		case code.OpSetField:
+			value := vm.pop()
+			field := vm.pop().(*object.String).Value
+			target := vm.pop()
+
+			s, ok := target.(*object.Struct)
+			if !ok {
+				return fmt.Errorf("cannot assign field %s of %s", field, target.Type())
+			}
+			if err := s.SetField(field, value); err != nil {
+				return err
+			}
+			err := vm.push(value)
+			if err != nil {
+				return err
+			}
 		case code.OpNull:
 			err := vm.push(Null)
 			if err != nil {
@@ -177,12 +362,18 @@ func (vm *VM) Run() error {
 				vm.currentFrame().ip = pos - 1
 			}
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
+			globalIndex := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
+			if globalIndex >= len(vm.globals) {
+				vm.growGlobals(globalIndex + 1)
+			}
 			vm.globals[globalIndex] = vm.pop()
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(ins[ip+1:])
+			globalIndex := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
+			if globalIndex >= len(vm.globals) {
+				vm.growGlobals(globalIndex + 1)
+			}
 			err := vm.push(vm.globals[globalIndex])
 			if err != nil {
 				return err
@@ -211,6 +402,28 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSpread:
+			value := vm.pop()
+			array, ok := value.(*object.Array)
+			if !ok {
+				return fmt.Errorf("cannot spread %s as arguments", value.Type())
+			}
+			err := vm.push(&spread{array: array})
+			if err != nil {
+				return err
+			}
+		case code.OpCallSpread:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip++
+
+			n, err := vm.expandSpread(int(numArgs))
+			if err != nil {
+				return err
+			}
+			err = vm.executeCall(n)
+			if err != nil {
+				return err
+			}
 		case code.OpClosure:
 			constIndex := code.ReadUint16(ins[ip+1:])
 			numFree := code.ReadUint8(ins[ip+3:])
@@ -223,7 +436,7 @@ func (vm *VM) Run() error {
 			builtinIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip++
 
-			definition := object.Builtins[builtinIndex]
+			definition := vm.options.Builtins[builtinIndex]
 
 			err := vm.push(definition.Builtin)
 			if err != nil {
@@ -233,10 +446,52 @@ func (vm *VM) Run() error {
 			freeIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip++
 			currentClosure := vm.currentFrame().cl
+			if names := currentClosure.Fn.FreeNames; int(freeIndex) < len(names) &&
+				(int(freeIndex) >= len(currentClosure.Free) || currentClosure.Free[freeIndex] == nil) {
+				// 関数宣言を, 参照する変数が定義されるより前に呼び出した
+				return fmt.Errorf("identifier not found: %s", names[freeIndex])
+			}
 			err := vm.push(currentClosure.Free[freeIndex])
 			if err != nil {
 				return err
 			}
+		case code.OpCurrentClosure:
+			err := vm.push(vm.currentFrame().cl)
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			freeIndex := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip++
+
+			closure := vm.stack[vm.sp-2].(*object.Closure)
+			if freeIndex >= len(closure.Free) {
+				free := make([]object.Object, freeIndex+1)
+				copy(free, closure.Free)
+				closure.Free = free
+			}
+			closure.Free[freeIndex] = vm.stack[vm.sp-1]
+			vm.sp = vm.sp - 2
+		case code.OpImport:
+			slot := int(code.ReadUint16(ins[ip+1:]))
+			initIndex := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			if slot < len(vm.globals) && vm.globals[slot] != nil {
+				err := vm.push(vm.globals[slot])
+				if err != nil {
+					return err
+				}
+				break
+			}
+			// 初期化関数がexportのハッシュをglobals[slot]へ保存してから返す
+			err := vm.pushClosure(initIndex, 0)
+			if err == nil {
+				err = vm.executeCall(0)
+			}
+			if err != nil {
+				return err
+			}
 		case code.OpReturnValue:
 			//        bottom                                 top
 			// stack: | ... | CompiledFunction | Return Value |
@@ -245,19 +500,123 @@ func (vm *VM) Run() error {
 			// 3. ReturnValueをStackへpushして戻す
 			// stack: | ... | Return Value |
 			returnValue := vm.pop()
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
-
-			err := vm.push(returnValue)
+			err := vm.executeReturn(returnValue)
 			if err != nil {
 				return err
 			}
 		case code.OpReturn:
 			// Return値がない場合はNullを返す(monkeyの仕様)
-			frame := vm.popFrame()
-			vm.sp = frame.basePointer - 1
+			err := vm.executeReturn(Null)
+			if err != nil {
+				return err
+			}
+		case code.OpTry:
+			handler := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
 
-			err := vm.push(Null)
+			frame := vm.currentFrame()
+			frame.handlers = append(frame.handlers, activeHandler{
+				Handler: frame.cl.Fn.Handlers[handler],
+				sp:      vm.sp,
+			})
+		case code.OpEndTry:
+			frame := vm.currentFrame()
+			frame.handlers = frame.handlers[:len(frame.handlers)-1]
+		case code.OpThrow:
+			return vm.throw(vm.pop())
+		case code.OpEndFinally:
+			// try・catchの値であればそのまま残す
+			c, ok := vm.StackTop().(*completion)
+			if !ok {
+				break
+			}
+			vm.pop()
+			if c.err != nil {
+				return c.err
+			}
+			err := vm.executeReturn(c.returnValue)
+			if err != nil {
+				return err
+			}
+		case code.OpMatchValue:
+			pattern := vm.pop()
+			value := vm.pop()
+			err := vm.push(nativeBoolToBoolean(object.Equals(pattern, value)))
+			if err != nil {
+				return err
+			}
+		case code.OpMatchArray:
+			length := int(code.ReadUint16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			array, ok := vm.pop().(*object.Array)
+			matched := ok && (len(array.Elements) == length || rest && len(array.Elements) > length)
+			err := vm.push(nativeBoolToBoolean(matched))
+			if err != nil {
+				return err
+			}
+		case code.OpMatchHash:
+			_, ok := vm.pop().(*object.Hash)
+			err := vm.push(nativeBoolToBoolean(ok))
+			if err != nil {
+				return err
+			}
+		case code.OpMatchKey:
+			key := vm.pop()
+			hash := vm.pop().(*object.Hash)
+			hashKey, _ := object.AsHashable(key)
+			_, ok := hash.Get(hashKey)
+			err := vm.push(nativeBoolToBoolean(ok))
+			if err != nil {
+				return err
+			}
+		case code.OpJumpTable:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			table := vm.constants[constIndex].(*object.JumpTable)
+			pos := table.Default
+			if key, ok := object.AsHashable(vm.pop()); ok {
+				if target, ok := table.Lookup(key); ok {
+					pos = target
+				}
+			}
+			vm.currentFrame().ip = pos - 1
+		case code.OpNoMatch:
+			return fmt.Errorf("no match for value: %s", vm.pop().Inspect())
+		case code.OpCheckArray:
+			length := int(code.ReadUint16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			err := checkArrayShape(vm.pop(), length, rest)
+			if err != nil {
+				return err
+			}
+		case code.OpCheckHash:
+			value := vm.pop()
+			if _, ok := value.(*object.Hash); !ok {
+				return fmt.Errorf("cannot destructure %s as HASH", value.Type())
+			}
+		case code.OpCheckKey:
+			key := vm.pop()
+			hash := vm.pop().(*object.Hash)
+			hashKey, _ := object.AsHashable(key)
+			if _, ok := hash.Get(hashKey); !ok {
+				return fmt.Errorf("cannot destructure HASH: missing key %s", key.Inspect())
+			}
+		case code.OpRest:
+			start := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.pop().(*object.Array)
+			rest := &object.Array{Elements: append([]object.Object{}, array.Elements[start:]...)}
+			err := vm.alloc(rest)
+			if err != nil {
+				return err
+			}
+			err = vm.push(rest)
 			if err != nil {
 				return err
 			}
@@ -268,10 +627,67 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// executeReturn 現在のフレームから戻る. 実行中のtry式にfinallyがあれば, 先にfinallyブロックへ移動する
+func (vm *VM) executeReturn(returnValue object.Object) error {
+	frame := vm.currentFrame()
+	for len(frame.handlers) > 0 {
+		h := frame.handlers[len(frame.handlers)-1]
+		frame.handlers = frame.handlers[:len(frame.handlers)-1]
+		if h.Finally >= 0 {
+			vm.sp = h.sp
+			frame.ip = h.Finally - 1
+			return vm.push(&completion{returnValue: returnValue})
+		}
+	}
+
+	//        bottom                                 top
+	// stack: | ... | CompiledFunction | Return Value |
+	vm.popFrame()
+	vm.sp = frame.basePointer - 1
+	return vm.push(returnValue)
+}
+
+// handleException errをcatchするハンドラ(またはfinallyブロック)を内側のフレームから探し, そこへ移動する
+// ハンドラが無い場合とcatchできないエラーの場合はfalseを返す
+func (vm *VM) handleException(err error, minFrames int) bool {
+	if isFatal(err) {
+		return false
+	}
+	for i := vm.framesIndex - 1; i >= minFrames; i-- {
+		frame := vm.frames[i]
+		n := len(frame.handlers)
+		if n == 0 {
+			continue
+		}
+
+		// ハンドラの無い内側のフレームは捨てる
+		vm.framesIndex = i + 1
+		h := frame.handlers[n-1]
+		vm.sp = h.sp
+		if h.Catch >= 0 {
+			if h.Finally >= 0 {
+				// catchブロックの例外とreturnのためにfinallyだけ残す
+				frame.handlers[n-1].Catch = -1
+			} else {
+				frame.handlers = frame.handlers[:n-1]
+			}
+			frame.ip = h.Catch - 1
+			return vm.push(exceptionValue(err)) == nil
+		}
+		frame.handlers = frame.handlers[:n-1]
+		frame.ip = h.Finally - 1
+		return vm.push(&completion{err: err}) == nil
+	}
+	return false
+}
+
 // Stack操作
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp >= len(vm.stack) {
+		err := vm.growStack(vm.sp + 1)
+		if err != nil {
+			return err
+		}
 	}
 
 	vm.stack[vm.sp] = o
@@ -280,6 +696,45 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// growStack スタックを少なくともsize要素まで拡張する. 上限を超える場合はstack overflow
+func (vm *VM) growStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+	if size > vm.options.MaxStackSize {
+		return vm.newRuntimeError(ErrStackOverflow)
+	}
+
+	newSize := len(vm.stack) * 2
+	if newSize < size {
+		newSize = size
+	}
+	if newSize > vm.options.MaxStackSize {
+		newSize = vm.options.MaxStackSize
+	}
+
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+// growGlobals globalsを少なくともsize要素まで拡張する
+// NewWithGlobalStoreで渡されたストアが十分な大きさであれば拡張されない
+func (vm *VM) growGlobals(size int) {
+	newSize := len(vm.globals) * 2
+	if newSize < size {
+		newSize = size
+	}
+	if newSize > GlobalsSize {
+		newSize = GlobalsSize
+	}
+
+	globals := make([]object.Object, newSize)
+	copy(globals, vm.globals)
+	vm.globals = globals
+}
+
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
 	vm.sp--
@@ -291,9 +746,18 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= vm.options.MaxFrames {
+		return vm.newRuntimeError(ErrStackOverflow)
+	}
+
+	if vm.framesIndex < len(vm.frames) {
+		vm.frames[vm.framesIndex] = f
+	} else {
+		vm.frames = append(vm.frames, f)
+	}
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -332,6 +796,9 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
@@ -347,7 +814,12 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
 
-	return vm.push(&object.String{Value: leftValue + rightValue})
+	result := &object.String{Value: leftValue + rightValue}
+	err := vm.alloc(result)
+	if err != nil {
+		return err
+	}
+	return vm.push(result)
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
@@ -360,13 +832,16 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.exectueIntegerComparison(op, left, right)
 	}
+	if op == code.OpGreaterThan && left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		// "a" < "b" は右辺と左辺を入れ替えたOpGreaterThanにコンパイルされる
+		return vm.push(nativeBoolToBoolean(left.(*object.String).Value > right.(*object.String).Value))
+	}
 
 	switch op {
 	case code.OpEqual:
-		// Boolean型はTrue, Falseへの参照なので、参照同士の比較で問題ない
-		return vm.push(nativeBoolToBoolean(right == left))
+		return vm.push(nativeBoolToBoolean(object.Equals(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBoolean(right != left))
+		return vm.push(nativeBoolToBoolean(!object.Equals(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
 	}
@@ -419,11 +894,33 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 		return vm.executeArrayIndex(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.ERROR_OBJ && index.Type() == object.STRING_OBJ:
+		return vm.push(left.(*object.Error).Field(index.(*object.String).Value))
+	case left.Type() == object.STRUCT_OBJ && index.Type() == object.STRING_OBJ:
+		value, err := left.(*object.Struct).Field(index.(*object.String).Value)
+		if err != nil {
+			return err
+		}
+		return vm.push(value)
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
 }
 
+// checkArrayShape valueが分割代入できる長さの配列でなければエラーを返す
+func checkArrayShape(value object.Object, length int, rest bool) error {
+	array, ok := value.(*object.Array)
+	switch {
+	case !ok:
+		return fmt.Errorf("cannot destructure %s as ARRAY", value.Type())
+	case !rest && len(array.Elements) != length:
+		return fmt.Errorf("cannot destructure ARRAY of length %d into %d elements", len(array.Elements), length)
+	case rest && len(array.Elements) < length:
+		return fmt.Errorf("cannot destructure ARRAY of length %d into at least %d elements", len(array.Elements), length)
+	}
+	return nil
+}
+
 func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	arrayObject := array.(*object.Array)
 	i := index.(*object.Integer).Value
@@ -436,16 +933,16 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
 		return fmt.Errorf("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return vm.push(Null)
 	}
-	return vm.push(pair.Value)
+	return vm.push(value)
 }
 
 func (vm *VM) executeCall(numArgs int) error {
@@ -455,11 +952,44 @@ func (vm *VM) executeCall(numArgs int) error {
 		return vm.callClosure(callee, numArgs)
 	case *object.Builtin:
 		return vm.callBuiltin(callee, numArgs)
+	case *object.StructType:
+		return vm.callStructType(callee, numArgs)
+	case *object.BoundMethod:
+		return vm.callBoundMethod(callee, numArgs)
 	default:
 		return fmt.Errorf("calling non-function and non-built-in")
 	}
 }
 
+// spread OpSpreadで展開を予約した引数の配列. スクリプトからは参照できない
+type spread struct {
+	array *object.Array
+}
+
+func (s *spread) Type() object.ObjectType { return "SPREAD" }
+
+func (s *spread) Inspect() string { return "..." + s.array.Inspect() }
+
+// expandSpread Stack上のnumArgs個の引数のうちOpSpreadの値を配列の要素に置き換え, 展開後の引数の数を返す
+func (vm *VM) expandSpread(numArgs int) (int, error) {
+	var args []object.Object
+	for _, arg := range vm.stack[vm.sp-numArgs : vm.sp] {
+		if s, ok := arg.(*spread); ok {
+			args = append(args, s.array.Elements...)
+		} else {
+			args = append(args, arg)
+		}
+	}
+	vm.sp -= numArgs
+	for _, arg := range args {
+		err := vm.push(arg)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(args), nil
+}
+
 func nativeBoolToBoolean(b bool) object.Object {
 	if b {
 		return True
@@ -468,12 +998,7 @@ func nativeBoolToBoolean(b bool) object.Object {
 }
 
 func isTruthy(obj object.Object) bool {
-	switch obj := obj.(type) {
-	case *object.Boolean:
-		return obj.Value
-	default:
-		return true
-	}
+	return object.IsTruthy(obj)
 }
 
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
@@ -485,34 +1010,79 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash((endIndex - startIndex) / 2)
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
 
-		pair := object.HashPair{Key: key, Value: value}
-
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
 
-		hashedPairs[hashKey.HashKey()] = pair
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+	result := builtin.Invoke(vm.caller, args...)
 	vm.sp = vm.sp - numArgs - 1
-	if result != nil {
-		vm.push(result)
-	} else {
-		vm.push(Null)
+	if result == nil {
+		return vm.push(Null)
 	}
-	return nil
+	if errObj, ok := result.(*object.Error); ok {
+		if errObj.Fatal != nil {
+			// builtin関数から呼び出したMonkeyの関数の実行時エラー・例外
+			return errObj.Fatal
+		}
+		if errObj.Failure {
+			// builtin関数の失敗は投げる. それ以外のERROR(error()の戻り値など)は値として返す
+			errObj.Failure = false
+			return vm.throw(errObj)
+		}
+	}
+
+	if !builtin.Borrows {
+		err := vm.alloc(result)
+		if err != nil {
+			return err
+		}
+	}
+	return vm.push(result)
+}
+
+// callBoundMethod レシーバを第1引数としてStackに挿入し, メソッドを呼び出す
+//
+//	stack: | BoundMethod | arg1 | arg2 |  ->  | Method | Receiver | arg1 | arg2 |
+func (vm *VM) callBoundMethod(bm *object.BoundMethod, numArgs int) error {
+	err := vm.growStack(vm.sp + 1)
+	if err != nil {
+		return err
+	}
+	base := vm.sp - numArgs
+	copy(vm.stack[base+1:vm.sp+1], vm.stack[base:vm.sp])
+	vm.stack[base] = bm.Receiver
+	vm.stack[base-1] = bm.Method
+	vm.sp++
+	return vm.executeCall(numArgs + 1)
+}
+
+// callStructType 構造体の型を呼び出し, 引数でフィールドを初期化したSTRUCTをpushする
+func (vm *VM) callStructType(def *object.StructType, numArgs int) error {
+	s, err := def.New(vm.stack[vm.sp-numArgs : vm.sp])
+	if err != nil {
+		return err
+	}
+	vm.sp = vm.sp - numArgs - 1
+
+	err = vm.alloc(s)
+	if err != nil {
+		return err
+	}
+	return vm.push(s)
 }
 
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
@@ -520,13 +1090,50 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	// spとCompiledFunction間に引数が存在するので引数の数だけspを差し引いたインデックスを参照する
 	//        bottom                                 top
 	// stack: | ... | CompiledFunction | arg1 | arg2 |
-	if numArgs != cl.Fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	fn := cl.Fn
+	required := fn.NumParameters - fn.NumDefaults
+	if numArgs < required || numArgs > fn.NumParameters && !fn.Rest {
+		return fmt.Errorf("wrong number of arguments: want=%s, got=%d", object.Arity(required, fn.NumParameters, fn.Rest), numArgs)
 	}
 
-	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	basePointer := vm.sp - numArgs
+	entry := 0
+	if numArgs < fn.NumParameters {
+		// 省略された引数はnullにしておき, 関数の中で既定値を計算する
+		for i := numArgs; i < fn.NumParameters; i++ {
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+		}
+		entry = fn.DefaultEntries[numArgs-required]
+	}
+	if fn.Rest {
+		rest := make([]object.Object, vm.sp-basePointer-fn.NumParameters)
+		copy(rest, vm.stack[basePointer+fn.NumParameters:vm.sp])
+		array := &object.Array{Elements: rest}
+		err := vm.alloc(array)
+		if err != nil {
+			return err
+		}
+		vm.sp = basePointer + fn.NumParameters
+		err = vm.push(array)
+		if err != nil {
+			return err
+		}
+	}
+
+	frame := NewFrame(cl, basePointer)
+	frame.ip = entry - 1
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
 	// Point: ローカル変数の数だけ"hole"を用意する
+	err = vm.growStack(frame.basePointer + cl.Fn.NumLocals)
+	if err != nil {
+		return err
+	}
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
 	return nil
@@ -547,5 +1154,76 @@ func (vm *VM) pushClosure(constIndex, numFree int) error {
 	vm.sp = vm.sp - numFree
 
 	closure := &object.Closure{Fn: function, Free: free}
+	err := vm.alloc(closure)
+	if err != nil {
+		return err
+	}
 	return vm.push(closure)
 }
+
+// alloc 生成したオブジェクトのメモリ量をOptions.Limits.MaxMemoryに対して計上する
+func (vm *VM) alloc(obj object.Object) error {
+	err := vm.budget.Alloc(obj)
+	if err != nil {
+		return vm.newRuntimeError(err)
+	}
+	return nil
+}
+
+// newRuntimeError 現在のフレームのトレースを付けたエラーを生成する
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	return &RuntimeError{Err: err, Trace: vm.stackTrace()}
+}
+
+// stackTrace 内側のフレームから順にフレームの情報を返す
+func (vm *VM) stackTrace() []string {
+	var trace []string
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		if len(trace) == maxTraceFrames {
+			trace = append(trace, fmt.Sprintf("... %d more frames", i+1))
+			break
+		}
+		name := vm.frames[i].cl.Inspect()
+		if i == 0 {
+			name = "<main>"
+		}
+		trace = append(trace, fmt.Sprintf("%s (ip=%d)", name, vm.frames[i].ip))
+	}
+	return trace
+}
+
+// throw valueを例外として投げる. Stackを持たないERRORには実行中の関数を記録する
+func (vm *VM) throw(value object.Object) error {
+	if errObj, ok := value.(*object.Error); ok && errObj.Stack == nil {
+		errObj.Stack = vm.callStack()
+	}
+	return &object.Exception{Value: value}
+}
+
+// callStack 内側から順に実行中の関数の名前を返す. ERRORのStackに記録する
+func (vm *VM) callStack() []string {
+	stack := make([]string, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 1; i-- {
+		stack = append(stack, object.StackFrameName(vm.frames[i].cl.Fn.Name))
+	}
+	return append(stack, object.MainFrameName)
+}
+
+// builtinCaller builtin関数からVM上のMonkeyの関数を呼び出す
+// VMの実行時エラーはFatalに設定したErrorとして返し, 呼び出し元のVMの実行を中断させる
+type builtinCaller struct {
+	vm *VM
+}
+
+func (c *builtinCaller) Call(fn object.Object, args ...object.Object) object.Object {
+	result, err := c.vm.Call(fn, args...)
+	if err != nil {
+		return &object.Error{Message: err.Error(), Fatal: err}
+	}
+	return result
+}
+
+// StackTrace error()が生成するERRORのStack
+func (c *builtinCaller) StackTrace() []string {
+	return c.vm.callStack()
+}
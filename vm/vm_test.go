@@ -1,13 +1,20 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"monkey/ast"
 	"monkey/compiler"
 	"monkey/lexer"
+	"monkey/module"
 	"monkey/object"
 	"monkey/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -15,6 +22,9 @@ type vmTestCase struct {
 	expected interface{}
 }
 
+// inspect 期待するInspectの表記
+type inspect string
+
 func TestIntegerArithmetic(t *testing.T) {
 	tests := []vmTestCase{
 		{"1", 1},
@@ -108,6 +118,22 @@ func TestHashLiterals(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []vmTestCase{
+		{`let h = {[1, 2]: "a", [2, 1]: "b"}; h[[1, 2]] + h[[2, 1]]`, "ab"},
+		{`let p = [0, 1]; {[0, 1]: 5}[p]`, 5},
+		{`{[1, [2, "x"]]: 1}[[1, [2, "x"]]]`, 1},
+		{`{[1, 2]: 1}[[1, 2, 3]]`, Null},
+		{`{null: 1}[null]`, 1},
+		{`{null: 1}[false]`, Null},
+		{`{[]: 1}[[]]`, 1},
+		{`{[null]: 1}[[null]]`, 1},
+		{`has({[1, 2]: true}, [1, 2])`, true},
+		{`{[1, 2]: 1, [1, 2]: 2}`, inspect("{[1, 2]: 2}")},
+	}
+	runVMTests(t, tests)
+}
+
 func TestIndexExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"[1, 2, 3][1]", 2},
@@ -123,6 +149,34 @@ func TestIndexExpressions(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestStructuralEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"b" > "abc"`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, [2, "x"]] == [1, [2, "x"]]`, true},
+		{`[1] == [1, 2]`, false},
+		{`[] != []`, false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`{} == {}`, true},
+		{`if (false) { 1 } == if (false) { 2 }`, true},
+		{`if (false) { 1 } == false`, false},
+		{`1 == "1"`, false},
+		{`[1] == 1`, false},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+	}
+	runVMTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -134,6 +188,11 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 > 2) { 10 }", Null},
 		{"if (false) { 10 }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		// nullはfalseと同じく偽. evaluatorと同じ
+		{"if (null) { 10 } else { 20 }", 20},
+		{"let n = if (false) { 1 }; if (n) { 10 } else { 20 }", 20},
+		{"if (0) { 10 } else { 20 }", 10},
 	}
 	runVMTests(t, tests)
 }
@@ -375,6 +434,173 @@ func TestBuiltinFunctions(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`let n = 10; map([1, 2], fn(x) { x + n })`, []int{11, 12}},
+		{`map([], fn(x) { x })`, []int{}},
+		{`map([[1], [2, 3]], len)`, []int{1, 2}},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`filter([1, 2, 3], fn(x) { if (x == 2) { true } })`, []int{2}},
+		{`reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })`, 10},
+		{`reduce([], 5, fn(acc, x) { acc + x })`, 5},
+		{`each([1, 2], fn(x) { x })`, Null},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, 2},
+		{`find([1, 2, 3], fn(x) { x > 5 })`, Null},
+		{`any([1, 2, 3], fn(x) { x == 2 })`, true},
+		{`any([], fn(x) { true })`, false},
+		{`all([1, 2, 3], fn(x) { x > 0 })`, true},
+		{`all([1, 2, 3], fn(x) { x > 1 })`, false},
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`first(sort(["b", "c", "a"]))`, "a"},
+		{`sort_by([3, 1, 2], fn(x) { -x })`, []int{3, 2, 1}},
+		{`sort_by([[2, 1], [1, 2], [2, 3]], first)[2]`, []int{2, 3}},
+		{`zip([1, 2], [3, 4, 5])[1]`, []int{2, 4}},
+		{`len(zip([1, 2], []))`, 0},
+		{`flatten([1, [2, [3, 4]], []])`, []int{1, 2, 3, 4}},
+		{`range(3)`, []int{0, 1, 2}},
+		{`range(1, 4)`, []int{1, 2, 3}},
+		{`range(10, 0, -3)`, []int{10, 7, 4, 1}},
+		{`range(3, 1)`, []int{}},
+		{`map(1, fn(x) { x })`, &object.Error{Message: "argument to `map` must be ARRAY, got INTEGER"}},
+		{`map([1])`, &object.Error{Message: "wrong number of arguments. got=1, want=2"}},
+		{`sort([1, "a"])`, &object.Error{Message: "argument to `sort` has mixed types: INTEGER and STRING"}},
+		{`sort([true])`, &object.Error{Message: "argument to `sort` must be sorted by INTEGER or STRING, got BOOLEAN"}},
+		{`zip([1])`, &object.Error{Message: "wrong number of arguments. got=1, want at least 2"}},
+		{`range(0, 1, 0)`, &object.Error{Message: "`range` step must not be 0"}},
+		{`range(1 - 9223372036854775807, 9223372036854775807)`, &object.Error{Message: "`range` is too large: 18446744073709551613 elements (max 16777216)"}},
+	}
+	runVMTests(t, tests)
+}
+
+func TestCallbackErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1], fn(x) { x + "a" })`, "unsupported types for binary oeperation: INTEGER STRING"},
+		{`filter([1], fn() { true })`, "wrong number of arguments: want=0, got=1"},
+		{`reduce([1], 0, 1)`, "calling non-function and non-built-in"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = New(comp.Bytecode()).Run()
+		if err == nil {
+			t.Errorf("expected VM error but resulted in none.")
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`split("a,b,,c", ",")`, []string{"a", "b", "", "c"}},
+		{`split("abc", "")`, []string{"a", "b", "c"}},
+		{`join(["a", "b"], ", ")`, "a, b"},
+		{`join([1, "b", true], "-")`, "1-b-true"},
+		{`trim("  hi  ")`, "hi"},
+		{`upper("abc")`, "ABC"},
+		{`lower("ABC")`, "abc"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`contains("monkey", "key")`, true},
+		{`contains("monkey", "cat")`, false},
+		{`starts_with("monkey", "mon")`, true},
+		{`ends_with("monkey", "mon")`, false},
+		{`index_of("日本語", "語")`, 2},
+		{`index_of("abc", "x")`, -1},
+		{`substr("日本語です", 1, 2)`, "本語"},
+		{`substr("hello", 3)`, "lo"},
+		{`substr("hello", -1, 100)`, "hello"},
+		{`substr("hello", 10)`, ""},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 0)`, ""},
+		{`pad_left("7", 3, "0")`, "007"},
+		{`pad_right("ab", 5, "xy")`, "abxyx"},
+		{`pad_left("abc", 2)`, "abc"},
+		{`pad_right("a", 3)`, "a  "},
+		{`chars("日本")`, []string{"日", "本"}},
+		{`format("%s has %d items: %v", "cart", 3, [1, 2])`, "cart has 3 items: [1, 2]"},
+		{`format("%t %v", true, if (false) { 1 })`, "true <nil>"},
+		{`upper(1)`, &object.Error{Message: "argument to `upper` must be STRING, got INTEGER"}},
+		{`split("a")`, &object.Error{Message: "wrong number of arguments. got=1, want=2"}},
+		{`repeat("a", -1)`, &object.Error{Message: "`repeat` count must not be negative. got -1"}},
+		{`repeat("ab", 9223372036854775807)`, &object.Error{Message: "`repeat` result is too large (max 16777216 bytes)"}},
+		{`pad_left("a", 3, "")`, &object.Error{Message: "`pad_left` padding must not be empty"}},
+		{`format(1)`, &object.Error{Message: "argument to `format` must be STRING, got INTEGER"}},
+	}
+	runVMTests(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`keys({"b": 1, "a": 2, 3: 3, true: 4})`, inspect("[b, a, 3, true]")},
+		{`values({"b": 1, "a": 2})`, []int{1, 2}},
+		{`entries({"b": 1, "a": 2})[0]`, inspect("[b, 1]")},
+		{`{"b": 1, "a": 2, 1: 3, false: 4}`, inspect("{b: 1, a: 2, 1: 3, false: 4}")},
+		{`{"a": 1, "b": 2, "a": 3}`, inspect("{a: 3, b: 2}")},
+		{`merge({"b": 1, "a": 2}, {"c": 3, "b": 4})`, inspect("{b: 4, a: 2, c: 3}")},
+		{`delete({"a": 1, "b": 2, "c": 3}, "b")`, inspect("{a: 1, c: 3}")},
+		{`from_entries([["z", 1], ["y", 2]])`, inspect("{z: 1, y: 2}")},
+		{`len(entries({}))`, 0},
+		{`from_entries([["a", 1], [2, 3]])[2]`, 3},
+		{`from_entries(entries({"x": 1}))["x"]`, 1},
+		{`has({"a": 1}, "a")`, true},
+		{`has({"a": 1}, "b")`, false},
+		{`get({"a": 1}, "a", 0)`, 1},
+		{`get({"a": 1}, "b", 0)`, 0},
+		{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); len(keys(h)) * 10 + len(keys(d))`, 21},
+		{`delete({"a": 1}, "b")["a"]`, 1},
+		{`let m = merge({"a": 1, "b": 2}, {"b": 3}, {"c": 4}); values(m)`, []int{1, 3, 4}},
+		{`let a = {"a": 1}; merge(a, {"a": 2}); a["a"]`, 1},
+		{`map(entries({"a": 1, "b": 2}), fn(e) { e[1] * 10 })`, []int{10, 20}},
+		{`keys([1])`, &object.Error{Message: "argument to `keys` must be HASH, got ARRAY"}},
+		{`has({}, fn(x) { x })`, &object.Error{Message: "unusable as hash key: CLOSURE"}},
+		{`from_entries([[1]])`, &object.Error{Message: "argument to `from_entries` must be ARRAY of [key, value], got [1]"}},
+		{`merge()`, &object.Error{Message: "wrong number of arguments. got=0, want at least 1"}},
+	}
+	runVMTests(t, tests)
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	// 文字列リテラルに"を書けないので, j(s)で'を"へ置き換える
+	prelude := `let j = fn(s) { replace(s, "'", chars(json_encode(""))[0]) }; `
+	tests := []vmTestCase{
+		{`json_encode({"b": [1, true, null], "a": "x<y"})`, `{"b":[1,true,null],"a":"x<y"}`},
+		{`json_encode([])`, "[]"},
+		{`json_encode({})`, "{}"},
+		{`json_encode([1, {"a": 2}], 2)`, "[\n  1,\n  {\n    \"a\": 2\n  }\n]"},
+		{`json_encode([1], "--")`, "[\n--1\n]"},
+		{`json_decode(j("{'b': 1, 'a': [2, null, false]}"))`, inspect("{b: 1, a: [2, null, false]}")},
+		{`json_decode(j("'hi'"))`, "hi"},
+		{`json_decode(" -42 ")`, -42},
+		{`json_decode(json_encode({"b": {"c": [1]}, "a": "s"}))`, inspect("{b: {c: [1]}, a: s}")},
+		{`json_encode(json_decode(j("{'z': 1, 'y': 2}")))`, `{"z":1,"y":2}`},
+		{`json_decode(j("{'a': 1, 'a': 2}"))`, inspect("{a: 2}")},
+		{`json_encode({1: 2})`, &object.Error{Message: "json_encode: unsupported key type INTEGER (keys must be STRING)"}},
+		{`json_encode(1, -1)`, &object.Error{Message: "json_encode: indent must be between 0 and 16. got -1"}},
+		{`json_decode("[1, 2")`, &object.Error{Message: "json_decode: unexpected end of input (line 1, column 6)"}},
+		{"json_decode(\"[1,\n 2 x]\")", &object.Error{Message: "json_decode: invalid character 'x' after array element (line 2, column 4)"}},
+		{`json_decode("1.5")`, &object.Error{Message: "json_decode: number 1.5 is not a 64-bit integer (line 1, column 1)"}},
+		{`json_decode("1 2")`, &object.Error{Message: "json_decode: unexpected data after top-level value (line 1, column 3)"}},
+		{`json_decode("")`, &object.Error{Message: "json_decode: unexpected end of input (line 1, column 1)"}},
+		{`json_decode(1)`, &object.Error{Message: "argument to `json_decode` must be STRING, got INTEGER"}},
+		{`json_encode({"a": [1, fn(x) { x }]})`, &object.Error{Message: `json_encode: key "a": index 1: unsupported type CLOSURE`}},
+	}
+	for i := range tests {
+		tests[i].input = prelude + tests[i].input
+	}
+	runVMTests(t, tests)
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -476,6 +702,210 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVMTests(t, tests)
 }
 
+func TestStackGrowsOnDemand(t *testing.T) {
+	input := `
+	let sum = fn(x) {
+		if (x == 0) { return 0; }
+		x + sum(x - 1);
+	};
+	sum(200);
+	`
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := NewWithOptions(comp.Bytecode(), Options{InitialStackSize: 1})
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 20100, vm.LastPoppedStackElem())
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		input   string
+		options Options
+	}{
+		{
+			input:   `let f = fn() { f(); }; f();`,
+			options: Options{},
+		},
+		{
+			input:   `let f = fn() { f(); }; f();`,
+			options: Options{MaxFrames: 8},
+		},
+		{
+			input:   `let f = fn(x) { 1 + f(x); }; f(1);`,
+			options: Options{MaxStackSize: 32},
+		},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewWithOptions(comp.Bytecode(), tt.options)
+		err = vm.Run()
+		if !errors.Is(err, ErrStackOverflow) {
+			t.Fatalf("expected stack overflow. got=%v", err)
+		}
+
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError. got=%T", err)
+		}
+		if len(runtimeErr.Trace) == 0 {
+			t.Errorf("runtime error has no trace")
+		}
+		if len(runtimeErr.Trace) > maxTraceFrames+1 {
+			t.Errorf("trace too long. got=%d", len(runtimeErr.Trace))
+		}
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	loop := `let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) } }; f(500);`
+	fib := `
+	let fib = fn(x) { if (x < 2) { x } else { fib(x - 1) + fib(x - 2) } };
+	fib(40);
+	`
+	strings := `let grow = fn(s, n) { if (n == 0) { s } else { grow(s + s, n - 1) } }; grow("ab", 30);`
+	arrays := `let grow = fn(a, n) { if (n == 0) { a } else { grow(push(a, n), n - 1) } }; grow([], 1000);`
+	hashes := `let grow = fn(n) { if (n == 0) { {} } else { {n: grow(n - 1)} } }; grow(100);`
+	// 既存のオブジェクトを返すbuiltin関数の戻り値は計上しない
+	borrowed := `
+	let s = "x".repeat(10000); let a = [s]; let h = {"s": s};
+	let f = fn(n) { if (n == 0) { 0 } else { first(a); a.last(); h.get("s", 0); find(a, fn(x) { true }); reduce(a, s, fn(acc, x) { acc }); f(n - 1) } };
+	f(200);
+	`
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected error
+	}{
+		{loop, object.Limits{MaxInstructions: 100}, object.ErrInstructionLimit},
+		{fib, object.Limits{Timeout: time.Millisecond}, object.ErrTimeout},
+		{fib, object.Limits{Context: canceled}, context.Canceled},
+		{loop, object.Limits{MaxInstructions: 100000, Timeout: time.Minute}, nil},
+		{strings, object.Limits{MaxMemory: 1 << 20}, object.ErrMemoryLimit},
+		{arrays, object.Limits{MaxMemory: 1 << 20}, object.ErrMemoryLimit},
+		{hashes, object.Limits{MaxMemory: 1 << 10}, object.ErrMemoryLimit},
+		{loop, object.Limits{MaxMemory: 1 << 20}, nil},
+		{borrowed, object.Limits{MaxMemory: 1 << 20}, nil},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewWithOptions(comp.Bytecode(), Options{Limits: tt.limits})
+		err = vm.Run()
+		if tt.expected == nil {
+			if err != nil {
+				t.Errorf("vm error: %s", err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("wrong error. want=%v, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse(`let f = fn(x) { if (x < 2) { x } else { f(x - 1) + f(x - 2) } }; f(40);`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	err = New(comp.Bytecode()).RunContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wrong error. want=%v, got=%v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	apply := object.NewCallerBuiltin(2, func(c object.Caller, args ...object.Object) object.Object {
+		return c.Call(args[0], args[1])
+	})
+	symbolTable := compiler.NewSymbolTable()
+	symbolTable.DefineBuiltin(0, "apply")
+
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	err := comp.Compile(parse(`let n = 2; let f = fn(x) { x * n }; apply(f, 21);`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := NewWithOptions(comp.Bytecode(), Options{
+		Builtins: []object.BuiltinDefinition{{Name: "apply", Builtin: apply}},
+	})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := testIntegerObject(42, vm.LastPoppedStackElem()); err != nil {
+		t.Errorf("testIntegerObject failed: %s", err)
+	}
+
+	f := vm.globals[1]
+	result, err := vm.Call(f, &object.Integer{Value: 5})
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := testIntegerObject(10, result); err != nil {
+		t.Errorf("testIntegerObject failed: %s", err)
+	}
+
+	if _, err := vm.Call(f); err == nil {
+		t.Errorf("expected error for wrong number of arguments")
+	}
+	if vm.sp != 0 {
+		t.Errorf("stack is not restored. sp=%d", vm.sp)
+	}
+
+	// 例外を投げた関数のフレームは残らない
+	comp = compiler.New()
+	if err := comp.Compile(parse(`let f = fn(x) { x * 2 }; let g = fn() { throw 1 };`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm = New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	framesIndex := vm.framesIndex
+	for i := 0; i < 2000; i++ {
+		if _, err := vm.Call(vm.globals[1]); err == nil {
+			t.Fatalf("expected error from throwing function")
+		}
+	}
+	if vm.framesIndex != framesIndex || vm.sp != 0 {
+		t.Errorf("frames are not restored. framesIndex=%d, sp=%d", vm.framesIndex, vm.sp)
+	}
+	result, err = vm.Call(vm.globals[0], &object.Integer{Value: 4})
+	if err != nil {
+		t.Fatalf("vm error after failed calls: %s", err)
+	}
+	if err := testIntegerObject(8, result); err != nil {
+		t.Errorf("testIntegerObject failed: %s", err)
+	}
+}
+
 func runVMTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
@@ -488,6 +918,12 @@ func runVMTests(t *testing.T, tests []vmTestCase) {
 		}
 		vm := New(comp.Bytecode())
 		err = vm.Run()
+		// catchされなかった例外(builtin関数のエラーを含む)はRunのエラーになる
+		var exception *object.Exception
+		if _, ok := tt.expected.(*object.Error); ok && errors.As(err, &exception) {
+			testExpectedObject(t, tt.expected, &object.Error{Message: exception.Error()})
+			continue
+		}
 		if err != nil {
 			t.Fatalf("vm error: %s", err)
 		}
@@ -532,20 +968,40 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
+	case inspect:
+		if actual.Inspect() != string(expected) {
+			t.Errorf("wrong object. want=%s, got=%s", expected, actual.Inspect())
+		}
+	case []string:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			err := testStringObject(expectedElem, array.Elements[i])
+			if err != nil {
+				t.Errorf("testStringObject failed: %s", err)
+			}
+		}
 	case map[object.HashKey]int64:
 		hash, ok := actual.(*object.Hash)
 		if !ok {
 			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
 			return
 		}
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("hash hash wrong number of pairs. got=%d,want=%d", len(expected), len(hash.Pairs))
+		if hash.Len() != len(expected) {
+			t.Errorf("hash hash wrong number of pairs. got=%d,want=%d", len(expected), hash.Len())
 			return
 		}
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.Pairs() {
+			expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 			if !ok {
-				t.Errorf("no pair for given key in Pairs")
+				t.Errorf("unexpected key in Pairs: %s", pair.Key.Inspect())
 			}
 			err := testIntegerObject(expectedValue, pair.Value)
 			if err != nil {
@@ -613,3 +1069,458 @@ func testBooleanObject(expected bool, actual object.Object) error {
 
 	return nil
 }
+
+// writeModules dirにモジュールのファイルを作成する. key: dirからの相対パス
+func writeModules(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var testModules = map[string]string{
+	"math.monkey": `
+		let square = fn(x) { x * x };
+		let hidden = 10;
+		export let cube = fn(x) { square(x) * x };
+		export let base = hidden + 1;
+	`,
+	"ns.monkey": `
+		let x = 1;
+		export let getX = fn() { x };
+	`,
+	"lib/text.monkey": `
+		let helper = import "./helper";
+		export let greet = fn(name) { helper["prefix"] + name };
+	`,
+	"lib/helper.monkey": `export let prefix = upper("hi ");`,
+	"cycle/a.monkey":    `import "./b"; export let a = 1;`,
+	"cycle/b.monkey":    `import "./a"; export let b = 2;`,
+	"nested.monkey":     `let f = fn() { export let x = 1; }; f();`,
+	"funcs.monkey":      `export fn double(n) { twice(n) } fn twice(n) { n * 2 }`,
+	"shapes.monkey":     `export struct Point { x, y }; export fn origin() { Point(0, 0) }`,
+	"broken.monkey":     `let x 1;`,
+}
+
+func TestModules(t *testing.T) {
+	dir := t.TempDir()
+	writeModules(t, dir, testModules)
+	loader := module.NewLoader(filepath.Join(dir, "lib"))
+	loader.BaseDir = dir
+
+	tests := []vmTestCase{
+		{`let m = import "./math"; m["cube"](3) + m["base"]`, 38},
+		{`keys(import "./math.monkey")`, []string{"cube", "base"}},
+		{`let x = 2; let m = import "./ns"; m["getX"]() * 10 + x`, 12},
+		{`let a = import "./math"; let b = import "./math"; a["base"] + b["base"]`, 22},
+		{`(import "text")["greet"]("monkey")`, "HI monkey"},
+		{`let f = fn() { import "./math" }; f()["base"]`, 11},
+		{`let m = import "./funcs"; [keys(m), m["double"](4)]`, inspect(`[[double], 8]`)},
+		{`let s = import "./shapes"; let p = s.origin(); p.x = 5; [type(p), p.x, s.Point(1, 2)]`, inspect(`[Point, 5, Point{x: 1, y: 2}]`)},
+	}
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetLoader(loader)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "./math"; square(1)`, "undefined variable square"},
+		{`import "./missing"`, `cannot find module "./missing"`},
+		{`import "./cycle/a"`, fmt.Sprintf("module %[2]s: import cycle: %[1]s -> %[2]s -> %[1]s",
+			filepath.Join(dir, "cycle/a.monkey"), filepath.Join(dir, "cycle/b.monkey"))},
+		{`import "./nested"`, fmt.Sprintf("module %s: export is only allowed at the top level: x", filepath.Join(dir, "nested.monkey"))},
+		{`import "./broken"`, fmt.Sprintf("parser errors in %s:\n\texpected next totken to be =, got INT instead", filepath.Join(dir, "broken.monkey"))},
+	}
+	for _, tt := range errorTests {
+		comp := compiler.New()
+		comp.SetLoader(loader)
+		err := comp.Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: wrong compiler error. got=%v, want=%q", tt.input, err, tt.expected)
+		}
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(`import "./math"`)); err == nil || err.Error() != `cannot import "./math": no module loader` {
+		t.Errorf("wrong compiler error without loader. got=%v", err)
+	}
+}
+
+func TestStdLibrary(t *testing.T) {
+	tests := []vmTestCase{
+		{`let m = import "std/math"; [m["abs"](-3), m["pow"](2, 10), m["gcd"](12, 18), m["lcm"](4, 6), m["isqrt"](99), m["mod"](-7, 3)]`, []int{3, 1024, 6, 12, 9, -1}},
+		{`let m = import "std/math"; [m["clamp"](15, 0, 10), m["sign"](-5), m["min"](2, 1), m["max"](2, 1)]`, []int{10, -1, 1, 2}},
+		{`let l = import "std/list"; [l["sum"]([1, 2, 3]), l["product"]([2, 3, 4]), l["max"]([3, 9, 2]), l["min"]([3, 9, 2])]`, []int{6, 24, 9, 2}},
+		{`(import "std/list")["max"]([])`, nil},
+		{`(import "std/list")["reverse"]([1, 2, 3])`, []int{3, 2, 1}},
+		{`let l = import "std/list"; [l["take"]([1, 2, 3], 2), l["drop"]([1, 2, 3], 2), l["take"]([1], 5)]`, inspect("[[1, 2], [3], [1]]")},
+		{`(import "std/list")["unique"]([1, 2, 1, 3, 2])`, []int{1, 2, 3}},
+		{`(import "std/list")["chunk"]([1, 2, 3, 4, 5], 2)`, inspect("[[1, 2], [3, 4], [5]]")},
+		{`(import "std/list")["group_by"]([1, 2, 3, 4], fn(x) { x > 2 })`, inspect("{false: [1, 2], true: [3, 4]}")},
+		{`(import "std/string")["reverse"]("monkey")`, "yeknom"},
+		{`(import "std/string")["title"]("  hello   monkey world ")`, "Hello Monkey World"},
+		{`(import "std/string")["count"]("banana", "an")`, 2},
+		{`(import "std/string")["truncate"]("monkey", 3, "...")`, "mon..."},
+		{`(import "std/string")["center"]("ab", 6, "*")`, "**ab**"},
+		// 起動時にコンパイルしたモジュールを, 既存のグローバル変数・定数の後ろへ取り込む
+		{`let x = 7; let s = "a"; let l = import "std/list"; let m = import "std/math"; [x, s, l["sum"]([1, 2]), m["abs"](-4)]`, inspect("[7, a, 3, 4]")},
+		{`let s = import "std/string"; let l = import "std/list"; [s["reverse"]("ab"), l["reverse"]([1, 2])]`, inspect("[ba, [2, 1]]")},
+	}
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetLoader(module.Std)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+
+	comp := compiler.New()
+	comp.SetLoader(module.Std)
+	if err := comp.Compile(parse(`import "std/missing"`)); err == nil || err.Error() != `cannot find module "std/missing"` {
+		t.Errorf("wrong compiler error. got=%v", err)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []vmTestCase{
+		{`try { throw 1; 2 } catch (e) { e + 10 }`, 11},
+		{`try { 5 } catch (e) { 0 }`, 5},
+		{`1 + try { throw 2 } catch (e) { e }`, 3},
+		{`try { throw 1 } catch (e) { }`, Null},
+		{`try { 1[0] } catch (e) { e }`, &object.Error{Message: "index operator not supported: INTEGER"}},
+		{`try { len(1) } catch (e) { e }`, &object.Error{Message: "argument to `len` not supported. got INTEGER"}},
+		{`1 / 0`, &object.Error{Message: "division by zero"}},
+		{`let d = 0; try { 1 / d } catch (e) { [e.kind, e.message] }`, []string{"RuntimeError", "division by zero"}},
+		{`let f = fn() { throw "boom" }; let g = fn() { f() + 1 }; try { g() } catch (e) { e }`, "boom"},
+		{`let f = fn() { try { throw 3 } catch (e) { e * 2 } }; f()`, 6},
+		{`try { map([1, 2, 3], fn(x) { if (x == 2) { throw x * 10 }; x }) } catch (e) { e }`, 20},
+		{`map([1, 2], fn(x) { try { throw x } catch (e) { e * 10 } })`, []int{10, 20}},
+		{`try { try { throw 1 } catch (e) { throw e + 1 } } catch (e) { e }`, 2},
+		{`try { try { len(1) } catch (e) { throw e } } catch (e) { e }`, &object.Error{Message: "argument to `len` not supported. got INTEGER"}},
+		{`try { if (len(1)) { 2 } } catch (e) { 3 }`, 3},
+		// catchしたERRORやerror()の値をbuiltin関数が返しても, 投げずに値として扱う
+		{`let e = try { len(1) } catch (x) { x }; try { first([e]); 1 } catch (x) { 2 }`, 1},
+		{`let e = error("x"); try { first([e]).message } catch (x) { "thrown" }`, "x"},
+		// catchの変数はcatchブロックの中だけで参照でき, 外側の同名の変数を上書きしない
+		{`let e = 5; try { throw "x" } catch (e) { e }; e`, 5},
+		{`let e = 5; try { throw "x" } catch (e) { e }`, "x"},
+		{`let e = 5; try { throw 1 } catch (e) { let k = e + 1 }; [e, k]`, []int{5, 2}},
+		{`let e = 5; try { throw 1 } catch (e) { let e = 2 }; e`, 5},
+		{`let f = fn() { let e = 5; let g = try { throw 1 } catch (e) { fn() { e } }; [e, g()] }; f()`, []int{5, 1}},
+		// finallyはtry・catchの後に評価され, 値は捨てられる
+		{`let a = [1]; try { let b = push(a, 2); throw b } catch (e) { let c = push(e, 3) } finally { let d = push(c, 4) }; d`, []int{1, 2, 3, 4}},
+		{`try { 1 } finally { 2 }`, 1},
+		{`try { try { throw "x" } finally { let seen = true } } catch (e) { if (seen) { e } else { "no" } }`, "x"},
+		// returnの前にもfinallyを評価する
+		{`let f = fn() { try { return 1 } finally { throw "finally" } }; try { f() } catch (e) { e }`, "finally"},
+		{`let f = fn() { try { return 1 } finally { 2 }; 3 }; f()`, 1},
+		{`let f = fn() { try { try { return 1 } finally { 2 } } finally { throw 3 } }; try { f() } catch (e) { e }`, 3},
+		{`let f = fn() { try { throw 1 } catch (e) { return e + 1 } finally { 0 }; 0 }; f()`, 2},
+		{`throw 5`, &object.Error{Message: "uncaught exception: 5"}},
+		{`throw len(1)`, &object.Error{Message: "argument to `len` not supported. got INTEGER"}},
+		{`try { throw "a" } finally { 1 }`, &object.Error{Message: "uncaught exception: a"}},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestUncatchableErrors(t *testing.T) {
+	loop := `let f = fn(x) { f(x + 1) }; try { f(0) } catch (e) { 0 }`
+	tests := []struct {
+		limits   object.Limits
+		expected error
+	}{
+		{object.Limits{MaxInstructions: 100}, object.ErrInstructionLimit},
+		{object.Limits{}, ErrStackOverflow},
+	}
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(loop)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewWithOptions(comp.Bytecode(), Options{Limits: tt.limits})
+		if err := vm.Run(); !errors.Is(err, tt.expected) {
+			t.Errorf("wrong error. want=%v, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []vmTestCase{
+		{`let e = error("not found"); [e.message, e.kind]`, []string{"not found", "Error"}},
+		{`let e = error("not found", {"kind": "NotFound", "code": 404}); [e.kind, e["message"]]`, []string{"NotFound", "not found"}},
+		{`error("x", {"code": 404}).code`, 404},
+		{`error("x", {"code": 404}).data["code"]`, 404},
+		{`error("x").data`, Null},
+		{`error("x").missing`, Null},
+		{`let inner = error("io", {"kind": "IOError"}); error("load", {"cause": inner}).cause.message`, "io"},
+		{`let inner = error("io", {"kind": "IOError"}); is_error(error("load", {"cause": inner}), "IOError")`, true},
+		{`let inner = error("io", {"kind": "IOError"}); is_error(error("load", {"cause": inner}), "Error")`, true},
+		{`let inner = error("io", {"kind": "IOError"}); is_error(error("load", {"cause": inner}), "TypeError")`, false},
+		{`is_error(error("x"))`, true},
+		{`is_error(1)`, false},
+		{`is_error(1, "Error")`, false},
+		{`try { len(1) } catch (e) { e.kind }`, "TypeError"},
+		{`try { len(1, 2) } catch (e) { e.kind }`, "ArgumentError"},
+		{`try { 1[0] } catch (e) { e.kind }`, "RuntimeError"},
+		{`try { throw error("boom", {"kind": "Custom"}) } catch (e) { [e.kind, e.message] }`, []string{"Custom", "boom"}},
+		{`let f = fn() { error("x") }; f().stack`, []string{"<fn>", "<main>"}},
+		{`error("x").stack`, []string{"<main>"}},
+		{`let f = fn() { 1[0] }; try { f() } catch (e) { e.stack }`, []string{"<fn>", "<main>"}},
+		{`let f = fn() { len(1) }; let g = fn() { f() }; try { g() } catch (e) { e.stack }`, []string{"<fn>", "<fn>", "<main>"}},
+		// error()の戻り値は値として扱い, builtin関数を通しても投げない
+		{`first([error("a")]).message`, "a"},
+		{`map([1, 2], fn(x) { error("e") })[1].message`, "e"},
+		{`let e = error("a"); if (is_error(e)) { 1 } else { 2 }`, 1},
+		{`let h = {"name": "monkey"}; h.name`, "monkey"},
+		{`{"a": {"b": 2}}.a.b`, 2},
+		{`error(1)`, &object.Error{Message: "argument to `error` must be STRING, got INTEGER"}},
+		{`error("x", {"kind": 1})`, &object.Error{Message: "`error` kind must be a non-empty STRING, got 1"}},
+		{`1.message`, &object.Error{Message: "index operator not supported: INTEGER"}},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`match 1 { 0 => "zero", 1 => "one", _ => "many" }`, "one"},
+		{`match 5 { 0 => "zero", 1 => "one", _ => "many" }`, "many"},
+		{`match -1 { -1 => "minus", _ => "other" }`, "minus"},
+		{`match "b" { "a" => 1, "b" => 2, "c" => 3 }`, 2},
+		{`match true { false => 0, true => 1 }`, 1},
+		// パターンで束縛した変数はアームの中だけで参照でき, 外側の変数を上書きしない
+		{`let x = 5; match 3 { x => x }`, 3},
+		{`let x = 5; match 3 { x => x }; x`, 5},
+		{`let x = 5; match [1, 2] { [x, y] if x > 0 => x + y }; x`, 5},
+		{`let x = 5; match 1 { 1 => 10, 2 => 20, x => x }; x`, 5},
+		{`let x = 5; match 3 { 1 => 10, 2 => 20, x => x }`, 3},
+		{`let f = fn(x) { match [x] { [x] => x * 2 }; x }; f(4)`, 4},
+		{`struct N { v }; match 1 { N => 1 }; type(N(2))`, "N"},
+		{`let f = match 2 { x => fn() { x } }; f()`, 2},
+		{`match null { null => 1, _ => 2 }`, 1},
+		// 型が違えば同じ値でもマッチしない
+		{`match "1" { 1 => "int", "1" => "string" }`, "string"},
+		{`match 1 { true => 0, _ => 1 }`, 1},
+		{`match 7 { n => n * 2 }`, 14},
+		{`match 3 { n if n > 5 => "big", n if n > 1 => "mid", _ => "small" }`, "mid"},
+		{`match [1, 2] { [] => 0, [x] => x, [x, y] => x + y, _ => -1 }`, 3},
+		{`match [1, 2, 3] { [x, y] => x + y, _ => -1 }`, -1},
+		{`match [1, [2, 3]] { [1, [a, b]] => a * b, _ => 0 }`, 6},
+		{`match [0, 5] { [0, _] => "origin x", _ => "other" }`, "origin x"},
+		{`match {"type": "a", "v": 10} { {"type": "b", "v": v} => v, {"type": "a", "v": v} => v + 1, _ => 0 }`, 11},
+		{`match {"x": 1} { {"x": 1, "y": y} => y, {"x": x} => x * 100 }`, 100},
+		{`match {"v": null} { {"v": v} => "has v", _ => "no v" }`, "has v"},
+		{`match 1 { [x] => x, {"x": x} => x, _ => "scalar" }`, "scalar"},
+		{`let f = fn(x) { match x { 0 => "zero", 1 => "one", n => n } }; [f(0), f(1), f(2)]`, inspect("[zero, one, 2]")},
+		{`let f = fn(x) { match x { [a, b] if a == b => a, [a, b] => { let s = a + b; s * 10 } } }; f([1, 2])`, 30},
+		{`let f = fn(x) { match x { 1 => { return "early"; 0 }, _ => "late" } }; f(1)`, "early"},
+		{`let x = 2; match x + 1 { 3 => { }, _ => 1 }`, Null},
+		{`1 + match 2 { 2 => 3, _ => 0 }`, 4},
+		{`match 3 { 1 => 10, 2 => 20 }`, &object.Error{Message: "no match for value: 3"}},
+		{`match 3 { 1 => 10, n if n < 0 => 20 }`, &object.Error{Message: "no match for value: 3"}},
+		{`try { match [1] { [] => 0 } } catch (e) { e.kind }`, "RuntimeError"},
+		{`match 1 { 1 => len(1), _ => 0 }`, &object.Error{Message: "argument to `len` not supported. got INTEGER"}},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []vmTestCase{
+		{`let [a, b] = [1, 2]; a + b`, 3},
+		{`let [a, [b, c]] = [1, [2, 3]]; a * b * c`, 6},
+		{`let [a, _, c] = [1, 2, 3]; [a, c]`, []int{1, 3}},
+		{`let [first, ...rest] = [1, 2, 3]; rest`, []int{2, 3}},
+		{`let [a, b, ...rest] = [1, 2]; rest`, []int{}},
+		{`let [...all] = [1, 2]; all`, []int{1, 2}},
+		{`let {name, age} = {"name": "monkey", "age": 3, "x": 0}; name`, "monkey"},
+		{`let {"age": years} = {"name": "monkey", "age": 3}; years`, 3},
+		{`let {"items": [x, y]} = {"items": [4, 5]}; x * y`, 20},
+		{`let x = 1; let [x, y] = [x + 1, x + 2]; [x, y]`, []int{2, 3}},
+		{`let f = fn([a, b], c) { a + b + c }; f([1, 2], 3)`, 6},
+		{`let f = fn({name}, [x, ...xs]) { [name, x, len(xs)] }; f({"name": "a"}, [1, 2, 3])`, inspect("[a, 1, 2]")},
+		{`map([[1, 2], [3, 4]], fn([a, b]) { a * b })`, []int{2, 12}},
+		{`let swap = fn([a, b]) { [b, a] }; swap([1, 2])`, []int{2, 1}},
+		{`match [1, 2, 3] { [x, ...xs] => xs, _ => 0 }`, []int{2, 3}},
+		{`match [] { [x, ...xs] => xs, _ => 0 }`, 0},
+		{`match {"name": "m", "age": 1} { {name} => name }`, "m"},
+		{`let [a, b] = 1;`, &object.Error{Message: "cannot destructure INTEGER as ARRAY"}},
+		{`let [a, b] = [1];`, &object.Error{Message: "cannot destructure ARRAY of length 1 into 2 elements"}},
+		{`let [a, b] = [1, 2, 3];`, &object.Error{Message: "cannot destructure ARRAY of length 3 into 2 elements"}},
+		{`let [a, b, ...c] = [1];`, &object.Error{Message: "cannot destructure ARRAY of length 1 into at least 2 elements"}},
+		{`let {name} = [1];`, &object.Error{Message: "cannot destructure ARRAY as HASH"}},
+		{`let {name} = {"age": 1};`, &object.Error{Message: "cannot destructure HASH: missing key name"}},
+		{`let f = fn([a]) { a }; f(1)`, &object.Error{Message: "cannot destructure INTEGER as ARRAY"}},
+		{`try { let {a} = {}; a } catch (e) { e.kind }`, "RuntimeError"},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestFunctionParameters(t *testing.T) {
+	tests := []vmTestCase{
+		{`let f = fn(a, b = 10) { a + b }; [f(1), f(1, 2)]`, []int{11, 3}},
+		{`let f = fn(a = 1, b = a + 1) { [a, b] }; f()`, []int{1, 2}},
+		{`let f = fn(a = 1, b = a + 1) { [a, b] }; f(5)`, []int{5, 6}},
+		{`let f = fn(a, b = 2, c = 3) { [a, b, c] }; f(9, 8)`, []int{9, 8, 3}},
+		{`let n = 100; let f = fn(a = n) { let n = 1; a + n }; f()`, 101},
+		{`let f = fn(a = b, b = 1) { a }; f()`, Null},
+		{`let f = fn(xs = [], x = len(xs)) { x }; f([1, 2])`, 2},
+		{`let f = fn([a, b] = [1, 2]) { a + b }; [f(), f([3, 4])]`, []int{3, 7}},
+		{`let f = fn(a, ...rest) { rest }; f(1, 2, 3)`, []int{2, 3}},
+		{`let f = fn(a, ...rest) { rest }; f(1)`, []int{}},
+		{`let f = fn(...all) { len(all) }; [f(), f(1, 2, 3)]`, []int{0, 3}},
+		{`let f = fn(a, b = 2, ...rest) { [a, b, len(rest)] }; [f(1), f(1, 5, 6, 7)]`, inspect("[[1, 2, 0], [1, 5, 2]]")},
+		{`let add = fn(a, b, c) { a + b + c }; let args = [1, 2, 3]; add(...args)`, 6},
+		{`let add = fn(a, b, c) { a + b + c }; add(1, ...[2], 3)`, 6},
+		{`let add = fn(a, b, c) { a + b + c }; add(...[1], ...[2, 3])`, 6},
+		{`let f = fn(...xs) { xs }; f(0, ...[1, 2], ...[], 3)`, []int{0, 1, 2, 3}},
+		{`len(...["abc"])`, 3},
+		{`push(...[[1], 2])`, []int{1, 2}},
+		{`let sum = fn(...xs) { reduce(xs, 0, fn(acc, x) { acc + x }) }; sum(...range(5))`, 10},
+		{`let f = fn(a, b = 1) { a }; f()`, &object.Error{Message: "wrong number of arguments: want=1..2, got=0"}},
+		{`let f = fn(a, b = 1) { a }; f(1, 2, 3)`, &object.Error{Message: "wrong number of arguments: want=1..2, got=3"}},
+		{`let f = fn(a, ...rest) { a }; f()`, &object.Error{Message: "wrong number of arguments: want=at least 1, got=0"}},
+		{`let f = fn(a) { a }; f(...[1, 2])`, &object.Error{Message: "wrong number of arguments: want=1, got=2"}},
+		{`let f = fn(a) { a }; f(...1)`, &object.Error{Message: "cannot spread INTEGER as arguments"}},
+		{`let f = fn(a = len(1)) { a }; try { f() } catch (e) { e.kind }`, "TypeError"},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []vmTestCase{
+		{`fn add(a, b) { a + b }; add(1, 2)`, 3},
+		{`let x = double(2); fn double(n) { n * 2 }; x`, 4},
+		{`fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } } fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } } isEven(10)`, true},
+		{`fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } } fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } } isOdd(10)`, false},
+		{`let f = fn() { fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } } fact(5) }; f()`, 120},
+		{`let x = 1; let y = f(); fn f() { x + 1 }; y`, 2},
+		{`let f = fn() { let x = 2; fn g() { x * 3 } g() }; f()`, 6},
+		{`let make = fn(n) { fn add(x) { x + n } add }; make(2)(3)`, 5},
+		{`let f = fn() { fn g(n) { if (n == 0) { 0 } else { fn() { g(n - 1) }() } } g(3) }; f()`, 0},
+		{`fn f(f) { f }; f(3)`, 3},
+		{`if (true) { fn g() { 1 } g() }`, 1},
+		// 関数の中の宣言もブロックの先頭へ巻き上げる
+		{`let f = fn() { let r = h(); fn h() { 1 } r }; f()`, 1},
+		{`let f = fn(n) { fn a(n) { if (n == 0) { "a" } else { b(n - 1) } } fn b(n) { if (n == 0) { "b" } else { a(n - 1) } } a(n) }; f(3)`, "b"},
+		{`let f = fn(k) { fn a(n) { if (n == 0) { k } else { b(n - 1) } } fn b(n) { a(n) } a(3) }; f(7)`, 7},
+		{`let f = fn() { 1; fn g() { 2 } }; f()`, Null},
+		// ifの分岐の最後の文が宣言の場合, 分岐の値はnull
+		{`if (true) { fn z() { 1 } } z()`, 1},
+		{`if (true) { fn z() { 1 } }`, Null},
+		{`if (false) { 1 } else { fn z() { 2 } }`, Null},
+		{`fn() { if (true) { fn z() { 1 } } }()`, Null},
+		{`fn() { let k = 3; if (true) { fn z() { k } } }()`, Null},
+		// 関数の中の変数を使う宣言も宣言より前に呼び出せ, 呼び出した時点の変数の値を参照する
+		{`let f = fn() { let x = 1; let r = g(); fn g() { x } r }; f()`, 1},
+		{`fn() { let r = a(3); fn a(n) { if (n == 0) { "a" } else { b(n - 1) } } fn b(n) { if (n == 0) { "b" } else { a(n - 1) } } r }()`, "b"},
+		{`let f = fn() { let x = 1; let a = g(); let x = 2; fn g() { x } [a, g()] }; f()`, []int{1, 2}},
+		{`let f = fn(x) { let r = match 1 { x => g() }; fn g() { x } r }; f(5)`, 5},
+		{`let f = fn() { let x = 5; let r = try { throw 1 } catch (x) { g() }; fn g() { x } r }; f()`, 5},
+		{`let f = fn() { let p = {"x": 3}; let r = g(); fn g() { p.x + len([p]) } r }; f()`, 4},
+		{`let f = fn() { let r = g(); let x = 1; fn g() { x } r }; f()`, &object.Error{Message: "identifier not found: x"}},
+		{`fn f() { len(1) } fn g() { f() } try { g() } catch (e) { e.stack }`, []string{"f", "g", "<main>"}},
+		{`let h = fn() { error("x") }; fn f() { h() }; f().stack`, []string{"<fn>", "f", "<main>"}},
+	}
+
+	runVMTests(t, tests)
+
+	program := parse(`fn add(a, b) { a + b }; add`)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := vm.LastPoppedStackElem().Inspect(); got != "Closure[add]" {
+		t.Errorf("wrong Inspect. want=%q, got=%q", "Closure[add]", got)
+	}
+}
+
+func TestStructs(t *testing.T) {
+	tests := []vmTestCase{
+		{`struct Point { x, y }; let p = Point(1, 2); p.x + p.y`, 3},
+		{`struct Point { x, y }; let p = Point(1, 2); p.x = 10; p.x`, 10},
+		{`struct Point { x, y }; let p = Point(1, 2); p.y = 5`, 5},
+		{`struct Point { x, y }; let p = Point(1, 2); let q = p; q.y = 5; p.y`, 5},
+		{`struct Point { x, y }; let p = Point(1, 2); p.x = p.y = 7; [p.x, p.y]`, []int{7, 7}},
+		{`struct Point { x, y }; Point(1, 2)["y"]`, 2},
+		{`struct Line { from, to }; struct Point { x, y }; let l = Line(Point(0, 0), Point(3, 4)); l.to.y`, 4},
+		{`struct Line { from, to }; struct Point { x, y }; let l = Line(Point(0, 0), Point(3, 4)); l.to.y = 9; l.to`, inspect("Point{x: 3, y: 9}")},
+		{`struct Unit {}; Unit()`, inspect("Unit{}")},
+		{`struct Point { x, y }; Point`, inspect("struct Point { x, y }")},
+		{`struct Point { x, y }; type(Point(1, 2))`, "Point"},
+		{`struct Point { x, y }; [type(1), type("a"), type([]), type({}), type(null), type(Point)]`, []string{"INTEGER", "STRING", "ARRAY", "HASH", "NULL", "STRUCT_TYPE"}},
+		{`struct Point { x, y }; Point(1, 2) == Point(1, 2)`, true},
+		{`struct Point { x, y }; Point(1, 2) == Point(1, 3)`, false},
+		{`struct Point { x, y }; struct Vec { x, y }; Point(1, 2) == Vec(1, 2)`, false},
+		{`struct N { v }; let n = N(1); n.v = n; n == n`, true},
+		{`struct N { v }; let n = N(1); n.v = n; n`, inspect("N{v: <cycle>}")},
+		{`struct Box { value }; map([1, 2], Box)[1].value`, 2},
+		{`let h = {"name": "monkey", "age": 3}; h.name`, "monkey"},
+		{`let h = {"name": "monkey"}; h.age`, Null},
+		{`struct Point { x, y }; Point(1)`, &object.Error{Message: "wrong number of arguments: want=2, got=1"}},
+		{`struct Point { x, y }; Point(1, 2).z`, &object.Error{Message: "Point has no field z"}},
+		{`struct Point { x, y }; let p = Point(1, 2); p.z = 1`, &object.Error{Message: "Point has no field z"}},
+		{`let h = {"x": 1}; h.x = 2`, &object.Error{Message: "cannot assign field x of HASH"}},
+		{`struct Point { x, y }; try { Point(1, 2).z } catch (e) { e.kind }`, "RuntimeError"},
+	}
+
+	runVMTests(t, tests)
+}
+
+func TestMethods(t *testing.T) {
+	tests := []vmTestCase{
+		{`struct Point { x, y }; impl Point { fn sum(self) { self.x + self.y } }; Point(1, 2).sum()`, 3},
+		{`struct Point { x, y }; impl Point { fn sum(self) { self.x + self.y } fn scale(self, k) { Point(self.x * k, self.y * k) } }; Point(1, 2).scale(3).sum()`, 9},
+		{`struct Counter { n }; impl Counter { fn inc(self, by = 1) { self.n = self.n + by; self } }; let c = Counter(0); c.inc().inc(5); c.n`, 6},
+		{`struct Point { x, y }; impl Point { fn doubleX(self) { self.x * 2 } }; impl Point { fn doubleY(self) { self.y * 2 } }; let p = Point(1, 2); [p.doubleX(), p.doubleY()]`, []int{2, 4}},
+		{`fn area(r) { r.area() } struct Rect { w, h } impl Rect { fn area(self) { self.w * self.h } } area(Rect(2, 3))`, 6},
+		{`struct Button { onClick }; Button(fn(x) { x * 2 }).onClick(4)`, 8},
+		{`struct Vec { xs }; impl Vec { fn count(self, ...more) { len(self.xs) * 10 + len(more) } }; Vec([1, 2]).count(...[3, 4])`, 22},
+		{`"abc".upper()`, "ABC"},
+		{`"x".repeat(3).len()`, 3},
+		{`[1, 2, 3].map(fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`[3, 1, 2].sort().first()`, 1},
+		{`"a,b,c".split(",").join("-")`, "a-b-c"},
+		{`[1, 2].push(...[3])`, []int{1, 2, 3}},
+		{`{"a": 1, "b": 2}.keys()`, []string{"a", "b"}},
+		{`let h = {"double": fn(x) { x * 2 }}; h.double(4)`, 8},
+		{`let n = 1; n.foo()`, &object.Error{Message: "INTEGER has no method foo"}},
+		{`[1].upper()`, &object.Error{Message: "ARRAY has no method upper"}},
+		{`struct P { x }; P(1).foo()`, &object.Error{Message: "P has no method foo"}},
+		{`struct P { x }; impl P { fn f(self) { 1 } }; P(1).f(2)`, &object.Error{Message: "wrong number of arguments: want=1, got=2"}},
+		{`let x = 1; impl x { fn f(self) { 1 } }`, &object.Error{Message: "cannot impl methods for INTEGER"}},
+		{`struct P { x }; impl P { fn bad(self) { len(self.x) } }; try { P(1).bad() } catch (e) { e.stack }`, []string{"P.bad", "<main>"}},
+	}
+
+	runVMTests(t, tests)
+}